@@ -0,0 +1,549 @@
+// Package asm converts bytecode to a textual assembly and back.
+//
+// A listing is made of directives, each on its own line, and of the
+// instructions of the unit opened by the last directive:
+//
+//	.constant 0 integer 42
+//	.constant 1 string "hello"
+//	.function 2 locals=1 params=1 free=0 name=identity
+//	  0000 OpGetLocal 0
+//	  0002 OpReturn
+//	.global 0 answer
+//	.main
+//	  0000 OpConstant 0
+//	  0003 OpSetGlobal 0
+//
+// Constants are numbered from 0 without gaps; their type is integer, float,
+// string (quoted as in Go), boolean or null. A function is a constant too,
+// followed by its instructions. Its `free` count is the number of free
+// variables of the closures built from it, and must agree with the OpClosure
+// instructions. Its `name`, used in errors, is optional. The main instructions come after `.main`. `.global` names a
+// global in the debug section, which is only written if there is at least
+// one of them.
+//
+// An instruction is its opcode name and its operands. It may be preceded by
+// its offset, which is then checked, and by labels such as `loop:`. Jump
+// operands can be labels of the same unit. An instruction is wide when it is
+// written after `OpWide`, or when its operands need it. Everything after a
+// `;` is a comment.
+package asm
+
+import (
+	"bufio"
+	"fmt"
+	"gold/code"
+	"gold/cold"
+	"gold/compiler"
+	"gold/object"
+	"strconv"
+	"strings"
+)
+
+// Error is returned by Assemble with the line of the problem.
+type Error struct {
+	Line    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// Disassemble lists every constant, function and the main instructions of
+// the bytecode, and the global names of debug if it isn't nil.
+func Disassemble(bytecode *compiler.Bytecode, debug *cold.Debug) string {
+	var out strings.Builder
+
+	numFree := map[int]int{}
+	units := []code.Instructions{bytecode.Instructions}
+	for _, constant := range bytecode.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			units = append(units, fn.Instructions)
+		}
+	}
+	for _, ins := range units {
+		for _, closure := range closures(ins) {
+			numFree[closure[0]] = closure[1]
+		}
+	}
+
+	for i, constant := range bytecode.Constants {
+		switch constant := constant.(type) {
+		case *object.Integer:
+			fmt.Fprintf(&out, ".constant %d integer %d\n", i, constant.Value)
+		case *object.Float:
+			fmt.Fprintf(&out, ".constant %d float %s\n", i, strconv.FormatFloat(constant.Value, 'g', -1, 64))
+		case *object.String:
+			fmt.Fprintf(&out, ".constant %d string %s\n", i, strconv.Quote(constant.Value))
+		case *object.Boolean:
+			fmt.Fprintf(&out, ".constant %d boolean %t\n", i, constant.Value)
+		case *object.Null:
+			fmt.Fprintf(&out, ".constant %d null\n", i)
+		case *object.CompiledFunction:
+			fmt.Fprintf(&out, ".function %d locals=%d params=%d free=%d", i, constant.NumLocals, constant.NumParameters, numFree[i])
+			if constant.Name != "" {
+				fmt.Fprintf(&out, " name=%s", constant.Name)
+			}
+			out.WriteString("\n")
+			writeInstructions(&out, constant.Instructions)
+		default:
+			fmt.Fprintf(&out, "; constant %d of type %s can't be listed\n", i, constant.Type())
+		}
+	}
+
+	if debug != nil {
+		for i, name := range debug.Globals {
+			if name != "" {
+				fmt.Fprintf(&out, ".global %d %s\n", i, name)
+			}
+		}
+	}
+
+	out.WriteString(".main\n")
+	writeInstructions(&out, bytecode.Instructions)
+
+	return out.String()
+}
+
+func writeInstructions(out *strings.Builder, ins code.Instructions) {
+	scanner := bufio.NewScanner(strings.NewReader(ins.String()))
+	for scanner.Scan() {
+		out.WriteString("  " + scanner.Text() + "\n")
+	}
+}
+
+// closures returns the constant index and the number of free variables of
+// every OpClosure of ins.
+func closures(ins code.Instructions) [][2]int {
+	var found [][2]int
+	for i := 0; i < len(ins); {
+		instruction, err := code.ReadInstruction(ins[i:])
+		if err != nil {
+			break
+		}
+		if instruction.Op == code.OpClosure {
+			found = append(found, [2]int{instruction.Operands[0], instruction.Operands[1]})
+		}
+		i += instruction.Width
+	}
+	return found
+}
+
+// unit is the function or main program being assembled.
+type unit struct {
+	instructions code.Instructions
+	labels       map[string]int
+	// fixups are the jump operands written before their label was known
+	fixups []fixup
+	// numFree is the free count of a function, or -1 when it is unknown
+	numFree int
+	// line is where the unit starts
+	line int
+}
+
+type fixup struct {
+	position int
+	wide     bool
+	label    string
+	line     int
+}
+
+type global struct {
+	name string
+	line int
+}
+
+type assembler struct {
+	bytecode *compiler.Bytecode
+	debug    *cold.Debug
+	globals  map[int]global
+
+	// units are aligned with the constants, main is apart
+	units   []*unit
+	current *unit
+	main    *unit
+
+	lineNumber int
+}
+
+// Assemble parses a listing in the format written by Disassemble. The debug
+// information is nil when the listing names no global.
+func Assemble(src string) (*compiler.Bytecode, *cold.Debug, error) {
+	a := &assembler{bytecode: &compiler.Bytecode{Constants: []object.Object{}}}
+
+	for i, text := range strings.Split(src, "\n") {
+		a.lineNumber = i + 1
+		err := a.line(text)
+		if err != nil {
+			return nil, nil, &Error{Line: i + 1, Message: err.Error()}
+		}
+	}
+
+	if a.main == nil {
+		return nil, nil, &Error{Line: 1, Message: "missing .main"}
+	}
+
+	for _, u := range append(a.units, a.main) {
+		for _, f := range u.fixups {
+			target, ok := u.labels[f.label]
+			if !ok {
+				return nil, nil, &Error{Line: f.line, Message: fmt.Sprintf("undefined label %q", f.label)}
+			}
+			if f.wide {
+				copy(u.instructions[f.position:], code.MakeWide(code.OpJump, target)[2:])
+			} else if code.Fits(code.OpJump, target) {
+				copy(u.instructions[f.position:], code.Make(code.OpJump, target)[1:])
+			} else {
+				return nil, nil, &Error{Line: f.line, Message: fmt.Sprintf("label %q is too far, use OpWide", f.label)}
+			}
+		}
+	}
+
+	for i, constant := range a.bytecode.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			fn.Instructions = a.units[i].instructions
+		}
+	}
+	a.bytecode.Instructions = a.main.instructions
+
+	err := a.checkClosures()
+	if err != nil {
+		return nil, nil, err
+	}
+	err = a.buildDebug()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return a.bytecode, a.debug, nil
+}
+
+func (a *assembler) line(text string) error {
+	fields, err := split(text)
+	if err != nil {
+		return err
+	}
+
+	// Labels can precede anything, even nothing
+	for len(fields) > 0 && strings.HasSuffix(fields[0], ":") {
+		label := strings.TrimSuffix(fields[0], ":")
+		if a.current == nil {
+			return fmt.Errorf("label %q outside of a function or .main", label)
+		}
+		if _, ok := a.current.labels[label]; ok {
+			return fmt.Errorf("label %q is already defined", label)
+		}
+		a.current.labels[label] = len(a.current.instructions)
+		fields = fields[1:]
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	switch fields[0] {
+	case ".constant":
+		return a.constant(fields[1:])
+	case ".function":
+		return a.function(fields[1:])
+	case ".global":
+		return a.global(fields[1:])
+	case ".main":
+		if len(fields) != 1 {
+			return fmt.Errorf(".main takes no argument")
+		}
+		if a.main != nil {
+			return fmt.Errorf(".main is already defined")
+		}
+		a.main = a.newUnit(-1)
+		return nil
+	}
+
+	if strings.HasPrefix(fields[0], ".") {
+		return fmt.Errorf("unknown directive %s", fields[0])
+	}
+	if a.current == nil {
+		return fmt.Errorf("instruction outside of a function or .main")
+	}
+	return a.instruction(fields)
+}
+
+func (a *assembler) newUnit(numFree int) *unit {
+	a.current = &unit{
+		instructions: code.Instructions{},
+		labels:       map[string]int{},
+		numFree:      numFree,
+		line:         a.lineNumber,
+	}
+	return a.current
+}
+
+// constantIndex checks that the next constant has the given index.
+func (a *assembler) constantIndex(field string) error {
+	index, err := strconv.Atoi(field)
+	if err != nil {
+		return fmt.Errorf("invalid constant index %q", field)
+	}
+	if index != len(a.bytecode.Constants) {
+		return fmt.Errorf("constant %d defined where %d was expected", index, len(a.bytecode.Constants))
+	}
+	if a.main != nil {
+		return fmt.Errorf("constants must be defined before .main")
+	}
+	return nil
+}
+
+func (a *assembler) constant(fields []string) error {
+	if len(fields) < 2 {
+		return fmt.Errorf("usage: .constant index type [value]")
+	}
+	err := a.constantIndex(fields[0])
+	if err != nil {
+		return err
+	}
+
+	var constant object.Object
+	kind, args := fields[1], fields[2:]
+	if kind == "null" {
+		if len(args) != 0 {
+			return fmt.Errorf("null takes no value")
+		}
+		constant = &object.Null{}
+	} else {
+		if len(args) != 1 {
+			return fmt.Errorf("%s constant needs one value", kind)
+		}
+		constant, err = parseConstant(kind, args[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	a.bytecode.Constants = append(a.bytecode.Constants, constant)
+	// Constants that aren't functions have no unit, keep the indexes aligned
+	a.units = append(a.units, &unit{})
+	a.current = nil
+	return nil
+}
+
+func parseConstant(kind, value string) (object.Object, error) {
+	switch kind {
+	case "integer":
+		v, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %q", value)
+		}
+		return &object.Integer{Value: v}, nil
+	case "float":
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid float %q", value)
+		}
+		return &object.Float{Value: v}, nil
+	case "string":
+		v, err := strconv.Unquote(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s", value)
+		}
+		return &object.String{Value: v}, nil
+	case "boolean":
+		v, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid boolean %q", value)
+		}
+		return &object.Boolean{Value: v}, nil
+	}
+	return nil, fmt.Errorf("unknown constant type %s", kind)
+}
+
+func (a *assembler) function(fields []string) error {
+	if len(fields) < 1 {
+		return fmt.Errorf("usage: .function index locals=n params=n free=n [name=name]")
+	}
+	err := a.constantIndex(fields[0])
+	if err != nil {
+		return err
+	}
+
+	attributes := map[string]int{"locals": 0, "params": 0, "free": -1}
+	name := ""
+	for _, field := range fields[1:] {
+		key, value, ok := strings.Cut(field, "=")
+		if ok && key == "name" && value != "" {
+			name = value
+			continue
+		}
+		if _, known := attributes[key]; !ok || !known {
+			return fmt.Errorf("invalid function attribute %q", field)
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid function attribute %q", field)
+		}
+		attributes[key] = n
+	}
+
+	fn := &object.CompiledFunction{NumLocals: attributes["locals"], NumParameters: attributes["params"], Name: name}
+	a.bytecode.Constants = append(a.bytecode.Constants, fn)
+	a.units = append(a.units, a.newUnit(attributes["free"]))
+	return nil
+}
+
+func (a *assembler) global(fields []string) error {
+	if len(fields) != 2 {
+		return fmt.Errorf("usage: .global index name")
+	}
+	index, err := strconv.Atoi(fields[0])
+	if err != nil || index < 0 {
+		return fmt.Errorf("invalid global index %q", fields[0])
+	}
+
+	if a.globals == nil {
+		a.globals = map[int]global{}
+	}
+	a.globals[index] = global{name: fields[1], line: a.lineNumber}
+	a.current = nil
+	return nil
+}
+
+// buildDebug makes the debug information from the names of the globals.
+func (a *assembler) buildDebug() error {
+	if a.globals == nil {
+		return nil
+	}
+
+	// Like in .cold files, every global needs an instruction to be set
+	size := len(a.bytecode.Instructions)
+	for _, u := range a.units {
+		size += len(u.instructions)
+	}
+
+	a.debug = &cold.Debug{}
+	for index, g := range a.globals {
+		if index >= size {
+			return &Error{Line: g.line, Message: fmt.Sprintf("global %d is out of range", index)}
+		}
+		for len(a.debug.Globals) <= index {
+			a.debug.Globals = append(a.debug.Globals, "")
+		}
+		a.debug.Globals[index] = g.name
+	}
+	return nil
+}
+
+func (a *assembler) instruction(fields []string) error {
+	u := a.current
+
+	// An optional offset, as written by Disassemble
+	if offset, err := strconv.Atoi(fields[0]); err == nil {
+		if offset != len(u.instructions) {
+			return fmt.Errorf("instruction is at offset %d, not %d", len(u.instructions), offset)
+		}
+		fields = fields[1:]
+		if len(fields) == 0 {
+			return fmt.Errorf("missing instruction after offset")
+		}
+	}
+
+	wide := fields[0] == "OpWide"
+	if wide {
+		fields = fields[1:]
+		if len(fields) == 0 {
+			return fmt.Errorf("missing instruction after OpWide")
+		}
+	}
+
+	op, ok := code.LookupName(fields[0])
+	if !ok || op == code.OpWide {
+		return fmt.Errorf("unknown opcode %s", fields[0])
+	}
+	def, _ := code.Lookup(byte(op))
+
+	args := fields[1:]
+	if len(args) != len(def.OperandWidths) {
+		return fmt.Errorf("%s takes %d operands, got %d", def.Name, len(def.OperandWidths), len(args))
+	}
+	if wide && len(args) == 0 {
+		return fmt.Errorf("%s has no wide form", def.Name)
+	}
+
+	operands := make([]int, len(args))
+	for i, arg := range args {
+		isJump := code.IsJump(op)
+		n, err := strconv.Atoi(arg)
+		if err != nil && isJump {
+			// Offset of the operand, patched once the label is known
+			position := len(u.instructions) + 1
+			if wide {
+				position++
+			}
+			u.fixups = append(u.fixups, fixup{position: position, wide: wide, label: arg, line: a.lineNumber})
+			continue
+		}
+		max := 1<<(16*def.OperandWidths[i]) - 1
+		if err != nil || n < 0 || n > max {
+			return fmt.Errorf("operand %q of %s must be between 0 and %d", arg, def.Name, max)
+		}
+		operands[i] = n
+	}
+
+	// Operands too large for the normal form make the instruction wide
+	if wide || !code.Fits(op, operands...) {
+		u.instructions = append(u.instructions, code.MakeWide(op, operands...)...)
+	} else {
+		u.instructions = append(u.instructions, code.Make(op, operands...)...)
+	}
+	return nil
+}
+
+// checkClosures checks the free counts of functions against the closures
+// built from them.
+func (a *assembler) checkClosures() error {
+	for _, u := range append(a.units, a.main) {
+		for _, closure := range closures(u.instructions) {
+			index, numFree := closure[0], closure[1]
+			if index >= len(a.units) || a.units[index].numFree < 0 {
+				continue
+			}
+			if _, ok := a.bytecode.Constants[index].(*object.CompiledFunction); !ok {
+				continue
+			}
+			if a.units[index].numFree != numFree {
+				return &Error{
+					Line:    a.units[index].line,
+					Message: fmt.Sprintf("function %d has free=%d but is closed over %d variables", index, a.units[index].numFree, numFree),
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// split returns the fields of a line, without its comment. A quoted string
+// is a single field.
+func split(text string) ([]string, error) {
+	var fields []string
+	for {
+		text = strings.TrimLeft(text, " \t\r")
+		if text == "" || text[0] == ';' {
+			return fields, nil
+		}
+
+		if text[0] == '"' {
+			quoted, err := strconv.QuotedPrefix(text)
+			if err != nil {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			fields = append(fields, quoted)
+			text = text[len(quoted):]
+			continue
+		}
+
+		end := strings.IndexAny(text, " \t\r;")
+		if end < 0 {
+			end = len(text)
+		}
+		fields = append(fields, text[:end])
+		text = text[end:]
+	}
+}
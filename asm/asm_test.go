@@ -0,0 +1,216 @@
+package asm
+
+import (
+	"errors"
+	"gold/cold"
+	"gold/compiler"
+	"gold/lexer"
+	"gold/object"
+	"gold/parser"
+	"gold/vm"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	input := `
+let greeting = "hello; world";
+lflt ratio = 0.1;
+let on = true;
+may newAdder = fn(mint a) {
+  return fn(mint b) { return a + b };
+};
+may addTwo = newAdder(2);
+let i = 0;
+while (i < 3) { i++ };
+[addTwo(i), ratio, greeting, on]`
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	comp := compiler.New()
+	_, err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := comp.Bytecode()
+	debug := &cold.Debug{Globals: comp.GlobalNames()}
+
+	listing := Disassemble(bytecode, debug)
+
+	assembled, assembledDebug, err := Assemble(listing)
+	if err != nil {
+		t.Fatalf("Assemble returned an error: %s\n%s", err, listing)
+	}
+	if !reflect.DeepEqual(assembled, bytecode) {
+		t.Errorf("assembled bytecode differs.\nwant=%s\ngot =%s", listing, Disassemble(assembled, nil))
+	}
+	if !reflect.DeepEqual(assembledDebug, debug) {
+		t.Errorf("wrong debug information. want=%+v, got=%+v", debug, assembledDebug)
+	}
+	if again := Disassemble(assembled, assembledDebug); again != listing {
+		t.Errorf("listing is not stable.\nfirst =%q\nsecond=%q", listing, again)
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	p := parser.New(lexer.New("may f = fn(mint a) { return a }; f(1)"))
+	comp := compiler.New()
+	_, err := comp.Compile(p.ParseProgram())
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	expected := `.function 0 locals=1 params=1 free=0 name=f
+  0000 OpGetLocal 0
+  0002 OpReturn
+.constant 1 integer 1
+.main
+  0000 OpClosure 0 0
+  0004 OpSetGlobal 0
+  0007 OpGetGlobal 0
+  0010 OpConstant 1
+  0013 OpCall 1
+  0015 OpPop
+`
+
+	listing := Disassemble(comp.Bytecode(), nil)
+	if listing != expected {
+		t.Errorf("wrong listing.\nwant=%s\ngot =%s", expected, listing)
+	}
+}
+
+func TestAssembleLabels(t *testing.T) {
+	// Sums the integers from 1 to 10
+	listing := `
+.constant 0 integer 0  ; sum
+.constant 1 integer 10 ; counter
+.main
+    OpConstant 0
+    OpSetGlobal 0
+    OpConstant 1
+    OpSetGlobal 1
+loop:
+    OpGetGlobal 1
+    OpJumpNotTruthy done
+    OpGetGlobal 0
+    OpGetGlobal 1
+    OpAdd
+    OpSetGlobal 0
+    OpGetGlobal 1
+    OpDec
+    OpSetGlobal 1
+    OpJump loop
+done:
+    OpGetGlobal 0
+    OpPop
+`
+
+	bytecode, debug, err := Assemble(listing)
+	if err != nil {
+		t.Fatalf("Assemble returned an error: %s", err)
+	}
+	if debug != nil {
+		t.Errorf("debug information without any global: %+v", debug)
+	}
+
+	machine := vm.New(bytecode)
+	err = machine.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if result := machine.LastPoppedStackElem().Inspect(); result != "55" {
+		t.Errorf("wrong result. want=55, got=%s", result)
+	}
+}
+
+func TestAssembleSuperinstructions(t *testing.T) {
+	// Sums the integers from 1 to 10, with the superinstructions
+	listing := `
+.constant 0 integer 0
+.constant 1 integer 10
+.main
+    OpConstant 0
+    OpSetGlobal 0
+    OpConstant 1
+    OpSetGlobal 1
+loop:
+    OpGetGlobal 1
+    OpConstant 0
+    OpJumpEqual done
+    OpGetGlobal 0
+    OpGetGlobal 1
+    OpAdd
+    OpSetGlobal 0
+    OpDecGlobal 1
+    OpJump loop
+done:
+    OpGetGlobal 0
+    OpPop
+`
+
+	bytecode, _, err := Assemble(listing)
+	if err != nil {
+		t.Fatalf("Assemble returned an error: %s", err)
+	}
+
+	machine := vm.New(bytecode)
+	err = machine.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if result := machine.LastPoppedStackElem().Inspect(); result != "55" {
+		t.Errorf("wrong result. want=55, got=%s", result)
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"OpPop", "line 1: instruction outside of a function or .main"},
+		{".constant 0 integer 1", "line 1: missing .main"},
+		{".constant 1 integer 1\n.main", "line 1: constant 1 defined where 0 was expected"},
+		{".constant 0 integer one\n.main", `line 1: invalid integer "one"`},
+		{".constant 0 string \"open\n.main", "line 1: unterminated string"},
+		{".main\nOpNope", "line 2: unknown opcode OpNope"},
+		{".main\nOpConstant", "line 2: OpConstant takes 1 operands, got 0"},
+		{".main\nOpGetLocal 65536", `line 2: operand "65536" of OpGetLocal must be between 0 and 65535`},
+		{".main\n0001 OpPop", "line 2: instruction is at offset 0, not 1"},
+		{".main\nOpJump nowhere", `line 2: undefined label "nowhere"`},
+		{".main\nx:\nx: OpPop", `line 3: label "x" is already defined`},
+		{".main\n.unknown", "line 2: unknown directive .unknown"},
+		{".global 3 x\n.main\nOpNull\nOpPop", "line 1: global 3 is out of range"},
+		{".main\nOpWide OpPop", "line 2: OpPop has no wide form"},
+		{".main\nOpJump far\n" + strings.Repeat("OpNull\nOpPop\n", 40000) + "far:", `line 2: label "far" is too far, use OpWide`},
+		{
+			".function 0 locals=0 params=0 free=2\nOpNull\nOpReturn\n.main\nOpClosure 0 1\nOpPop",
+			"line 1: function 0 has free=2 but is closed over 1 variables",
+		},
+	}
+
+	for _, tt := range tests {
+		_, _, err := Assemble(tt.input)
+		var asmErr *Error
+		if !errors.As(err, &asmErr) {
+			t.Errorf("expected an *Error for %q, got=%v", tt.input, err)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error for %q.\nwant=%q\ngot =%q", tt.input, tt.expected, err.Error())
+		}
+	}
+}
+
+func TestAssembleStringConstant(t *testing.T) {
+	bytecode, _, err := Assemble(".constant 0 string \"a \\\"quoted\\\"; text\\n\" ; comment\n.main")
+	if err != nil {
+		t.Fatalf("Assemble returned an error: %s", err)
+	}
+
+	str, ok := bytecode.Constants[0].(*object.String)
+	if !ok || str.Value != "a \"quoted\"; text\n" {
+		t.Errorf("wrong constant. got=%+v", bytecode.Constants[0])
+	}
+}
@@ -127,6 +127,19 @@ func (rs *ReturnStatement) String() string {
 	return out.String()
 }
 
+// ImportStatement brings the exported globals of a module in the namespace
+// of the importing one.
+type ImportStatement struct {
+	Token token.Token // the 'import' token
+	Path  *StringLiteral
+}
+
+func (is *ImportStatement) statementNode()       {}
+func (is *ImportStatement) TokenLiteral() string { return is.Token.Literal }
+func (is *ImportStatement) String() string {
+	return is.TokenLiteral() + " \"" + is.Path.Value + "\";"
+}
+
 type ExpressionStatement struct {
 	Expression Expression
 	Token      token.Token // the first token of the expression
@@ -144,6 +157,7 @@ func (es *ExpressionStatement) String() string {
 type BlockStatement struct {
 	Token      token.Token // the { token
 	Statements []Statement
+	Rbrace     token.Token // the } token
 }
 
 func (bs *BlockStatement) statementNode()       {}
@@ -444,7 +458,8 @@ func (ie *IndexExpression) String() string {
 
 type HashLiteral struct {
 	Pairs map[Expression]Expression
-	Token token.Token // the '{' token
+	Keys  []Expression // keys of Pairs in source order
+	Token token.Token  // the '{' token
 }
 
 func (hl *HashLiteral) expressionNode()      {}
@@ -453,8 +468,8 @@ func (hl *HashLiteral) String() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for key, value := range hl.Pairs {
-		pairs = append(pairs, key.String()+":"+value.String())
+	for _, key := range hl.Keys {
+		pairs = append(pairs, key.String()+":"+hl.Pairs[key].String())
 	}
 
 	out.WriteString("{")
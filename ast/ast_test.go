@@ -26,3 +26,48 @@ func TestString(t *testing.T) {
 		t.Errorf("program.String() wrong. got=%q", program.String())
 	}
 }
+
+func TestInspect(t *testing.T) {
+	x := &Identifier{Token: token.Token{Type: token.IDENT, Literal: "x"}, Value: "x"}
+	one := &IntegerLiteral{Token: token.Token{Type: token.INT, Literal: "1"}, Value: 1}
+	program := &Program{
+		Statements: []Statement{
+			&Declare{
+				Token: token.Token{Type: token.LET, Literal: "let"},
+				Name:  x,
+				Value: &InfixExpression{Left: one, Operator: "+", Right: one},
+			},
+			&ReturnStatement{ReturnValue: nil},
+			(*Declare)(nil),
+		},
+	}
+
+	identifiers := 0
+	integers := 0
+	Inspect(program, func(n Node) bool {
+		switch n.(type) {
+		case *Identifier:
+			identifiers++
+		case *IntegerLiteral:
+			integers++
+		}
+		return true
+	})
+
+	if identifiers != 1 {
+		t.Errorf("wrong number of identifiers visited. got=%d", identifiers)
+	}
+	if integers != 2 {
+		t.Errorf("wrong number of integers visited. got=%d", integers)
+	}
+
+	visited := 0
+	Inspect(program, func(n Node) bool {
+		visited++
+		_, ok := n.(*Program)
+		return ok
+	})
+	if visited != 3 {
+		t.Errorf("children of skipped nodes were visited. got=%d", visited)
+	}
+}
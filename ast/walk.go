@@ -0,0 +1,101 @@
+package ast
+
+// Inspect traverses the AST in depth-first order, starting with node. It calls
+// f for each node; if f returns false, the children of that node are skipped.
+// Nil nodes are never passed to f.
+func Inspect(node Node, f func(Node) bool) {
+	if isNil(node) || !f(node) {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		for _, s := range n.Statements {
+			Inspect(s, f)
+		}
+	case *Declare:
+		Inspect(n.Name, f)
+		Inspect(n.Value, f)
+	case *ReassignStatement:
+		Inspect(n.Name, f)
+		Inspect(n.Value, f)
+	case *ReturnStatement:
+		Inspect(n.ReturnValue, f)
+	case *ExpressionStatement:
+		Inspect(n.Expression, f)
+	case *ImportStatement:
+		Inspect(n.Path, f)
+	case *BlockStatement:
+		for _, s := range n.Statements {
+			Inspect(s, f)
+		}
+	case *PrefixExpression:
+		Inspect(n.Right, f)
+	case *InfixExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Right, f)
+	case *IncPostExpression:
+		Inspect(n.Left, f)
+	case *IncPreExpression:
+		Inspect(n.Right, f)
+	case *IfExpression:
+		Inspect(n.Condition, f)
+		Inspect(n.Consequence, f)
+		Inspect(n.Alternative, f)
+	case *WhileExpression:
+		Inspect(n.Condition, f)
+		Inspect(n.Consequence, f)
+	case *FunctionLiteral:
+		for _, p := range n.Parameters {
+			Inspect(p, f)
+		}
+		Inspect(n.Body, f)
+	case *Parameter:
+		Inspect(n.Name, f)
+	case *CallExpression:
+		Inspect(n.Function, f)
+		for _, a := range n.Arguments {
+			Inspect(a, f)
+		}
+	case *ArrayLiteral:
+		for _, e := range n.Elements {
+			Inspect(e, f)
+		}
+	case *IndexExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Index, f)
+	case *HashLiteral:
+		for _, key := range n.Keys {
+			Inspect(key, f)
+			Inspect(n.Pairs[key], f)
+		}
+	}
+}
+
+// isNil reports whether node is nil, including typed nil pointers the parser
+// leaves behind on errors.
+func isNil(node Node) bool {
+	switch n := node.(type) {
+	case nil:
+		return true
+	case *Program:
+		return n == nil
+	case *Declare:
+		return n == nil
+	case *ReassignStatement:
+		return n == nil
+	case *ReturnStatement:
+		return n == nil
+	case *ExpressionStatement:
+		return n == nil
+	case *BlockStatement:
+		return n == nil
+	case *Identifier:
+		return n == nil
+	case *FunctionLiteral:
+		return n == nil
+	case *Parameter:
+		return n == nil
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+// Package build compiles the modules of a program to units, one .cold file
+// next to the source of each module, and only compiles again the modules
+// whose source or imported exports changed since their unit was written.
+package build
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"gold/ast"
+	"gold/cold"
+	"gold/compiler"
+	"gold/lexer"
+	"gold/parser"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Builder builds the units of a program.
+type Builder struct {
+	// Optimization is one of the compiler.Optimization levels. The units
+	// compiled at another level are compiled again.
+	Optimization int
+	// Compiled lists the modules compiled by the last Build, the others
+	// were up to date.
+	Compiled []string
+
+	units    map[string]*compiler.Unit
+	order    []*compiler.Unit
+	building []string
+}
+
+// UnitFile returns the name of the unit of the module in file.
+func UnitFile(file string) string {
+	return strings.TrimSuffix(file, filepath.Ext(file)) + ".cold"
+}
+
+// Build builds the units of the module in file and of the modules it
+// imports. The unit of file comes first, as link.Link expects.
+func (b *Builder) Build(file string) ([]*compiler.Unit, error) {
+	b.Compiled = nil
+	b.units = map[string]*compiler.Unit{}
+	b.order = nil
+	b.building = nil
+
+	main, err := b.build(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+	// Every module is built after the ones it imports, so the main one is
+	// the last
+	units := []*compiler.Unit{main}
+	return append(units, b.order[:len(b.order)-1]...), nil
+}
+
+func (b *Builder) build(name string) (*compiler.Unit, error) {
+	if unit, ok := b.units[name]; ok {
+		return unit, nil
+	}
+	for i, building := range b.building {
+		if building == name {
+			return nil, fmt.Errorf("import cycle: %s -> %s", strings.Join(b.building[i:], " -> "), name)
+		}
+	}
+	b.building = append(b.building, name)
+	defer func() { b.building = b.building[:len(b.building)-1] }()
+
+	source, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	p := parser.New(lexer.New(string(source)))
+	program := p.ParseProgram()
+	if errs := p.ParseErrors(); len(errs) != 0 {
+		return nil, fmt.Errorf("%s:%d:%d: %s", name, errs[0].Token.Line, errs[0].Token.Column, errs[0].Message)
+	}
+
+	// The imported modules are built first, their exports are needed to
+	// compile this one.
+	for _, statement := range program.Statements {
+		imp, ok := statement.(*ast.ImportStatement)
+		if !ok {
+			break
+		}
+		imported, _, err := compiler.FileResolver{}.Resolve(name, imp.Path.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d:%d: cannot import %q: %w", name, imp.Token.Line, imp.Token.Column, imp.Path.Value, err)
+		}
+		_, err = b.build(imported)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	hash := sha256.Sum256(append([]byte{byte(b.Optimization)}, source...))
+	if unit, ok := b.upToDate(name, hash); ok {
+		b.add(unit)
+		return unit, nil
+	}
+
+	comp := compiler.New()
+	comp.SetUnitResolver(b, name)
+	comp.SetOptimization(b.Optimization)
+	_, err = comp.Compile(program)
+	if err != nil {
+		var compileErr *compiler.CompileError
+		if errors.As(err, &compileErr) {
+			return nil, fmt.Errorf("%s:%d:%d: %w", name, compileErr.Token.Line, compileErr.Token.Column, err)
+		}
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	unit := comp.Unit()
+	unit.SourceHash = hash
+
+	var out bytes.Buffer
+	err = cold.EncodeUnit(&out, unit)
+	if err != nil {
+		return nil, err
+	}
+	err = os.WriteFile(UnitFile(name), out.Bytes(), 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	b.add(unit)
+	b.Compiled = append(b.Compiled, name)
+	return unit, nil
+}
+
+func (b *Builder) add(unit *compiler.Unit) {
+	b.units[unit.Name] = unit
+	b.order = append(b.order, unit)
+}
+
+// upToDate returns the unit written for the module name, if it was compiled
+// from the same source and against the current exports of its imports.
+func (b *Builder) upToDate(name string, hash [sha256.Size]byte) (*compiler.Unit, bool) {
+	file, err := os.Open(UnitFile(name))
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+
+	unit, err := cold.DecodeUnit(file)
+	if err != nil || unit.Name != name || unit.SourceHash != hash {
+		return nil, false
+	}
+	for _, dependency := range unit.Imports {
+		imported, ok := b.units[dependency.Name]
+		if !ok || imported.ExportsHash() != dependency.ExportsHash {
+			return nil, false
+		}
+	}
+	return unit, true
+}
+
+// ResolveUnit returns the unit built for the module imported as path by the
+// module from.
+func (b *Builder) ResolveUnit(from, path string) (*compiler.Unit, error) {
+	name, _, err := compiler.FileResolver{}.Resolve(from, path)
+	if err != nil {
+		return nil, err
+	}
+	unit, ok := b.units[name]
+	if !ok {
+		return nil, fmt.Errorf("module %s is not built", name)
+	}
+	return unit, nil
+}
@@ -0,0 +1,98 @@
+package build
+
+import (
+	"gold/link"
+	"gold/vm"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func write(t *testing.T, file, source string) {
+	t.Helper()
+	err := os.WriteFile(file, []byte(source), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIncrementalBuild(t *testing.T) {
+	dir := t.TempDir()
+	err := os.Mkdir(filepath.Join(dir, "lib"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	main := filepath.Join(dir, "main.gold")
+	lib := filepath.Join(dir, "lib", "math.gold")
+	write(t, lib, "let double = fn(lint x) { return 2 * x }")
+	write(t, main, `import "lib/math"; double(21)`)
+
+	tests := []struct {
+		change   func()
+		compiled []string
+		result   string
+	}{
+		{func() {}, []string{lib, main}, "42"},
+		// Nothing changed
+		{func() {}, nil, "42"},
+		// The exports of lib are the same, main is kept
+		{func() { write(t, lib, "let double = fn(lint x) { return x + x + 1 }") }, []string{lib}, "43"},
+		{func() { write(t, main, `import "lib/math"; double(1)`) }, []string{main}, "3"},
+		// A new export changes the exports of lib, main is compiled again
+		{func() { write(t, lib, "let double = fn(lint x) { return 2 * x }; let half = 0") }, []string{lib, main}, "2"},
+	}
+
+	for i, tt := range tests {
+		tt.change()
+		builder := &Builder{}
+		units, err := builder.Build(main)
+		if err != nil {
+			t.Fatalf("build %d: %s", i, err)
+		}
+		if !reflect.DeepEqual(builder.Compiled, tt.compiled) {
+			t.Errorf("build %d compiled %q, want %q", i, builder.Compiled, tt.compiled)
+		}
+
+		bytecode, _, err := link.Link(units...)
+		if err != nil {
+			t.Fatalf("link %d: %s", i, err)
+		}
+		machine := vm.New(bytecode)
+		err = machine.Run()
+		if err != nil {
+			t.Fatalf("vm error %d: %s", i, err)
+		}
+		if got := machine.LastPoppedStackElem().Inspect(); got != tt.result {
+			t.Errorf("build %d gives %s, want %s", i, got, tt.result)
+		}
+	}
+
+	// Another optimization level compiles everything again
+	builder := &Builder{Optimization: 2}
+	_, err = builder.Build(main)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(builder.Compiled) != 2 {
+		t.Errorf("expected both modules to be compiled again, got %q", builder.Compiled)
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.gold"), filepath.Join(dir, "b.gold")
+	write(t, a, `import "b"; 1`)
+	write(t, b, `import "a"; 2`)
+
+	_, err := (&Builder{}).Build(a)
+	if err == nil || err.Error() != "import cycle: "+a+" -> "+b+" -> "+a {
+		t.Errorf("wrong error for a cycle, got=%v", err)
+	}
+
+	write(t, b, "lint x = \"two\"")
+	_, err = (&Builder{}).Build(a)
+	if err == nil || err.Error() != b+":1:1: wrong type used : 'x' expect type 'INTEGER' but got 'STRING'" {
+		t.Errorf("wrong error in an imported module, got=%v", err)
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"gold/asm"
+	"gold/build"
+	"gold/cold"
+	"gold/compiler"
+	"gold/format"
+	"gold/lexer"
+	"gold/link"
+	"gold/object"
+	"gold/parser"
+	"gold/project"
+	"gold/verifier"
+	"gold/vm"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runFile runs the program in fileName, compiled first unless it is a .cold
+// file, with args as the arguments of the program, the environment of the
+// process, and access to the files allowed by the policy files.
+func runFile(c *cli, fileName string, level int, args []string, files *object.FilePolicy) error {
+	var bytecode *compiler.Bytecode
+	if filepath.Ext(fileName) == ".cold" {
+		var err error
+		bytecode, _, err = readBytecode(fileName)
+		if err != nil {
+			return err
+		}
+	} else {
+		comp, err := compileSource(fileName, level)
+		if err != nil {
+			return err
+		}
+		bytecode = comp.Bytecode()
+	}
+
+	// The bytecode of the compiler is checked too, so that its bugs are
+	// reported rather than crashing the VM
+	err := verifier.Verify(bytecode)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fileName, err)
+	}
+
+	machine := vm.NewWithConfig(bytecode, vm.Config{
+		Args:   args,
+		Getenv: os.LookupEnv,
+		Files:  files,
+		Stdin:  c.stdin,
+		Stdout: c.stdout,
+		Stderr: c.stderr,
+	})
+	err = machine.Run()
+	if err != nil {
+		return err
+	}
+	if code, ok := machine.ExitCode(); ok && code != 0 {
+		return exitStatus(code)
+	}
+	return nil
+}
+
+// compileSource compiles the program in fileName with the modules it
+// imports. The errors are prefixed with their position in the file.
+func compileSource(fileName string, level int) (*compiler.Compiler, error) {
+	source, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	p := parser.New(lexer.New(string(source)))
+	program := p.ParseProgram()
+	if parseErrs := p.ParseErrors(); len(parseErrs) != 0 {
+		errs := make([]error, len(parseErrs))
+		for i, e := range parseErrs {
+			errs[i] = fmt.Errorf("%s:%d:%d: %s", fileName, e.Token.Line, e.Token.Column, e.Message)
+		}
+		return nil, errors.Join(errs...)
+	}
+
+	comp := compiler.New()
+	comp.SetResolver(compiler.FileResolver{}, fileName)
+	comp.SetOptimization(level)
+	_, err = comp.Compile(program)
+	if err != nil {
+		var compileErr *compiler.CompileError
+		if errors.As(err, &compileErr) {
+			return nil, fmt.Errorf("%s:%d:%d: %w", fileName, compileErr.Token.Line, compileErr.Token.Column, err)
+		}
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+	return comp, nil
+}
+
+func compileFile(inputFileName, outputFileName string, level int) error {
+	comp, err := compileSource(inputFileName, level)
+	if err != nil {
+		return err
+	}
+	return writeProgram(outputFileName, comp.Bytecode(), &cold.Debug{Globals: comp.GlobalNames()})
+}
+
+// buildProject compiles the package in dir, described by its gold.mod, to
+// output, or to a program named after the package in dir.
+func buildProject(dir, output string, level int) error {
+	p, err := project.Load(dir)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+
+	comp, err := p.Build(level)
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		output = filepath.Join(dir, p.Root.Manifest.Package+".cold")
+	}
+	return writeProgram(output, comp.Bytecode(), &cold.Debug{Globals: comp.GlobalNames()})
+}
+
+// checkTarget compiles the file or the package in the directory target,
+// without writing the program.
+func checkTarget(target string) error {
+	if !isDir(target) {
+		_, err := compileSource(target, compiler.OptimizationNone)
+		return err
+	}
+
+	p, err := project.Load(target)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+	_, err = p.Build(compiler.OptimizationNone)
+	return err
+}
+
+// buildUnits compiles the module in fileName and the modules it imports to
+// units, leaving the ones that are up to date, and lists the units written.
+func buildUnits(w io.Writer, fileName string, level int) error {
+	builder := &build.Builder{Optimization: level}
+	_, err := builder.Build(fileName)
+	if err != nil {
+		return err
+	}
+	for _, name := range builder.Compiled {
+		fmt.Fprintln(w, build.UnitFile(name))
+	}
+	return nil
+}
+
+// linkFiles links the units in files, the main one first, in the program
+// output.
+func linkFiles(files []string, output string) error {
+	var units []*compiler.Unit
+	for _, name := range files {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		unit, err := cold.DecodeUnit(bufio.NewReader(file))
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		units = append(units, unit)
+	}
+
+	bytecode, debug, err := link.Link(units...)
+	if err != nil {
+		return err
+	}
+	return writeProgram(output, bytecode, debug)
+}
+
+func readBytecode(fileName string) (*compiler.Bytecode, *cold.Debug, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	bytecode, debug, err := cold.Decode(bufio.NewReader(file))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+	return bytecode, debug, nil
+}
+
+// writeProgram writes a .cold file.
+func writeProgram(fileName string, bytecode *compiler.Bytecode, debug *cold.Debug) error {
+	var out bytes.Buffer
+	err := cold.Encode(&out, bytecode, debug)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, out.Bytes(), 0644)
+}
+
+// disassembleFile prints the listing of a .cold file, or of the program
+// compiled from a source file.
+func disassembleFile(w io.Writer, fileName string, level int) error {
+	if filepath.Ext(fileName) == ".cold" {
+		bytecode, debug, err := readBytecode(fileName)
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(w, asm.Disassemble(bytecode, debug))
+		return nil
+	}
+
+	comp, err := compileSource(fileName, level)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(w, asm.Disassemble(comp.Bytecode(), &cold.Debug{Globals: comp.GlobalNames()}))
+	return nil
+}
+
+// assembleFile writes the .cold file of a listing.
+func assembleFile(inputFileName, outputFileName string) error {
+	src, err := os.ReadFile(inputFileName)
+	if err != nil {
+		return err
+	}
+
+	bytecode, debug, err := asm.Assemble(string(src))
+	if err != nil {
+		return fmt.Errorf("%s: %w", inputFileName, err)
+	}
+	return writeProgram(outputFileName, bytecode, debug)
+}
+
+// formatFiles rewrites the given files in the canonical style, or formats the
+// standard input to the standard output when there is none. With check, the
+// files are left untouched and the ones that need formatting are listed.
+func formatFiles(c *cli, files []string, check bool) error {
+	if len(files) == 0 {
+		src, err := io.ReadAll(c.stdin)
+		if err != nil {
+			return err
+		}
+		formatted, err := format.Source(src)
+		if err != nil {
+			return err
+		}
+		if check {
+			if !bytes.Equal(src, formatted) {
+				fmt.Fprintln(c.stdout, "<standard input>")
+				return errReported
+			}
+			return nil
+		}
+		_, err = c.stdout.Write(formatted)
+		return err
+	}
+
+	failed := false
+	for _, name := range files {
+		src, err := os.ReadFile(name)
+		if err != nil {
+			fmt.Fprintln(c.stderr, err)
+			failed = true
+			continue
+		}
+		formatted, err := format.Source(src)
+		if err != nil {
+			fmt.Fprintf(c.stderr, "%s: %s\n", name, err)
+			failed = true
+			continue
+		}
+		if bytes.Equal(src, formatted) {
+			continue
+		}
+
+		if check {
+			fmt.Fprintln(c.stdout, name)
+			failed = true
+			continue
+		}
+		err = os.WriteFile(name, formatted, 0644)
+		if err != nil {
+			fmt.Fprintln(c.stderr, err)
+			failed = true
+		}
+	}
+	if failed {
+		return errReported
+	}
+	return nil
+}
+
+func isDir(name string) bool {
+	info, err := os.Stat(name)
+	return err == nil && info.IsDir()
+}
+
+// coldFile names the program compiled from the file name.
+func coldFile(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name)) + ".cold"
+}
@@ -0,0 +1,416 @@
+// Command gold compiles, runs and inspects Gold programs.
+//
+//	gold run [-O] file [arguments...]
+//	gold build [-O] [-o output] [file | dir]
+//	gold check file | dir...
+//
+// Run gold help for the other commands. Without a command, gold starts the
+// REPL. A command exits with status 0 when it succeeds, 1 when it fails and 2
+// when its command line is wrong.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"gold/cold"
+	"gold/compiler"
+	"gold/lsp"
+	"gold/object"
+	"gold/repl"
+	"io"
+	"os"
+	"os/user"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// version is the version of the toolchain, set at build time with
+// -ldflags "-X main.version=1.0.0".
+var version = "devel"
+
+// cli holds the standard streams of the commands.
+type cli struct {
+	stdin          io.Reader
+	stdout, stderr io.Writer
+}
+
+// command is a subcommand of gold.
+type command struct {
+	name string
+	// usage lists the arguments after the name of the command.
+	usage   string
+	summary string
+	// flagsFirst stops the flags at the first argument. The flags of the
+	// other commands may follow their arguments.
+	flagsFirst bool
+	// setup declares the flags of the command in fs and returns the function
+	// running it with the arguments left once they are parsed.
+	setup func(c *cli, fs *flag.FlagSet) func(args []string) error
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{"run", "[-O] [-root dir] [-read-only] [-no-files] file [arguments...]", "compile and run a .gold file, or run a .cold program", true, setupRun},
+		{"build", "[-O] [-o output] [file | dir]", "compile a .gold file, or the package of a directory, to a .cold program", false, setupBuild},
+		{"check", "file | dir...", "report the errors of .gold files or packages without writing anything", false, setupCheck},
+		{"unit", "[-O] file", "compile a module and its imports to units, leaving the ones up to date", false, setupUnit},
+		{"link", "-o output main.cold [module.cold...]", "link units into a program", false, setupLink},
+		{"disasm", "[-O] file", "print the assembly of a .cold program or of a .gold file", false, setupDisasm},
+		{"asm", "[-o output] file", "assemble a listing into a .cold program", false, setupAsm},
+		{"fmt", "[-check] [file...]", "format .gold files, or the standard input", false, setupFmt},
+		{"repl", "", "start the read-eval-print loop", false, setupRepl},
+		{"lsp", "", "start the language server on the standard input and output", false, setupLsp},
+		{"version", "", "print the version of gold", false, setupVersion},
+		{"help", "[command]", "print the help of gold or of a command", false, setupHelp},
+	}
+}
+
+func main() {
+	os.Exit(execute(os.Args[1:], &cli{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr}))
+}
+
+// usageError is a command line a command can't make sense of. Its message
+// may be empty when the flag package already reported the problem.
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+// errReported is returned by the commands that printed their errors
+// themselves.
+var errReported = errors.New("errors were reported")
+
+// exitStatus is returned by run for a program exiting with a status other
+// than 0, which becomes the status of gold.
+type exitStatus int
+
+func (e exitStatus) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+
+// execute runs the command line args and returns the exit status. A command
+// that panics fails with the error it panicked with rather than a stack
+// trace.
+func execute(args []string, c *cli) (code int) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(c.stderr, "error: %v\n", r)
+			code = 1
+		}
+	}()
+
+	if len(args) == 0 {
+		args = []string{"repl"}
+	}
+	cmd := lookup(args[0])
+	if cmd == nil {
+		fmt.Fprintf(c.stderr, "gold: unknown command %q\nRun 'gold help' for usage.\n", args[0])
+		return 2
+	}
+
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {}
+	run := cmd.setup(c, fs)
+
+	err := parseFlags(fs, args[1:], cmd.flagsFirst)
+	if errors.Is(err, flag.ErrHelp) {
+		printCommandHelp(c.stdout, cmd)
+		return 0
+	}
+	if err != nil {
+		// The flag package reported the error
+		err = usageError("")
+	} else {
+		err = run(fs.Args())
+	}
+
+	var usage usageError
+	var status exitStatus
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &usage):
+		if usage != "" {
+			fmt.Fprintf(c.stderr, "gold %s: %s\n", cmd.name, usage)
+		}
+		fmt.Fprintf(c.stderr, "usage: gold %s %s\nRun 'gold help %s' for details.\n", cmd.name, cmd.usage, cmd.name)
+		return 2
+	case errors.As(err, &status):
+		return int(status)
+	case errors.Is(err, errReported):
+		return 1
+	default:
+		fmt.Fprintln(c.stderr, err)
+		return 1
+	}
+}
+
+// parseFlags parses the flags of args. Unless flagsFirst, the flags may be
+// mixed with the arguments, up to a "--".
+func parseFlags(fs *flag.FlagSet, args []string, flagsFirst bool) error {
+	if flagsFirst {
+		return fs.Parse(args)
+	}
+	var positional []string
+	for {
+		err := fs.Parse(args)
+		if err != nil {
+			return err
+		}
+		rest := fs.Args()
+		consumed := len(args) - len(rest)
+		if len(rest) == 0 || consumed > 0 && args[consumed-1] == "--" {
+			positional = append(positional, rest...)
+			break
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+	return fs.Parse(append([]string{"--"}, positional...))
+}
+
+func lookup(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func printHelp(w io.Writer) {
+	fmt.Fprintf(w, "Gold compiles, runs and inspects Gold programs.\n\nusage: gold <command> [arguments]\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(w, "\nWithout a command, gold starts the REPL. Run 'gold help <command>' for the flags of a command.\n")
+}
+
+func printCommandHelp(w io.Writer, cmd *command) {
+	fmt.Fprintf(w, "usage: gold %s %s\n\n%s.\n", cmd.name, cmd.usage, strings.ToUpper(cmd.summary[:1])+cmd.summary[1:])
+
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	cmd.setup(&cli{}, fs)
+	hasFlags := false
+	fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+	if hasFlags {
+		fmt.Fprintf(w, "\nflags:\n")
+		fs.SetOutput(w)
+		fs.PrintDefaults()
+	}
+}
+
+func setupRun(c *cli, fs *flag.FlagSet) func([]string) error {
+	level := optimizationFlags(fs)
+	var roots listFlag
+	fs.Var(&roots, "root", "let the program access the files under `dir` only, may be repeated;\nwithout -root, every file is accessible")
+	readOnly := fs.Bool("read-only", false, "don't let the program write files")
+	noFiles := fs.Bool("no-files", false, "don't let the program access any file")
+	return func(args []string) error {
+		if len(args) == 0 {
+			return usageError("missing file")
+		}
+		var policy *object.FilePolicy
+		if !*noFiles {
+			policy = &object.FilePolicy{Roots: roots, ReadOnly: *readOnly}
+		}
+		return runFile(c, args[0], *level, args[1:], policy)
+	}
+}
+
+func setupBuild(c *cli, fs *flag.FlagSet) func([]string) error {
+	level := optimizationFlags(fs)
+	output := fs.String("o", "", "write the program to `file`, by default the name of the source or of the package with the .cold extension")
+	return func(args []string) error {
+		if len(args) > 1 {
+			return usageError("too many arguments")
+		}
+		target := "."
+		if len(args) == 1 {
+			target = args[0]
+		}
+		if isDir(target) {
+			return buildProject(target, *output, *level)
+		}
+		out := *output
+		if out == "" {
+			out = coldFile(target)
+		}
+		return compileFile(target, out, *level)
+	}
+}
+
+func setupCheck(c *cli, fs *flag.FlagSet) func([]string) error {
+	return func(args []string) error {
+		if len(args) == 0 {
+			return usageError("missing file")
+		}
+		failed := false
+		for _, target := range args {
+			err := checkTarget(target)
+			if err != nil {
+				fmt.Fprintln(c.stderr, err)
+				failed = true
+			}
+		}
+		if failed {
+			return errReported
+		}
+		return nil
+	}
+}
+
+func setupUnit(c *cli, fs *flag.FlagSet) func([]string) error {
+	level := optimizationFlags(fs)
+	return func(args []string) error {
+		if len(args) != 1 {
+			return usageError("expect one file")
+		}
+		return buildUnits(c.stdout, args[0], *level)
+	}
+}
+
+func setupLink(c *cli, fs *flag.FlagSet) func([]string) error {
+	output := fs.String("o", "", "write the program to `file`")
+	return func(args []string) error {
+		if *output == "" {
+			return usageError("-o is required")
+		}
+		if len(args) == 0 {
+			return usageError("missing unit")
+		}
+		return linkFiles(args, *output)
+	}
+}
+
+func setupDisasm(c *cli, fs *flag.FlagSet) func([]string) error {
+	level := optimizationFlags(fs)
+	return func(args []string) error {
+		if len(args) != 1 {
+			return usageError("expect one file")
+		}
+		return disassembleFile(c.stdout, args[0], *level)
+	}
+}
+
+func setupAsm(c *cli, fs *flag.FlagSet) func([]string) error {
+	output := fs.String("o", "", "write the program to `file`, by default the name of the listing with the .cold extension")
+	return func(args []string) error {
+		if len(args) != 1 {
+			return usageError("expect one file")
+		}
+		out := *output
+		if out == "" {
+			out = coldFile(args[0])
+		}
+		return assembleFile(args[0], out)
+	}
+}
+
+func setupFmt(c *cli, fs *flag.FlagSet) func([]string) error {
+	check := fs.Bool("check", false, "list the files that need formatting instead of rewriting them, and fail if there is any")
+	return func(args []string) error {
+		return formatFiles(c, args, *check)
+	}
+}
+
+func setupRepl(c *cli, fs *flag.FlagSet) func([]string) error {
+	return func(args []string) error {
+		if len(args) != 0 {
+			return usageError("too many arguments")
+		}
+		name := "there"
+		if u, err := user.Current(); err == nil {
+			name = u.Username
+		}
+		fmt.Fprintf(c.stdout, "Hello %s! This is the Gold programming language!\n", name)
+		fmt.Fprintf(c.stdout, "Feel free to type in commands\n")
+		repl.Start(c.stdin, c.stdout)
+		return nil
+	}
+}
+
+func setupLsp(c *cli, fs *flag.FlagSet) func([]string) error {
+	return func(args []string) error {
+		if len(args) != 0 {
+			return usageError("too many arguments")
+		}
+		return lsp.NewServer(c.stdin, c.stdout).Serve()
+	}
+}
+
+func setupVersion(c *cli, fs *flag.FlagSet) func([]string) error {
+	return func(args []string) error {
+		if len(args) != 0 {
+			return usageError("too many arguments")
+		}
+		fmt.Fprintf(c.stdout, "gold %s, bytecode format %d.%d, %s %s/%s\n",
+			version, cold.MajorVersion, cold.MinorVersion, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+		return nil
+	}
+}
+
+func setupHelp(c *cli, fs *flag.FlagSet) func([]string) error {
+	return func(args []string) error {
+		switch len(args) {
+		case 0:
+			printHelp(c.stdout)
+			return nil
+		case 1:
+			cmd := lookup(args[0])
+			if cmd == nil {
+				return usageError(fmt.Sprintf("unknown command %q", args[0]))
+			}
+			printCommandHelp(c.stdout, cmd)
+			return nil
+		}
+		return usageError("too many arguments")
+	}
+}
+
+// listFlag is a flag that may be repeated, listing its values.
+type listFlag []string
+
+func (f *listFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *listFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// optimizationFlag is one of the -O flags, setting the optimization level
+// to value.
+type optimizationFlag struct {
+	level *int
+	value int
+}
+
+func (f optimizationFlag) String() string { return "" }
+
+func (f optimizationFlag) Set(s string) error {
+	enabled, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	if enabled {
+		*f.level = f.value
+	} else {
+		*f.level = compiler.OptimizationNone
+	}
+	return nil
+}
+
+func (f optimizationFlag) IsBoolFlag() bool { return true }
+
+// optimizationFlags declares -O, the highest optimization level, and -O0,
+// -O1 and -O2, and returns the level they set.
+func optimizationFlags(fs *flag.FlagSet) *int {
+	level := compiler.OptimizationNone
+	fs.Var(optimizationFlag{&level, compiler.OptimizationPeephole}, "O", "optimize, same as -O2")
+	fs.Var(optimizationFlag{&level, compiler.OptimizationNone}, "O0", "compile the program as written, the default")
+	fs.Var(optimizationFlag{&level, compiler.OptimizationConstants}, "O1", "fold the operations on literals and share the numbers and strings of the constant pool")
+	fs.Var(optimizationFlag{&level, compiler.OptimizationPeephole}, "O2", "also remove useless jumps and values, and merge common sequences of instructions")
+	return &level
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExecute(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		err := os.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	good := write("good.gold", "let x = 1 + 2;\n")
+	bad := write("bad.gold", "let x = 1;\nx + y;\n")
+	exit := write("exit.gold", "exit(len(args()));\n")
+	divide := write("divide.gold", "let x = 0;\nprint(1 / x);\n")
+	echo := write("echo.gold", "may line = read_line();\nprintf(\"%s %d\\n\", line, len(args()));\neprint(\"done\");\n")
+	program := filepath.Join(dir, "out.cold")
+
+	tests := []struct {
+		args           []string
+		expectedStatus int
+		expectedStdout string
+		expectedStderr string
+	}{
+		{[]string{"version"}, 0, "gold devel", ""},
+		{[]string{"help"}, 0, "commands:", ""},
+		{[]string{"help", "build"}, 0, "usage: gold build", ""},
+		{[]string{"run", "-h"}, 0, "usage: gold run", ""},
+		{[]string{"bogus"}, 2, "", `unknown command "bogus"`},
+		{[]string{"help", "bogus"}, 2, "", `unknown command "bogus"`},
+		{[]string{"run"}, 2, "", "gold run: missing file"},
+		{[]string{"build", "-x", good}, 2, "", "flag provided but not defined: -x"},
+		{[]string{"link", "main.cold"}, 2, "", "-o is required"},
+		{[]string{"run", good, "-O", "arg"}, 0, "", ""},
+		{[]string{"run", bad}, 1, "", bad + ":2:1: undefined variable : 'y'"},
+		{[]string{"run", filepath.Join(dir, "missing.gold")}, 1, "", "no such file"},
+		{[]string{"run", exit}, 0, "", ""},
+		{[]string{"run", exit, "a", "-b", "c"}, 3, "", ""},
+		{[]string{"run", echo, "a"}, 0, "hello 1\n", "done\n"},
+		{[]string{"run", divide}, 1, "", "integer division by zero"},
+		{[]string{"run", "-h"}, 0, "without -root, every file is accessible", ""},
+		{[]string{"check", good}, 0, "", ""},
+		{[]string{"check", good, bad}, 1, "", bad + ":2:1"},
+		{[]string{"build", good, "-O", "-o", program}, 0, "", ""},
+		{[]string{"run", program}, 0, "", ""},
+		{[]string{"disasm", program}, 0, ".global 0 x", ""},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		status := execute(tt.args, &cli{stdin: strings.NewReader("hello\n"), stdout: &stdout, stderr: &stderr})
+		if status != tt.expectedStatus {
+			t.Errorf("gold %s: wrong status. want=%d, got=%d, stderr=%q", strings.Join(tt.args, " "), tt.expectedStatus, status, stderr.String())
+		}
+		if !strings.Contains(stdout.String(), tt.expectedStdout) {
+			t.Errorf("gold %s: wrong stdout. want=%q in %q", strings.Join(tt.args, " "), tt.expectedStdout, stdout.String())
+		}
+		if !strings.Contains(stderr.String(), tt.expectedStderr) {
+			t.Errorf("gold %s: wrong stderr. want=%q in %q", strings.Join(tt.args, " "), tt.expectedStderr, stderr.String())
+		}
+	}
+}
+
+func TestExecuteRecovers(t *testing.T) {
+	commands = append(commands, &command{name: "crash", summary: "panic", setup: func(*cli, *flag.FlagSet) func([]string) error {
+		return func([]string) error { panic("operands [70000] don't fit OpJump") }
+	}})
+	defer func() { commands = commands[:len(commands)-1] }()
+
+	var stdout, stderr bytes.Buffer
+	status := execute([]string{"crash"}, &cli{stdout: &stdout, stderr: &stderr})
+	if status != 1 {
+		t.Errorf("wrong status. want=1, got=%d", status)
+	}
+	if stderr.String() != "error: operands [70000] don't fit OpJump\n" {
+		t.Errorf("wrong stderr. got=%q", stderr.String())
+	}
+}
@@ -13,17 +13,20 @@ func (ins Instructions) String() string {
 
 	i := 0
 	for i < len(ins) {
-		def, err := Lookup(ins[i])
+		instruction, err := ReadInstruction(ins[i:])
 		if err != nil {
-			fmt.Fprintf(&out, "ERROR: %s\n", err)
-			continue
+			fmt.Fprintf(&out, "%04d ERROR: %s\n", i, err)
+			break
 		}
 
-		operands, read := ReadOperands(def, ins[i+1:])
-
-		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+		def, _ := Lookup(byte(instruction.Op))
+		prefix := ""
+		if instruction.Wide {
+			prefix = "OpWide "
+		}
+		fmt.Fprintf(&out, "%04d %s%s\n", i, prefix, ins.fmtInstruction(def, instruction.Operands))
 
-		i += 1 + read
+		i += instruction.Width
 	}
 
 	return out.String()
@@ -102,6 +105,29 @@ const (
 	OpGetFree
 
 	OpCurrentClosure
+
+	// OpWide prefixes an instruction whose operands are twice as wide as
+	// usual, for indexes, counts and jump targets that don't fit otherwise.
+	OpWide
+
+	// Superinstructions, emitted by the optimizer for common sequences.
+
+	// OpIncGlobal is OpGetGlobal, OpInc and OpSetGlobal of the same global.
+	OpIncGlobal
+	OpDecGlobal
+	OpIncLocal
+	OpDecLocal
+
+	// OpJumpNotGreaterThan is OpGreaterThan followed by OpJumpNotTruthy.
+	OpJumpNotGreaterThan
+	OpJumpNotGreaterEqualThan
+	OpJumpNotEqual
+	// OpJumpEqual is OpNotEqual followed by OpJumpNotTruthy.
+	OpJumpEqual
+
+	// OpTailCall calls a function and returns its result, reusing the frame
+	// of the caller.
+	OpTailCall
 )
 
 type Definition struct {
@@ -160,6 +186,30 @@ var definitions = map[Opcode]*Definition{
 	OpGetFree: {"OpGetFree", []int{1}},
 
 	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+
+	OpWide: {"OpWide", []int{}},
+
+	OpIncGlobal: {"OpIncGlobal", []int{2}},
+	OpDecGlobal: {"OpDecGlobal", []int{2}},
+	OpIncLocal:  {"OpIncLocal", []int{1}},
+	OpDecLocal:  {"OpDecLocal", []int{1}},
+
+	OpJumpNotGreaterThan:      {"OpJumpNotGreaterThan", []int{2}},
+	OpJumpNotGreaterEqualThan: {"OpJumpNotGreaterEqualThan", []int{2}},
+	OpJumpNotEqual:            {"OpJumpNotEqual", []int{2}},
+	OpJumpEqual:               {"OpJumpEqual", []int{2}},
+
+	OpTailCall: {"OpTailCall", []int{1}},
+}
+
+// IsJump reports whether the first operand of op is a jump target.
+func IsJump(op Opcode) bool {
+	switch op {
+	case OpJump, OpJumpNotTruthy,
+		OpJumpNotGreaterThan, OpJumpNotGreaterEqualThan, OpJumpNotEqual, OpJumpEqual:
+		return true
+	}
+	return false
 }
 
 func Lookup(op byte) (*Definition, error) {
@@ -171,11 +221,26 @@ func Lookup(op byte) (*Definition, error) {
 	return def, nil
 }
 
+// LookupName returns the opcode with the given name, such as "OpConstant".
+func LookupName(name string) (Opcode, bool) {
+	for op, def := range definitions {
+		if def.Name == name {
+			return op, true
+		}
+	}
+	return 0, false
+}
+
+// Make encodes an instruction. It panics when an operand doesn't fit its
+// width, use Fits to know if MakeWide is needed instead.
 func Make(op Opcode, operands ...int) []byte {
 	def, ok := definitions[op]
 	if !ok {
 		return []byte{}
 	}
+	if !Fits(op, operands...) {
+		panic(fmt.Sprintf("operands %v don't fit %s", operands, def.Name))
+	}
 
 	instructionLen := 1
 	for _, w := range def.OperandWidths {
@@ -200,12 +265,85 @@ func Make(op Opcode, operands ...int) []byte {
 	return instruction
 }
 
+// MakeWide encodes an instruction prefixed by OpWide, with operands twice as
+// wide as Make would write them. It panics when an operand doesn't fit them.
+func MakeWide(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok || len(def.OperandWidths) == 0 {
+		return []byte{}
+	}
+	if !FitsWide(op, operands...) {
+		panic(fmt.Sprintf("operands %v don't fit OpWide %s", operands, def.Name))
+	}
+
+	instructionLen := 2
+	for _, w := range def.OperandWidths {
+		instructionLen += 2 * w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(OpWide)
+	instruction[1] = byte(op)
+
+	offset := 2
+	for i, o := range operands {
+		width := 2 * def.OperandWidths[i]
+		switch width {
+		case 4:
+			binary.BigEndian.PutUint32(instruction[offset:], uint32(o))
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		}
+		offset += width
+	}
+
+	return instruction
+}
+
+// Fits reports whether the operands can be encoded by Make without being
+// truncated.
+func Fits(op Opcode, operands ...int) bool {
+	return fits(op, 1, operands)
+}
+
+// FitsWide reports whether the operands can be encoded by MakeWide without
+// being truncated.
+func FitsWide(op Opcode, operands ...int) bool {
+	return fits(op, 2, operands)
+}
+
+func fits(op Opcode, scale int, operands []int) bool {
+	def, ok := definitions[op]
+	if !ok {
+		return false
+	}
+
+	for i, o := range operands {
+		if o < 0 || uint64(o) >= 1<<(8*scale*def.OperandWidths[i]) {
+			return false
+		}
+	}
+	return true
+}
+
 func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	return readOperands(def, ins, 1)
+}
+
+// ReadOperandsWide reads the operands of an instruction prefixed by OpWide.
+func ReadOperandsWide(def *Definition, ins Instructions) ([]int, int) {
+	return readOperands(def, ins, 2)
+}
+
+func readOperands(def *Definition, ins Instructions, scale int) ([]int, int) {
 	operands := make([]int, len(def.OperandWidths))
 	offset := 0
 
 	for i, width := range def.OperandWidths {
+		width *= scale
 		switch width {
+		case 4:
+			operands[i] = int(ReadUint32(ins[offset:]))
 		case 2:
 			operands[i] = int(ReadUint16(ins[offset:]))
 		case 1:
@@ -218,8 +356,85 @@ func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
 	return operands, offset
 }
 
+// Instruction is a decoded instruction.
+type Instruction struct {
+	Op       Opcode
+	Operands []int
+	// Wide is set when the instruction is prefixed by OpWide
+	Wide bool
+	// Width is the number of bytes of the instruction, prefix included
+	Width int
+}
+
+// ReadInstruction decodes the instruction at the start of ins, with its
+// OpWide prefix if it has one. Unlike ReadOperands, it fails on undefined
+// opcodes and truncated operands.
+func ReadInstruction(ins Instructions) (Instruction, error) {
+	if len(ins) == 0 {
+		return Instruction{}, fmt.Errorf("no instruction to read")
+	}
+
+	scale, start := 1, 0
+	if Opcode(ins[0]) == OpWide {
+		scale, start = 2, 1
+		if len(ins) < 2 {
+			return Instruction{}, fmt.Errorf("OpWide is not followed by an instruction")
+		}
+	}
+
+	def, err := Lookup(ins[start])
+	if err != nil {
+		return Instruction{}, err
+	}
+	if scale == 2 && len(def.OperandWidths) == 0 {
+		return Instruction{}, fmt.Errorf("OpWide before %s, which has no operand", def.Name)
+	}
+
+	width := start + 1
+	for _, w := range def.OperandWidths {
+		width += scale * w
+	}
+	if len(ins) < width {
+		return Instruction{}, fmt.Errorf("operands of %s are truncated", def.Name)
+	}
+
+	operands, _ := readOperands(def, ins[start+1:], scale)
+	return Instruction{Op: Opcode(ins[start]), Operands: operands, Wide: scale == 2, Width: width}, nil
+}
+
+// Decode reads every instruction of ins.
+func Decode(ins Instructions) ([]Instruction, error) {
+	var instructions []Instruction
+	for offset := 0; offset < len(ins); {
+		instruction, err := ReadInstruction(ins[offset:])
+		if err != nil {
+			return nil, fmt.Errorf("at %04d: %w", offset, err)
+		}
+		instructions = append(instructions, instruction)
+		offset += instruction.Width
+	}
+	return instructions, nil
+}
+
+// Encode writes instructions back to bytes, keeping their wide prefix.
+func Encode(instructions []Instruction) Instructions {
+	out := Instructions{}
+	for _, instruction := range instructions {
+		if instruction.Wide {
+			out = append(out, MakeWide(instruction.Op, instruction.Operands...)...)
+		} else {
+			out = append(out, Make(instruction.Op, instruction.Operands...)...)
+		}
+	}
+	return out
+}
+
 func ReadUint8(ins Instructions) uint8 { return uint8(ins[0]) }
 
 func ReadUint16(ins Instructions) uint16 {
 	return binary.BigEndian.Uint16(ins)
 }
+
+func ReadUint32(ins Instructions) uint32 {
+	return binary.BigEndian.Uint32(ins)
+}
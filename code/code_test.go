@@ -1,6 +1,9 @@
 package code
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func TestMake(t *testing.T) {
 	tests := []struct {
@@ -38,6 +41,7 @@ func TestInstructionsString(t *testing.T) {
 		Make(OpConstant, 2),
 		Make(OpConstant, 65535),
 		Make(OpClosure, 65535, 255),
+		MakeWide(OpConstant, 65536),
 	}
 
 	expected := `0000 OpAdd
@@ -45,6 +49,7 @@ func TestInstructionsString(t *testing.T) {
 0003 OpConstant 2
 0006 OpConstant 65535
 0009 OpClosure 65535 255
+0013 OpWide OpConstant 65536
 `
 
 	concatted := Instructions{}
@@ -89,3 +94,156 @@ func TestReadOperands(t *testing.T) {
 		}
 	}
 }
+
+func TestReadInstruction(t *testing.T) {
+	tests := []struct {
+		ins      Instructions
+		expected Instruction
+		err      string
+	}{
+		{Make(OpAdd), Instruction{OpAdd, []int{}, false, 1}, ""},
+		{Make(OpClosure, 65535, 255), Instruction{OpClosure, []int{65535, 255}, false, 4}, ""},
+		{append(Make(OpJump, 3), Make(OpPop)...), Instruction{OpJump, []int{3}, false, 3}, ""},
+		{MakeWide(OpConstant, 65536), Instruction{OpConstant, []int{65536}, true, 6}, ""},
+		{MakeWide(OpClosure, 1<<32-1, 256), Instruction{OpClosure, []int{1<<32 - 1, 256}, true, 8}, ""},
+		{Make(OpConstant, 1)[:2], Instruction{}, "operands of OpConstant are truncated"},
+		{MakeWide(OpGetLocal, 300)[:3], Instruction{}, "operands of OpGetLocal are truncated"},
+		{Instructions{byte(OpWide), byte(OpPop)}, Instruction{}, "OpWide before OpPop, which has no operand"},
+		{Instructions{byte(OpWide), byte(OpWide)}, Instruction{}, "OpWide before OpWide, which has no operand"},
+		{Instructions{byte(OpWide)}, Instruction{}, "OpWide is not followed by an instruction"},
+		{Instructions{255}, Instruction{}, "opcode 255 undefined"},
+		{Instructions{}, Instruction{}, "no instruction to read"},
+	}
+
+	for _, tt := range tests {
+		instruction, err := ReadInstruction(tt.ins)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("wrong error. want=%q, got=%v", tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if !reflect.DeepEqual(instruction, tt.expected) {
+			t.Errorf("wrong instruction. want=%+v, got=%+v", tt.expected, instruction)
+		}
+	}
+}
+
+func TestMakeWide(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		expected []byte
+	}{
+		{OpConstant, []int{65536}, []byte{byte(OpWide), byte(OpConstant), 0, 1, 0, 0}},
+		{OpGetLocal, []int{256}, []byte{byte(OpWide), byte(OpGetLocal), 1, 0}},
+		{OpClosure, []int{65536, 256}, []byte{byte(OpWide), byte(OpClosure), 0, 1, 0, 0, 1, 0}},
+		{OpPop, []int{}, []byte{}},
+	}
+
+	for _, tt := range tests {
+		instruction := MakeWide(tt.op, tt.operands...)
+		if !reflect.DeepEqual(instruction, tt.expected) {
+			t.Errorf("wrong instruction. want=%v, got=%v", tt.expected, instruction)
+		}
+	}
+}
+
+func TestMakeOutOfRange(t *testing.T) {
+	tests := []struct {
+		make     func(Opcode, ...int) []byte
+		op       Opcode
+		operands []int
+	}{
+		{Make, OpJump, []int{65536}},
+		{Make, OpGetLocal, []int{256}},
+		{MakeWide, OpJump, []int{1 << 32}},
+		{MakeWide, OpConstant, []int{-1}},
+	}
+
+	for _, tt := range tests {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("operands %v of %d were truncated", tt.operands, tt.op)
+				}
+			}()
+			tt.make(tt.op, tt.operands...)
+		}()
+	}
+}
+
+func TestFits(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		fits     bool
+		fitsWide bool
+	}{
+		{OpConstant, []int{65535}, true, true},
+		{OpConstant, []int{65536}, false, true},
+		{OpJump, []int{1<<32 - 1}, false, true},
+		{OpJump, []int{1 << 32}, false, false},
+		{OpGetLocal, []int{255}, true, true},
+		{OpGetLocal, []int{256}, false, true},
+		{OpCall, []int{65536}, false, false},
+		{OpClosure, []int{1, 256}, false, true},
+		{OpConstant, []int{-1}, false, false},
+	}
+
+	for _, tt := range tests {
+		if Fits(tt.op, tt.operands...) != tt.fits {
+			t.Errorf("Fits(%d, %v) should be %t", tt.op, tt.operands, tt.fits)
+		}
+		if FitsWide(tt.op, tt.operands...) != tt.fitsWide {
+			t.Errorf("FitsWide(%d, %v) should be %t", tt.op, tt.operands, tt.fitsWide)
+		}
+	}
+}
+
+func TestLookupName(t *testing.T) {
+	for op, def := range definitions {
+		found, ok := LookupName(def.Name)
+		if !ok || found != op {
+			t.Errorf("wrong opcode for %s. want=%d, got=%d (%t)", def.Name, op, found, ok)
+		}
+	}
+
+	if _, ok := LookupName("OpUnknown"); ok {
+		t.Errorf("LookupName found an undefined opcode")
+	}
+}
+
+func TestDecodeEncode(t *testing.T) {
+	ins := Instructions{}
+	for _, i := range [][]byte{
+		Make(OpConstant, 1),
+		MakeWide(OpGetLocal, 300),
+		Make(OpJumpNotTruthy, 0),
+		MakeWide(OpJump, 2),
+		Make(OpPop),
+	} {
+		ins = append(ins, i...)
+	}
+
+	decoded, err := Decode(ins)
+	if err != nil {
+		t.Fatalf("Decode returned an error: %s", err)
+	}
+	if len(decoded) != 5 || !decoded[1].Wide || decoded[1].Operands[0] != 300 {
+		t.Errorf("wrong instructions. got=%+v", decoded)
+	}
+
+	if encoded := Encode(decoded); !reflect.DeepEqual(encoded, ins) {
+		t.Errorf("wrong encoding.\nwant=%s\ngot =%s", ins, encoded)
+	}
+
+	_, err = Decode(append(ins, 255))
+	if err == nil || err.Error() != "at 0017: opcode 255 undefined" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+}
@@ -0,0 +1,599 @@
+package cold
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"gold/code"
+	"gold/compiler"
+	"gold/object"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+const (
+	Magic        = "COLD"
+	MajorVersion = 1
+	MinorVersion = 2
+
+	headerSize = 18
+)
+
+const (
+	flagDebug = 1 << 0
+	flagUnit  = 1 << 1
+)
+
+const (
+	sectionConstants byte = iota + 1
+	sectionFunctions
+	sectionMain
+	sectionDebug
+	sectionNames
+	sectionUnit
+)
+
+const (
+	tagInteger byte = iota + 1
+	tagFloat
+	tagString
+	tagBoolean
+	tagNull
+	tagFunction
+)
+
+var (
+	ErrBadMagic = errors.New("cold: not a cold file")
+	ErrChecksum = errors.New("cold: checksum mismatch")
+	ErrCorrupt  = errors.New("cold: corrupt file")
+	// ErrUnit is returned by Decode for a unit, which must be linked before
+	// it runs, and ErrNotUnit by DecodeUnit for a program.
+	ErrUnit    = errors.New("cold: file is a unit, link it to run it")
+	ErrNotUnit = errors.New("cold: file is not a unit")
+)
+
+// VersionError is returned when a file was written with an incompatible
+// version of the format.
+type VersionError struct {
+	Major, Minor int
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("cold: unsupported version %d.%d, this reader supports %d.x",
+		e.Major, e.Minor, MajorVersion)
+}
+
+// Debug holds the information that isn't needed to run a program.
+type Debug struct {
+	// Globals are the names of the globals, by index. Globals without a
+	// name, such as shadowed ones, are empty.
+	Globals []string
+}
+
+// Encode writes bytecode to w. The debug section is written only if debug is
+// not nil.
+func Encode(w io.Writer, bytecode *compiler.Bytecode, debug *Debug) error {
+	payload, err := programPayload(bytecode)
+	if err != nil {
+		return err
+	}
+
+	var flags uint16
+	if debug != nil {
+		flags |= flagDebug
+
+		var globals []byte
+		count := 0
+		for _, name := range debug.Globals {
+			if name != "" {
+				count++
+			}
+		}
+		globals = binary.AppendUvarint(globals, uint64(count))
+		for i, name := range debug.Globals {
+			if name != "" {
+				globals = binary.AppendUvarint(globals, uint64(i))
+				globals = appendString(globals, name)
+			}
+		}
+		payload = appendSection(payload, sectionDebug, globals)
+	}
+
+	return write(w, flags, payload)
+}
+
+// EncodeUnit writes a unit to w, with the sections of its bytecode followed by
+// the unit section.
+func EncodeUnit(w io.Writer, unit *compiler.Unit) error {
+	payload, err := programPayload(unit.Bytecode)
+	if err != nil {
+		return err
+	}
+	payload = appendSection(payload, sectionUnit, appendUnit(nil, unit))
+	return write(w, flagUnit, payload)
+}
+
+// programPayload returns the constants, functions, main and names sections of
+// bytecode.
+func programPayload(bytecode *compiler.Bytecode) ([]byte, error) {
+	var functions []*object.CompiledFunction
+	functionIndex := map[*object.CompiledFunction]int{}
+
+	var constants []byte
+	constants = binary.AppendUvarint(constants, uint64(len(bytecode.Constants)))
+	for i, constant := range bytecode.Constants {
+		switch constant := constant.(type) {
+		case *object.Integer:
+			constants = append(constants, tagInteger)
+			constants = binary.AppendVarint(constants, constant.Value)
+		case *object.Float:
+			constants = append(constants, tagFloat)
+			constants = binary.BigEndian.AppendUint64(constants, math.Float64bits(constant.Value))
+		case *object.String:
+			constants = append(constants, tagString)
+			constants = appendString(constants, constant.Value)
+		case *object.Boolean:
+			constants = append(constants, tagBoolean)
+			if constant.Value {
+				constants = append(constants, 1)
+			} else {
+				constants = append(constants, 0)
+			}
+		case *object.Null:
+			constants = append(constants, tagNull)
+		case *object.CompiledFunction:
+			index, ok := functionIndex[constant]
+			if !ok {
+				index = len(functions)
+				functionIndex[constant] = index
+				functions = append(functions, constant)
+			}
+			constants = append(constants, tagFunction)
+			constants = binary.AppendUvarint(constants, uint64(index))
+		default:
+			return nil, fmt.Errorf("cold: constant %d has unsupported type %s", i, constant.Type())
+		}
+	}
+
+	var table []byte
+	table = binary.AppendUvarint(table, uint64(len(functions)))
+	for _, fn := range functions {
+		table = binary.AppendUvarint(table, uint64(fn.NumLocals))
+		table = binary.AppendUvarint(table, uint64(fn.NumParameters))
+		table = appendBytes(table, fn.Instructions)
+	}
+
+	var payload []byte
+	payload = appendSection(payload, sectionConstants, constants)
+	payload = appendSection(payload, sectionFunctions, table)
+	payload = appendSection(payload, sectionMain, appendBytes(nil, bytecode.Instructions))
+
+	var names []byte
+	named := 0
+	for i, fn := range functions {
+		if fn.Name != "" {
+			names = binary.AppendUvarint(names, uint64(i))
+			names = appendString(names, fn.Name)
+			named++
+		}
+	}
+	if named > 0 {
+		payload = appendSection(payload, sectionNames, append(binary.AppendUvarint(nil, uint64(named)), names...))
+	}
+	return payload, nil
+}
+
+// write writes the header and the payload.
+func write(w io.Writer, flags uint16, payload []byte) error {
+	if uint64(len(payload)) > math.MaxUint32 {
+		return fmt.Errorf("cold: program is too large")
+	}
+
+	header := make([]byte, 0, headerSize)
+	header = append(header, Magic...)
+	header = binary.BigEndian.AppendUint16(header, MajorVersion)
+	header = binary.BigEndian.AppendUint16(header, MinorVersion)
+	header = binary.BigEndian.AppendUint16(header, flags)
+	header = binary.BigEndian.AppendUint32(header, uint32(len(payload)))
+	header = binary.BigEndian.AppendUint32(header, crc32.ChecksumIEEE(payload))
+
+	_, err := w.Write(append(header, payload...))
+	return err
+}
+
+// Decode reads a program written by Encode. The returned debug information
+// is nil when the file has none.
+func Decode(r io.Reader) (*compiler.Bytecode, *Debug, error) {
+	flags, payload, err := read(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	if flags&flagUnit != 0 {
+		return nil, nil, ErrUnit
+	}
+
+	d := &decoder{buf: payload}
+	bytecode, debug, err := d.program(flags)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bytecode, debug, nil
+}
+
+// DecodeUnit reads a unit written by EncodeUnit.
+func DecodeUnit(r io.Reader) (*compiler.Unit, error) {
+	flags, payload, err := read(r)
+	if err != nil {
+		return nil, err
+	}
+	if flags&flagUnit == 0 {
+		return nil, ErrNotUnit
+	}
+
+	d := &decoder{buf: payload}
+	bytecode, _, err := d.program(flags)
+	if err != nil {
+		return nil, err
+	}
+	unit, err := (&decoder{buf: d.unitSection}).unit()
+	if err != nil {
+		return nil, err
+	}
+	unit.Bytecode = bytecode
+	return unit, nil
+}
+
+// read checks the header and returns the flags and the payload.
+func read(r io.Reader) (uint16, []byte, error) {
+	header := make([]byte, headerSize)
+	_, err := io.ReadFull(r, header)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return 0, nil, ErrBadMagic
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if string(header[:4]) != Magic {
+		return 0, nil, ErrBadMagic
+	}
+
+	major := binary.BigEndian.Uint16(header[4:])
+	minor := binary.BigEndian.Uint16(header[6:])
+	if major != MajorVersion {
+		return 0, nil, &VersionError{Major: int(major), Minor: int(minor)}
+	}
+
+	flags := binary.BigEndian.Uint16(header[8:])
+	length := binary.BigEndian.Uint32(header[10:])
+	checksum := binary.BigEndian.Uint32(header[14:])
+
+	payload, err := io.ReadAll(io.LimitReader(r, int64(length)+1))
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(payload) != int(length) {
+		return 0, nil, corrupt("payload is %d bytes long, header says %d", len(payload), length)
+	}
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return 0, nil, ErrChecksum
+	}
+	return flags, payload, nil
+}
+
+type decoder struct {
+	buf []byte
+	// unit is the body of the unit section, read by program
+	unitSection []byte
+}
+
+func (d *decoder) program(flags uint16) (*compiler.Bytecode, *Debug, error) {
+	hasDebug := flags&flagDebug != 0
+	sections := map[byte][]byte{}
+	next := sectionConstants
+	for len(d.buf) > 0 {
+		id, err := d.byte()
+		if err != nil {
+			return nil, nil, err
+		}
+		body, err := d.bytes()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		_, seen := sections[id]
+		switch {
+		case id < sectionConstants || id > sectionUnit:
+			// Written by a later minor version
+			continue
+		case seen:
+			return nil, nil, corrupt("section %d appears twice", id)
+		case id <= sectionMain && id != next:
+			return nil, nil, corrupt("section %d is out of order", id)
+		}
+		sections[id] = body
+		if id == next {
+			next++
+		}
+	}
+
+	if next <= sectionMain {
+		return nil, nil, corrupt("section %d is missing", next)
+	}
+	if _, ok := sections[sectionDebug]; ok != hasDebug {
+		return nil, nil, corrupt("debug flag doesn't match the sections")
+	}
+	if _, ok := sections[sectionUnit]; ok != (flags&flagUnit != 0) {
+		return nil, nil, corrupt("unit flag doesn't match the sections")
+	}
+	d.unitSection = sections[sectionUnit]
+
+	functions, err := (&decoder{buf: sections[sectionFunctions]}).functions()
+	if err != nil {
+		return nil, nil, err
+	}
+	err = (&decoder{buf: sections[sectionNames]}).names(functions)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	constants, err := (&decoder{buf: sections[sectionConstants]}).constants(functions)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	main := &decoder{buf: sections[sectionMain]}
+	instructions, err := main.bytes()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := main.end("main"); err != nil {
+		return nil, nil, err
+	}
+
+	bytecode := &compiler.Bytecode{
+		Instructions: code.Instructions(instructions),
+		Constants:    constants,
+	}
+
+	if !hasDebug {
+		return bytecode, nil, nil
+	}
+	// Every global is set by an instruction of at least three bytes, which
+	// bounds the indexes of the names.
+	maxGlobals := len(instructions)
+	for _, fn := range functions {
+		maxGlobals += len(fn.Instructions)
+	}
+	debug, err := (&decoder{buf: sections[sectionDebug]}).debug(maxGlobals)
+	if err != nil {
+		return nil, nil, err
+	}
+	return bytecode, debug, nil
+}
+
+func (d *decoder) functions() ([]*object.CompiledFunction, error) {
+	count, err := d.count()
+	if err != nil {
+		return nil, err
+	}
+
+	functions := make([]*object.CompiledFunction, count)
+	for i := range functions {
+		locals, err := d.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		params, err := d.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		if params > locals {
+			return nil, corrupt("function %d has %d parameters but %d locals", i, params, locals)
+		}
+		instructions, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+
+		functions[i] = &object.CompiledFunction{
+			Instructions:  code.Instructions(instructions),
+			NumLocals:     locals,
+			NumParameters: params,
+		}
+	}
+
+	return functions, d.end("functions")
+}
+
+// names sets the names of the functions. The section is optional, and
+// only lists the functions with a name.
+func (d *decoder) names(functions []*object.CompiledFunction) error {
+	if d.buf == nil {
+		return nil
+	}
+	count, err := d.count()
+	if err != nil {
+		return err
+	}
+
+	next := 0
+	for i := 0; i < count; i++ {
+		index, err := d.uvarint()
+		if err != nil {
+			return err
+		}
+		name, err := d.bytes()
+		if err != nil {
+			return err
+		}
+		if index < next || index >= len(functions) {
+			return corrupt("function index %d is out of order or out of range", index)
+		}
+		functions[index].Name = string(name)
+		next = index + 1
+	}
+
+	return d.end("names")
+}
+
+func (d *decoder) constants(functions []*object.CompiledFunction) ([]object.Object, error) {
+	count, err := d.count()
+	if err != nil {
+		return nil, err
+	}
+
+	constants := make([]object.Object, count)
+	for i := range constants {
+		tag, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch tag {
+		case tagInteger:
+			value, n := binary.Varint(d.buf)
+			if n <= 0 {
+				return nil, corrupt("invalid integer constant %d", i)
+			}
+			d.buf = d.buf[n:]
+			constants[i] = &object.Integer{Value: value}
+		case tagFloat:
+			if len(d.buf) < 8 {
+				return nil, corrupt("truncated float constant %d", i)
+			}
+			constants[i] = &object.Float{Value: math.Float64frombits(binary.BigEndian.Uint64(d.buf))}
+			d.buf = d.buf[8:]
+		case tagString:
+			value, err := d.bytes()
+			if err != nil {
+				return nil, err
+			}
+			constants[i] = &object.String{Value: string(value)}
+		case tagBoolean:
+			value, err := d.byte()
+			if err != nil {
+				return nil, err
+			}
+			if value > 1 {
+				return nil, corrupt("invalid boolean constant %d", i)
+			}
+			constants[i] = &object.Boolean{Value: value == 1}
+		case tagNull:
+			constants[i] = &object.Null{}
+		case tagFunction:
+			index, err := d.uvarint()
+			if err != nil {
+				return nil, err
+			}
+			if index >= len(functions) {
+				return nil, corrupt("constant %d refers to function %d, the table has %d", i, index, len(functions))
+			}
+			constants[i] = functions[index]
+		default:
+			return nil, corrupt("constant %d has unknown tag %d", i, tag)
+		}
+	}
+
+	return constants, d.end("constants")
+}
+
+func (d *decoder) debug(maxGlobals int) (*Debug, error) {
+	count, err := d.count()
+	if err != nil {
+		return nil, err
+	}
+
+	debug := &Debug{}
+	for i := 0; i < count; i++ {
+		index, err := d.uvarint()
+		if err != nil {
+			return nil, err
+		}
+		name, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		if index < len(debug.Globals) || index >= maxGlobals {
+			return nil, corrupt("global index %d is out of order or out of range", index)
+		}
+		for len(debug.Globals) <= index {
+			debug.Globals = append(debug.Globals, "")
+		}
+		debug.Globals[index] = string(name)
+	}
+
+	return debug, d.end("debug")
+}
+
+func (d *decoder) byte() (byte, error) {
+	if len(d.buf) == 0 {
+		return 0, corrupt("unexpected end of data")
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b, nil
+}
+
+func (d *decoder) uvarint() (int, error) {
+	value, n := binary.Uvarint(d.buf)
+	if n <= 0 || value > math.MaxInt32 {
+		return 0, corrupt("invalid unsigned integer")
+	}
+	d.buf = d.buf[n:]
+	return int(value), nil
+}
+
+// count reads a number of elements, each of them taking at least one byte.
+func (d *decoder) count() (int, error) {
+	count, err := d.uvarint()
+	if err != nil {
+		return 0, err
+	}
+	if count > len(d.buf) {
+		return 0, corrupt("%d elements announced in %d bytes", count, len(d.buf))
+	}
+	return count, nil
+}
+
+// bytes reads a length prefixed byte slice.
+func (d *decoder) bytes() ([]byte, error) {
+	length, err := d.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	if length > len(d.buf) {
+		return nil, corrupt("unexpected end of data")
+	}
+	b := bytes.Clone(d.buf[:length])
+	d.buf = d.buf[length:]
+	return b, nil
+}
+
+func (d *decoder) end(section string) error {
+	if len(d.buf) != 0 {
+		return corrupt("%d unexpected bytes at the end of the %s section", len(d.buf), section)
+	}
+	return nil
+}
+
+func appendSection(b []byte, id byte, body []byte) []byte {
+	b = append(b, id)
+	return appendBytes(b, body)
+}
+
+func appendBytes(b []byte, data []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func corrupt(format string, a ...any) error {
+	return fmt.Errorf("%w: %s", ErrCorrupt, fmt.Sprintf(format, a...))
+}
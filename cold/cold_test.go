@@ -0,0 +1,264 @@
+package cold
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"gold/code"
+	"gold/compiler"
+	"gold/lexer"
+	"gold/object"
+	"gold/parser"
+	"gold/vm"
+	"hash/crc32"
+	"reflect"
+	"testing"
+)
+
+const program = `
+let greeting = "hello";
+lflt ratio = 0.5;
+let add = fn(mint a, mint b) { return a + b };
+may newAdder = fn(mint a) {
+  return fn(mint b) { return a + b };
+};
+may addTwo = newAdder(2);
+[add(-40, 2), ratio, greeting, addTwo(3)]`
+
+func TestRoundTrip(t *testing.T) {
+	comp := compile(t, program)
+	bytecode := comp.Bytecode()
+
+	var buf bytes.Buffer
+	err := Encode(&buf, bytecode, &Debug{Globals: comp.GlobalNames()})
+	if err != nil {
+		t.Fatalf("Encode returned an error: %s", err)
+	}
+
+	decoded, debug, err := Decode(&buf)
+	if err != nil {
+		t.Fatalf("Decode returned an error: %s", err)
+	}
+
+	if !reflect.DeepEqual(decoded, bytecode) {
+		t.Errorf("decoded bytecode differs.\nwant=%+v\ngot =%+v", bytecode, decoded)
+	}
+
+	expected := []string{"greeting", "ratio", "add", "newAdder", "addTwo"}
+	if debug == nil || !reflect.DeepEqual(debug.Globals, expected) {
+		t.Errorf("wrong debug information. want=%q, got=%+v", expected, debug)
+	}
+
+	machine := vm.New(decoded)
+	err = machine.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	result := machine.LastPoppedStackElem().Inspect()
+	if result != `[-38, 0.500000, hello, 5]` {
+		t.Errorf("wrong result. got=%s", result)
+	}
+}
+
+func TestRoundTripConstants(t *testing.T) {
+	fn := &object.CompiledFunction{Instructions: code.Instructions{}, NumLocals: 2, NumParameters: 1}
+	bytecode := &compiler.Bytecode{
+		Instructions: []byte{},
+		Constants: []object.Object{
+			&object.Integer{Value: -1 << 63},
+			&object.Float{Value: -0.25},
+			&object.String{Value: "héllo"},
+			&object.Boolean{Value: true},
+			&object.Null{},
+			fn,
+			fn,
+		},
+	}
+
+	var buf bytes.Buffer
+	err := Encode(&buf, bytecode, nil)
+	if err != nil {
+		t.Fatalf("Encode returned an error: %s", err)
+	}
+
+	decoded, debug, err := Decode(&buf)
+	if err != nil {
+		t.Fatalf("Decode returned an error: %s", err)
+	}
+	if debug != nil {
+		t.Errorf("debug information without a debug section: %+v", debug)
+	}
+	if !reflect.DeepEqual(decoded.Constants, bytecode.Constants) {
+		t.Errorf("wrong constants.\nwant=%+v\ngot =%+v", bytecode.Constants, decoded.Constants)
+	}
+	if decoded.Constants[5] != decoded.Constants[6] {
+		t.Errorf("a function shared by two constants was duplicated")
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	var buf bytes.Buffer
+	err := Encode(&buf, compile(t, program).Bytecode(), nil)
+	if err != nil {
+		t.Fatalf("Encode returned an error: %s", err)
+	}
+	valid := buf.Bytes()
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{"empty", []byte{}, ErrBadMagic},
+		{"gob", []byte("\x0f\xff\x81\x03\x01\x01\x08Bytecode\x01\xff\x82\x00"), ErrBadMagic},
+		{"flipped bit", modify(valid, func(b []byte) { b[len(b)-1] ^= 1 }), ErrChecksum},
+		{"truncated", valid[:len(valid)-1], ErrCorrupt},
+		{"unknown tag", withPayload(valid, []byte{1, 2, 1, 9, 2, 1, 0, 3, 1, 0}), ErrCorrupt},
+		{"missing main", withPayload(valid, []byte{1, 1, 0, 2, 1, 0}), ErrCorrupt},
+		{"bad function index", withPayload(valid, []byte{1, 2, 1, 6, 0, 2, 1, 0, 3, 1, 0}), ErrCorrupt},
+		{"bad name index", withPayload(valid, []byte{1, 1, 0, 2, 1, 0, 3, 1, 0, 5, 3, 1, 0, 0}), ErrCorrupt},
+	}
+
+	for _, tt := range tests {
+		_, _, err := Decode(bytes.NewReader(tt.data))
+		if !errors.Is(err, tt.expected) {
+			t.Errorf("%s: wrong error. want=%v, got=%v", tt.name, tt.expected, err)
+		}
+	}
+}
+
+func TestDecodeVersions(t *testing.T) {
+	var buf bytes.Buffer
+	err := Encode(&buf, compile(t, "1 + 2").Bytecode(), nil)
+	if err != nil {
+		t.Fatalf("Encode returned an error: %s", err)
+	}
+	valid := buf.Bytes()
+
+	newer := modify(valid, func(b []byte) { binary.BigEndian.PutUint16(b[4:], MajorVersion+1) })
+	_, _, err = Decode(bytes.NewReader(newer))
+	var versionErr *VersionError
+	if !errors.As(err, &versionErr) || versionErr.Major != MajorVersion+1 {
+		t.Errorf("wrong error for another major version. got=%v", err)
+	}
+
+	// A later minor version may add sections, which are skipped
+	payload := append(bytes.Clone(valid[headerSize:]), 42, 2, 0xca, 0xfe)
+	minor := withPayload(valid, payload)
+	binary.BigEndian.PutUint16(minor[6:], MinorVersion+1)
+	bytecode, _, err := Decode(bytes.NewReader(minor))
+	if err != nil {
+		t.Fatalf("a later minor version is rejected: %s", err)
+	}
+	if len(bytecode.Constants) != 2 {
+		t.Errorf("wrong constants. got=%+v", bytecode.Constants)
+	}
+}
+
+// unitResolver gives the same unit for every import.
+type unitResolver struct{ unit *compiler.Unit }
+
+func (r unitResolver) ResolveUnit(from, path string) (*compiler.Unit, error) {
+	return r.unit, nil
+}
+
+func TestRoundTripUnit(t *testing.T) {
+	lib := compiler.New()
+	lib.SetUnitResolver(unitResolver{}, "lib.gold")
+	_, err := lib.Compile(parser.New(lexer.New(`let apply = fn(lint x) { return map([x], fn(lint y) { return y }) }; may _hidden = 1`)).ParseProgram())
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	comp := compiler.New()
+	comp.SetUnitResolver(unitResolver{lib.Unit()}, "main.gold")
+	_, err = comp.Compile(parser.New(lexer.New(`import "lib"; let result = apply(2); result`)).ParseProgram())
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	unit := comp.Unit()
+	unit.SourceHash[0] = 42
+
+	var buf bytes.Buffer
+	err = EncodeUnit(&buf, unit)
+	if err != nil {
+		t.Fatalf("EncodeUnit returned an error: %s", err)
+	}
+	data := buf.Bytes()
+
+	decoded, err := DecodeUnit(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("DecodeUnit returned an error: %s", err)
+	}
+	if !reflect.DeepEqual(decoded.Bytecode, unit.Bytecode) {
+		t.Errorf("decoded bytecode differs.\nwant=%+v\ngot =%+v", unit.Bytecode, decoded.Bytecode)
+	}
+	if decoded.Name != "main.gold" || decoded.SourceHash != unit.SourceHash ||
+		!reflect.DeepEqual(decoded.Globals, unit.Globals) || !reflect.DeepEqual(decoded.Builtins, unit.Builtins) ||
+		!reflect.DeepEqual(decoded.Imports, unit.Imports) || !reflect.DeepEqual(decoded.Externals, unit.Externals) {
+		t.Errorf("decoded unit differs.\nwant=%+v\ngot =%+v", unit, decoded)
+	}
+	if len(decoded.Exports) != 1 || decoded.Exports[0].Name != "result" || decoded.ExportsHash() != unit.ExportsHash() {
+		t.Errorf("wrong exports. want=%+v, got=%+v", unit.Exports, decoded.Exports)
+	}
+
+	// The types of the exports keep the functions they take
+	var libBuf bytes.Buffer
+	err = EncodeUnit(&libBuf, lib.Unit())
+	if err != nil {
+		t.Fatalf("EncodeUnit returned an error: %s", err)
+	}
+	decodedLib, err := DecodeUnit(&libBuf)
+	if err != nil {
+		t.Fatalf("DecodeUnit returned an error: %s", err)
+	}
+	if decodedLib.ExportsHash() != lib.Unit().ExportsHash() {
+		t.Errorf("wrong exports. want=%+v, got=%+v", lib.Unit().Exports, decodedLib.Exports)
+	}
+
+	_, _, err = Decode(bytes.NewReader(data))
+	if err != ErrUnit {
+		t.Errorf("expected ErrUnit when decoding a unit as a program, got=%v", err)
+	}
+	buf.Reset()
+	err = Encode(&buf, unit.Bytecode, nil)
+	if err != nil {
+		t.Fatalf("Encode returned an error: %s", err)
+	}
+	_, err = DecodeUnit(&buf)
+	if err != ErrNotUnit {
+		t.Errorf("expected ErrNotUnit when decoding a program as a unit, got=%v", err)
+	}
+}
+
+func compile(t *testing.T, input string) *compiler.Compiler {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parse errors: %v", p.Errors())
+	}
+
+	comp := compiler.New()
+	_, err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return comp
+}
+
+func modify(data []byte, f func([]byte)) []byte {
+	data = bytes.Clone(data)
+	f(data)
+	return data
+}
+
+// withPayload replaces the payload of a valid file, keeping the header
+// consistent.
+func withPayload(valid []byte, payload []byte) []byte {
+	data := append(bytes.Clone(valid[:headerSize]), payload...)
+	binary.BigEndian.PutUint32(data[10:], uint32(len(payload)))
+	binary.BigEndian.PutUint32(data[14:], crc32.ChecksumIEEE(payload))
+	return data
+}
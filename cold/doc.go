@@ -0,0 +1,65 @@
+// Package cold reads and writes compiled Gold programs, the `.cold` files.
+//
+// # Layout
+//
+// A file starts with a fixed 18 bytes header, followed by the payload. All
+// fixed size integers are big endian, like the operands of the instructions.
+//
+//	magic    [4]byte  "COLD"
+//	major    uint16   incremented on incompatible changes
+//	minor    uint16   incremented when sections are added
+//	flags    uint16   bit 0 is set when the payload has a debug section,
+//	                  bit 1 when the file is a unit (since 1.2)
+//	length   uint32   length of the payload in bytes
+//	checksum uint32   CRC-32 (IEEE) of the payload
+//
+// The payload is a sequence of sections. Each one starts with its id on one
+// byte and the length of its body as an unsigned varint. Variable sized
+// integers use the encoding of encoding/binary: uvarint for counts, lengths
+// and indexes, and zig-zag varint for integer constants.
+//
+//	1 constants   count, then for each constant a type tag and its value
+//	2 functions   count, then for each function the number of locals, the
+//	              number of parameters, the length of the instructions and
+//	              the instructions
+//	3 main        length of the main instructions and the instructions
+//	4 debug       count, then for each global its index and name
+//	5 names       count, then for each named function its index in the
+//	              function table and its name (since 1.1)
+//	6 unit        the name of the module, the hash of its source on 32
+//	              bytes, the names of the globals, the names of the
+//	              builtins, then the imports, exports and externals (since
+//	              1.2)
+//
+// The constants, functions and main sections are required and appear once,
+// in this order. The debug and names sections are optional, the names
+// section is written when at least one function has a name. A string is its
+// length in bytes followed by its UTF-8 bytes.
+//
+// A unit is a module compiled on its own, see compiler.Unit, which must be
+// linked before it runs: Decode rejects it with ErrUnit. Its unit section
+// lists the names of the imported modules, each one followed by the 32
+// bytes hash of the exports it was compiled against, the exports as their
+// name, the index of their global and their type, and the externals as the
+// name of the module and of the global that set them, and the index of the
+// global. A type is its object type as a string, a byte of flags (1
+// nullable, 2 function, 4 variadic, 8 promote, 16 followed by the type of
+// the function returned), and the count of its arguments, each one being its
+// object type, a byte of flags (1 nullable, 16 followed by the type of the
+// function expected) and that type.
+//
+// The constant tags are:
+//
+//	1 integer     varint
+//	2 float       uint64, the IEEE 754 bits of the value
+//	3 string      string
+//	4 boolean     one byte, 0 or 1
+//	5 null        nothing
+//	6 function    uvarint, index in the function table
+//
+// # Compatibility
+//
+// A reader accepts every file with its own major version. Sections it
+// doesn't know, written by a later minor version, are skipped. Files with
+// another major version are rejected with a *VersionError.
+package cold
@@ -0,0 +1,276 @@
+package cold
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"gold/compiler"
+	"gold/object"
+)
+
+// maxAttributeDepth bounds the nesting of the function types of a unit, so a
+// corrupt file can't exhaust the stack of the decoder.
+const maxAttributeDepth = 64
+
+const (
+	attributeNullable byte = 1 << iota
+	attributeIsFunction
+	attributeVariadic
+	attributePromote
+	attributeHasFunction
+)
+
+func appendUnit(b []byte, unit *compiler.Unit) []byte {
+	b = appendString(b, unit.Name)
+	b = append(b, unit.SourceHash[:]...)
+
+	b = binary.AppendUvarint(b, uint64(len(unit.Globals)))
+	for _, name := range unit.Globals {
+		b = appendString(b, name)
+	}
+	b = binary.AppendUvarint(b, uint64(len(unit.Builtins)))
+	for _, name := range unit.Builtins {
+		b = appendString(b, name)
+	}
+	b = binary.AppendUvarint(b, uint64(len(unit.Imports)))
+	for _, dependency := range unit.Imports {
+		b = appendString(b, dependency.Name)
+		b = append(b, dependency.ExportsHash[:]...)
+	}
+	b = binary.AppendUvarint(b, uint64(len(unit.Exports)))
+	for _, export := range unit.Exports {
+		b = appendString(b, export.Name)
+		b = binary.AppendUvarint(b, uint64(export.Index))
+		b = appendAttribute(b, &export.Type)
+	}
+	b = binary.AppendUvarint(b, uint64(len(unit.Externals)))
+	for _, external := range unit.Externals {
+		b = appendString(b, external.Module)
+		b = appendString(b, external.Name)
+		b = binary.AppendUvarint(b, uint64(external.Index))
+	}
+	return b
+}
+
+// appendAttribute writes a type: its object type, its flags, the type of the
+// function it returns if any, then the type, nullability and function type of
+// every argument.
+func appendAttribute(b []byte, attribute *object.Attribute) []byte {
+	b = appendString(b, string(attribute.ObjectType))
+	var flags byte
+	if attribute.Nullable {
+		flags |= attributeNullable
+	}
+	if attribute.IsFunction {
+		flags |= attributeIsFunction
+	}
+	if attribute.Variadic {
+		flags |= attributeVariadic
+	}
+	if attribute.Promote {
+		flags |= attributePromote
+	}
+	if attribute.FunctionAttribute != nil {
+		flags |= attributeHasFunction
+	}
+	b = append(b, flags)
+	if attribute.FunctionAttribute != nil {
+		b = appendAttribute(b, attribute.FunctionAttribute)
+	}
+
+	b = binary.AppendUvarint(b, uint64(len(attribute.ArgsObjectType)))
+	for i, argument := range attribute.ArgsObjectType {
+		b = appendString(b, string(argument))
+		var flags byte
+		if i < len(attribute.ArgsNullable) && attribute.ArgsNullable[i] {
+			flags |= attributeNullable
+		}
+		var function *object.Attribute
+		if i < len(attribute.ArgsFunction) {
+			function = attribute.ArgsFunction[i]
+		}
+		if function != nil {
+			flags |= attributeHasFunction
+		}
+		b = append(b, flags)
+		if function != nil {
+			b = appendAttribute(b, function)
+		}
+	}
+	return b
+}
+
+func (d *decoder) unit() (*compiler.Unit, error) {
+	name, err := d.bytes()
+	if err != nil {
+		return nil, err
+	}
+	unit := &compiler.Unit{Name: string(name)}
+	unit.SourceHash, err = d.hash()
+	if err != nil {
+		return nil, err
+	}
+
+	unit.Globals, err = d.strings()
+	if err != nil {
+		return nil, err
+	}
+	unit.Builtins, err = d.strings()
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := d.count()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < count; i++ {
+		name, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		hash, err := d.hash()
+		if err != nil {
+			return nil, err
+		}
+		unit.Imports = append(unit.Imports, compiler.Dependency{Name: string(name), ExportsHash: hash})
+	}
+
+	count, err = d.count()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < count; i++ {
+		name, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		index, err := d.global(unit)
+		if err != nil {
+			return nil, err
+		}
+		attribute, err := d.attribute(0)
+		if err != nil {
+			return nil, err
+		}
+		unit.Exports = append(unit.Exports, compiler.Export{Name: string(name), Index: index, Type: *attribute})
+	}
+
+	count, err = d.count()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < count; i++ {
+		module, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		name, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		index, err := d.global(unit)
+		if err != nil {
+			return nil, err
+		}
+		unit.Externals = append(unit.Externals, compiler.External{Module: string(module), Name: string(name), Index: index})
+	}
+
+	return unit, d.end("unit")
+}
+
+func (d *decoder) attribute(depth int) (*object.Attribute, error) {
+	if depth > maxAttributeDepth {
+		return nil, corrupt("types are nested too deeply")
+	}
+	objectType, err := d.bytes()
+	if err != nil {
+		return nil, err
+	}
+	flags, err := d.byte()
+	if err != nil {
+		return nil, err
+	}
+	attribute := &object.Attribute{
+		ObjectType: object.ObjectType(objectType),
+		Nullable:   flags&attributeNullable != 0,
+		IsFunction: flags&attributeIsFunction != 0,
+		Variadic:   flags&attributeVariadic != 0,
+		Promote:    flags&attributePromote != 0,
+	}
+	if flags&attributeHasFunction != 0 {
+		attribute.FunctionAttribute, err = d.attribute(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	count, err := d.count()
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return attribute, nil
+	}
+	attribute.ArgsObjectType = make([]object.ObjectType, count)
+	attribute.ArgsNullable = make([]bool, count)
+	for i := 0; i < count; i++ {
+		argument, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		flags, err := d.byte()
+		if err != nil {
+			return nil, err
+		}
+		attribute.ArgsObjectType[i] = object.ObjectType(argument)
+		attribute.ArgsNullable[i] = flags&attributeNullable != 0
+		if flags&attributeHasFunction != 0 {
+			if attribute.ArgsFunction == nil {
+				attribute.ArgsFunction = make([]*object.Attribute, count)
+			}
+			attribute.ArgsFunction[i], err = d.attribute(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return attribute, nil
+}
+
+// global reads the index of a global of unit.
+func (d *decoder) global(unit *compiler.Unit) (int, error) {
+	index, err := d.uvarint()
+	if err != nil {
+		return 0, err
+	}
+	if index >= len(unit.Globals) {
+		return 0, corrupt("global %d is out of range, the unit has %d", index, len(unit.Globals))
+	}
+	return index, nil
+}
+
+func (d *decoder) strings() ([]string, error) {
+	count, err := d.count()
+	if err != nil {
+		return nil, err
+	}
+	strings := make([]string, count)
+	for i := range strings {
+		s, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		strings[i] = string(s)
+	}
+	return strings, nil
+}
+
+func (d *decoder) hash() ([sha256.Size]byte, error) {
+	var hash [sha256.Size]byte
+	if len(d.buf) < len(hash) {
+		return hash, corrupt("unexpected end of data")
+	}
+	copy(hash[:], d.buf)
+	d.buf = d.buf[len(hash):]
+	return hash, nil
+}
@@ -1,13 +1,13 @@
 package compiler
 
 import (
+	"errors"
 	"fmt"
 	"gold/ast"
 	"gold/code"
 	"gold/object"
 	"gold/token"
 	"reflect"
-	"sort"
 )
 
 type Compiler struct {
@@ -15,9 +15,57 @@ type Compiler struct {
 	symbolTable *SymbolTable
 	scopes      []CompilationScope
 	scopeIndex  int
+
+	// definitions mirrors the symbol tables with the token where each name
+	// was defined, so references can point back to their declaration.
+	definitions []map[string]token.Token
+	references  []Reference
+
+	optimization    int
+	constantIndexes map[constantKey]int
+
+	// resolver finds the imported modules, file is the name of the module
+	// being compiled, modules the ones compiled by name, and importing the
+	// chain of imports leading to file, to report cycles.
+	resolver  Resolver
+	file      string
+	modules   map[string]*module
+	importing []string
+
+	// units finds the imported units when the module is compiled as a
+	// unit, with the imports and externals found so far.
+	units     UnitResolver
+	imports   []Dependency
+	externals []External
+}
+
+// Reference links an identifier found in the source to the symbol it was
+// resolved to. Definition is the token of the declaration, or the zero token
+// for builtins and names defined outside of the compiled source.
+type Reference struct {
+	Token      token.Token
+	Symbol     Symbol
+	Definition token.Token
+}
+
+// CompileError is returned by Compile with the token of the statement that
+// failed to compile. Its message is the one of the underlying error.
+type CompileError struct {
+	Token token.Token
+	Err   error
 }
 
+func (e *CompileError) Error() string { return e.Err.Error() }
+func (e *CompileError) Unwrap() error { return e.Err }
+
 func New() *Compiler {
+	return NewWithBuiltins(object.Builtins)
+}
+
+// NewWithBuiltins creates a compiler where builtins, instead of
+// object.Builtins, are the functions available to the program. The VM
+// running the bytecode must be given the same list.
+func NewWithBuiltins(builtins []object.BuiltinDefinition) *Compiler {
 	mainScope := CompilationScope{
 		instructions:        code.Instructions{},
 		lastInstruction:     EmittedInstruction{},
@@ -26,7 +74,7 @@ func New() *Compiler {
 
 	symbolTable := NewSymbolTable()
 
-	for i, v := range object.Builtins {
+	for i, v := range builtins {
 		symbolTable.DefineBuiltin(i, v.Name, v.Type)
 	}
 
@@ -35,6 +83,7 @@ func New() *Compiler {
 		symbolTable: symbolTable,
 		scopes:      []CompilationScope{mainScope},
 		scopeIndex:  0,
+		definitions: []map[string]token.Token{{}},
 	}
 }
 
@@ -55,13 +104,25 @@ func (c *Compiler) Compile(node ast.Node) (object.Attribute, error) {
 
 	// === MAIN ===
 	case *ast.Program:
+		imports := true
 		for _, s := range node.Statements {
-			infos, err = c.Compile(s)
+			if imp, ok := s.(*ast.ImportStatement); ok {
+				if !imports {
+					return infos, wrapError(s, fmt.Errorf("imports must come before the other statements"))
+				}
+				infos, err = object.Attribute{}, c.compileImport(imp)
+			} else {
+				imports = false
+				infos, err = c.Compile(s)
+			}
 			if err != nil {
-				return infos, err
+				return infos, wrapError(s, err)
 			}
 		}
 
+	case *ast.ImportStatement:
+		return infos, fmt.Errorf("import is only allowed at the top level of a module")
+
 	case *ast.ExpressionStatement:
 		infos, err = c.Compile(node.Expression)
 		if err != nil {
@@ -73,7 +134,7 @@ func (c *Compiler) Compile(node ast.Node) (object.Attribute, error) {
 		for _, s := range node.Statements {
 			tmpObjectAttribute, err := c.Compile(s)
 			if err != nil {
-				return infos, err
+				return infos, wrapError(s, err)
 			}
 
 			infos.Nullable = infos.Nullable || tmpObjectAttribute.Nullable
@@ -119,8 +180,7 @@ func (c *Compiler) Compile(node ast.Node) (object.Attribute, error) {
 			return infos, err
 		}
 
-		// Emit an `OpJumpNotTruthy` with a bogus value
-		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+		jumpNotTruthy := c.emitJump(code.OpJumpNotTruthy, node.Consequence, node.Alternative)
 
 		infos, err = c.Compile(node.Consequence)
 		if err != nil {
@@ -133,11 +193,12 @@ func (c *Compiler) Compile(node ast.Node) (object.Attribute, error) {
 			c.emit(code.OpNull)
 		}
 
-		// Emit an `OpJump` with a bogus value
-		jumpPos := c.emit(code.OpJump, 9999)
+		jump := c.emitJump(code.OpJump, node.Alternative)
 
-		afterConsequencePos := len(c.currentInstructions())
-		c.changeOperand(jumpNotTruthyPos, afterConsequencePos)
+		// Land after the consequence
+		if err := c.patchJump(jumpNotTruthy); err != nil {
+			return infos, err
+		}
 
 		if node.Alternative == nil {
 			c.emit(code.OpNull)
@@ -165,8 +226,9 @@ func (c *Compiler) Compile(node ast.Node) (object.Attribute, error) {
 			}
 		}
 
-		afterAlternativePos := len(c.currentInstructions())
-		c.changeOperand(jumpPos, afterAlternativePos)
+		if err := c.patchJump(jump); err != nil {
+			return infos, err
+		}
 
 	case *ast.WhileExpression:
 		pos := len(c.currentInstructions())
@@ -177,7 +239,7 @@ func (c *Compiler) Compile(node ast.Node) (object.Attribute, error) {
 			return infos, err
 		}
 
-		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+		jumpNotTruthy := c.emitJump(code.OpJumpNotTruthy, node.Consequence)
 
 		// NOTE : will have to get the infos when while return value. How to ignore return and only take break return value?
 		_, err = c.Compile(node.Consequence)
@@ -187,14 +249,16 @@ func (c *Compiler) Compile(node ast.Node) (object.Attribute, error) {
 
 		c.emit(code.OpJump, pos)
 
-		afterConsequencePos := len(c.currentInstructions())
-		c.changeOperand(jumpNotTruthyPos, afterConsequencePos)
+		if err := c.patchJump(jumpNotTruthy); err != nil {
+			return infos, err
+		}
 
 		c.emit(code.OpNull) // since it's an expression, must produce a value
 		infos.Nullable = true
 
 	case *ast.InfixExpression:
 		// This separate case reverse the order of right and left. With that we can use the same opCode for < and >
+		mark := c.markFold()
 
 		var rightInfos object.Attribute
 		var leftInfos object.Attribute
@@ -285,9 +349,10 @@ func (c *Compiler) Compile(node ast.Node) (object.Attribute, error) {
 		default:
 			return infos, fmt.Errorf("unknown operator '%s'", node.Operator)
 		}
+		c.foldConstant(node, mark)
 
 	case *ast.IncPostExpression:
-		symbol, ok := c.symbolTable.Resolve(node.Left.Value)
+		symbol, ok := c.resolve(node.Left)
 		if !ok {
 			return infos, nil
 		}
@@ -325,7 +390,7 @@ func (c *Compiler) Compile(node ast.Node) (object.Attribute, error) {
 	case *ast.IncPreExpression:
 		// TODO : refactor to simplify in prefix and postfix operation
 
-		symbol, ok := c.symbolTable.Resolve(node.Right.Value)
+		symbol, ok := c.resolve(node.Right)
 		if !ok {
 			return infos, fmt.Errorf("unknown name %s", node.Right.Value)
 		}
@@ -359,6 +424,7 @@ func (c *Compiler) Compile(node ast.Node) (object.Attribute, error) {
 		}
 
 	case *ast.PrefixExpression:
+		mark := c.markFold()
 		infos, err = c.Compile(node.Right)
 		if err != nil {
 			return infos, err
@@ -376,21 +442,29 @@ func (c *Compiler) Compile(node ast.Node) (object.Attribute, error) {
 		default:
 			return infos, fmt.Errorf("unknown operator %s", node.Operator)
 		}
+		c.foldConstant(node, mark)
 
 	case *ast.IndexExpression:
 		implemInfos, err := c.Compile(node.Left)
 		if err != nil {
 			return infos, err
 		}
-		if !implemInfos.IsTypeOf(object.ARRAY_OBJ, object.HASH_OBJ) {
-			return infos, fmt.Errorf("trying to index something other than array or hash")
+		if !implemInfos.IsTypeOf(object.ARRAY_OBJ, object.TUPLE_OBJ, object.HASH_OBJ) {
+			return infos, fmt.Errorf("trying to index something other than array, tuple or hash")
 		}
 
 		indexInfos, err := c.Compile(node.Index)
 		if err != nil {
 			return infos, err
 		}
-		if indexInfos.ObjectType != object.INTEGER_OBJ {
+		switch indexInfos.ObjectType {
+		case object.INTEGER_OBJ:
+		case object.STRING_OBJ, object.BOOLEAN_OBJ, object.FLOAT_OBJ, object.TUPLE_OBJ:
+			// Hashes are also indexed by the other keys of their literals
+			if implemInfos.ObjectType == object.ARRAY_OBJ || implemInfos.ObjectType == object.TUPLE_OBJ {
+				return infos, fmt.Errorf("trying to index with non integer")
+			}
+		default:
 			return infos, fmt.Errorf("trying to index with non integer")
 		}
 
@@ -439,15 +513,7 @@ func (c *Compiler) Compile(node ast.Node) (object.Attribute, error) {
 		infos.ObjectType = object.ARRAY_OBJ
 
 	case *ast.HashLiteral:
-		keys := []ast.Expression{}
-		for k := range node.Pairs {
-			keys = append(keys, k)
-		}
-		sort.Slice(keys, func(i, j int) bool {
-			return keys[i].String() < keys[j].String()
-		})
-
-		for _, k := range keys {
+		for _, k := range node.Keys {
 			_, err = c.Compile(k)
 			if err != nil {
 				return infos, err
@@ -464,12 +530,12 @@ func (c *Compiler) Compile(node ast.Node) (object.Attribute, error) {
 	// === DECLARE ===
 
 	case *ast.Declare:
-		err := c.compileDeclare(node.Name.Value, node.Value, node.Nullable, objectType(node.Token.Type))
+		err := c.compileDeclare(node.Name, node.Value, node.Nullable, objectType(node.Token.Type))
 		if err != nil {
 			return infos, err
 		}
 	case *ast.ReassignStatement:
-		symbol, ok := c.symbolTable.Resolve(node.Name.Value)
+		symbol, ok := c.resolve(node.Name)
 		if !ok {
 			return infos, errorUndefined(node.Name.Value)
 		}
@@ -495,7 +561,7 @@ func (c *Compiler) Compile(node ast.Node) (object.Attribute, error) {
 	// === IDENTIFIER ===
 
 	case *ast.Identifier:
-		symbol, ok := c.symbolTable.Resolve(node.Value)
+		symbol, ok := c.resolve(node)
 		if !ok {
 			return infos, errorUndefined(node.Value)
 		}
@@ -518,12 +584,13 @@ func (c *Compiler) Compile(node ast.Node) (object.Attribute, error) {
 
 			argsObjectType = append(argsObjectType, objectType)
 			argsNullable = append(argsNullable, nullable)
-			c.symbolTable.Define(p.Name.Value, object.Attribute{ObjectType: objectType, Nullable: nullable})
+			c.define(p.Name, object.Attribute{ObjectType: objectType, Nullable: nullable})
 		}
 
 		if node.Name != "" {
 			// ANY since the function itself has no type but the variabe associated has.
 			// But if we decide to modify the definition to include a type, it can be add there.
+			c.definitions[len(c.definitions)-1][node.Name] = node.Token
 			c.symbolTable.DefineFunctionName(
 				node.Name,
 				object.Attribute{
@@ -544,7 +611,7 @@ func (c *Compiler) Compile(node ast.Node) (object.Attribute, error) {
 		infos.ArgsObjectType = argsObjectType
 		infos.IsFunction = true
 
-		if !c.lastInstructionIs(code.OpReturn) {
+		if !c.lastInstructionIs(code.OpReturn) && !c.lastInstructionIs(code.OpTailCall) {
 			c.emit(code.OpNull)
 			c.emit(code.OpReturn)
 		}
@@ -552,6 +619,16 @@ func (c *Compiler) Compile(node ast.Node) (object.Attribute, error) {
 		freeSymbols := c.symbolTable.FreeSymbols
 		numLocals := c.symbolTable.numDefinitions
 		instructions := c.leaveScope()
+		if c.optimization >= OptimizationPeephole {
+			instructions = optimizeInstructions(instructions, false)
+		}
+
+		if numLocals > 1<<16 {
+			return infos, errorLimit("local variables in a function", numLocals, 1<<16)
+		}
+		if len(freeSymbols) > 1<<16 {
+			return infos, errorLimit("free variables in a function", len(freeSymbols), 1<<16)
+		}
 
 		for _, s := range freeSymbols {
 			c.loadSymbol(s)
@@ -564,6 +641,7 @@ func (c *Compiler) Compile(node ast.Node) (object.Attribute, error) {
 			Instructions:  instructions,
 			NumLocals:     numLocals,
 			NumParameters: len(node.Parameters),
+			Name:          node.Name,
 		}
 
 		fnIndex := c.addConstant(compiledFn)
@@ -575,6 +653,12 @@ func (c *Compiler) Compile(node ast.Node) (object.Attribute, error) {
 			return infos, err
 		}
 
+		// A function returning a call to itself can reuse its frame
+		if call, ok := node.ReturnValue.(*ast.CallExpression); ok && c.isSelfCall(call) {
+			c.replaceLastOpcode(code.OpTailCall)
+			break
+		}
+
 		c.emit(code.OpReturn)
 
 	case *ast.CallExpression:
@@ -583,15 +667,29 @@ func (c *Compiler) Compile(node ast.Node) (object.Attribute, error) {
 			return infos, err
 		}
 
-		if len(node.Arguments) != len(infos.ArgsObjectType) {
+		if infos.Variadic {
+			if len(node.Arguments) < len(infos.ArgsObjectType)-1 {
+				return infos, fmt.Errorf("wrong argument count : expect at least %d but got %d",
+					len(infos.ArgsObjectType)-1, len(node.Arguments))
+			}
+		} else if len(node.Arguments) != len(infos.ArgsObjectType) {
 			return infos, errorArgumentCount(len(infos.ArgsObjectType), len(node.Arguments))
 		}
+		if len(node.Arguments) >= 1<<16 {
+			return infos, errorLimit("arguments in a call", len(node.Arguments), 1<<16-1)
+		}
 
-		for i, a := range node.Arguments {
+		allIntegers := true
+		for argument, a := range node.Arguments {
 			argInfo, err := c.Compile(a)
 			if err != nil {
 				return infos, err
 			}
+			allIntegers = allIntegers && argInfo.IsTypeOf(object.INTEGER_OBJ)
+
+			// The arguments beyond the parameters of a variadic function
+			// have the type of the last one
+			i := min(argument, len(infos.ArgsObjectType)-1)
 
 			if argInfo.Nullable && !infos.ArgsNullable[i] {
 				return infos, errorNullable(a.String())
@@ -600,12 +698,26 @@ func (c *Compiler) Compile(node ast.Node) (object.Attribute, error) {
 			if !argInfo.IsTypeOf(infos.ArgsObjectType[i]) {
 				return infos, errorType(a.String(), infos.ArgsObjectType[i], argInfo.ObjectType)
 			}
+
+			if i < len(infos.ArgsFunction) && infos.ArgsFunction[i] != nil {
+				err := checkCallback(a.String(), *infos.ArgsFunction[i], argInfo)
+				if err != nil {
+					return infos, err
+				}
+			}
 		}
 
 		if infos.FunctionAttribute != nil {
 			infos = *infos.FunctionAttribute
 		}
 
+		if infos.Promote {
+			infos.ObjectType = object.INTEGER_OBJ
+			if !allIntegers {
+				infos.ObjectType = object.FLOAT_OBJ
+			}
+		}
+
 		c.emit(code.OpCall, len(node.Arguments))
 	}
 
@@ -613,19 +725,38 @@ func (c *Compiler) Compile(node ast.Node) (object.Attribute, error) {
 }
 
 func (c *Compiler) Bytecode() *Bytecode {
+	instructions := c.currentInstructions()
+	if c.optimization >= OptimizationPeephole {
+		instructions = optimizeInstructions(instructions, true)
+	}
 	return &Bytecode{
-		Instructions: c.currentInstructions(),
+		Instructions: instructions,
 		Constants:    c.constants,
 	}
 }
 
+// GlobalNames returns the names of the globals, by index.
+func (c *Compiler) GlobalNames() []string {
+	return c.symbolTable.Names()
+}
+
 func (c *Compiler) addConstant(obj object.Object) int {
+	if c.optimization >= OptimizationConstants {
+		if index, ok := c.sharedConstant(obj); ok {
+			return index
+		}
+	}
 	c.constants = append(c.constants, obj)
 	return len(c.constants) - 1
 }
 
 func (c *Compiler) emit(op code.Opcode, operands ...int) int {
-	ins := code.Make(op, operands...)
+	var ins []byte
+	if code.Fits(op, operands...) {
+		ins = code.Make(op, operands...)
+	} else {
+		ins = code.MakeWide(op, operands...)
+	}
 	pos := c.addInstruction(ins)
 
 	c.setLastInstruction(op, pos)
@@ -669,6 +800,31 @@ func (c *Compiler) removeLastPop() {
 	c.scopes[c.scopeIndex].lastInstruction = previous
 }
 
+// replaceLastOpcode changes the opcode of the last instruction for one with
+// the same operands.
+func (c *Compiler) replaceLastOpcode(op code.Opcode) {
+	last := &c.scopes[c.scopeIndex].lastInstruction
+	ins := c.currentInstructions()
+
+	pos := last.Position
+	if code.Opcode(ins[pos]) == code.OpWide {
+		pos++
+	}
+	ins[pos] = byte(op)
+	last.Opcode = op
+}
+
+// isSelfCall reports whether call calls the function being compiled, by the
+// name it was declared with. The call must already be compiled.
+func (c *Compiler) isSelfCall(call *ast.CallExpression) bool {
+	name, ok := call.Function.(*ast.Identifier)
+	if !ok {
+		return false
+	}
+	symbol, ok := c.symbolTable.store[name.Value]
+	return ok && symbol.Scope == FunctionScope
+}
+
 func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
 	ins := c.currentInstructions()
 
@@ -677,11 +833,126 @@ func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
 	}
 }
 
-func (c *Compiler) changeOperand(opPos int, operand int) {
-	op := code.Opcode(c.currentInstructions()[opPos])
-	newInstruction := code.Make(op, operand)
+// maxNodeSize bounds the number of bytes the compilation of a single node can
+// add to the current instructions, wide operands included.
+const maxNodeSize = 32
+
+// emitJump emits a forward jump with a bogus target, to be set with
+// patchJump once the nodes in between are compiled. The target can't be
+// further than what these nodes can emit, so the jump is only wide when a
+// narrow operand might not be enough. It returns a handle on the jump, as its
+// position may change before it is patched.
+func (c *Compiler) emitJump(op code.Opcode, between ...ast.Node) int {
+	size := 0
+	for _, node := range between {
+		ast.Inspect(node, func(ast.Node) bool {
+			size += maxNodeSize
+			return true
+		})
+	}
+
+	// The jump itself and the instructions around the nodes
+	var pos int
+	furthest := len(c.currentInstructions()) + 2*maxNodeSize + size
+	if code.Fits(op, furthest) {
+		pos = c.emit(op, 9999)
+	} else {
+		pos = c.addInstruction(code.MakeWide(op, 9999))
+		c.setLastInstruction(op, pos)
+	}
+
+	scope := &c.scopes[c.scopeIndex]
+	scope.jumps = append(scope.jumps, pos)
+	return len(scope.jumps) - 1
+}
+
+// patchJump sets the target of a jump emitted by emitJump to the end of the
+// current instructions. A narrow jump is made wide when the target doesn't
+// fit it after all.
+func (c *Compiler) patchJump(jump int) error {
+	scope := &c.scopes[c.scopeIndex]
+	pos := scope.jumps[jump]
+	scope.jumps[jump] = -1
+
+	ins := c.currentInstructions()
+	target := len(ins)
+	op := code.Opcode(ins[pos])
+	switch {
+	case op == code.OpWide:
+		op = code.Opcode(ins[pos+1])
+		if !code.FitsWide(op, target) {
+			return fmt.Errorf("jump to %d is too far", target)
+		}
+		c.replaceInstruction(pos, code.MakeWide(op, target))
+	case code.Fits(op, target):
+		c.replaceInstruction(pos, code.Make(op, target))
+	default:
+		return c.widenJump(pos)
+	}
+	return nil
+}
+
+// widenJump makes the narrow jump at pos wide and lands it at the end of the
+// current instructions. The instructions after it move: the other jumps get
+// their target again, becoming wide in turn when they no longer fit, and the
+// positions kept by the scope are moved too.
+func (c *Compiler) widenJump(pos int) error {
+	scope := &c.scopes[c.scopeIndex]
+	instructions, err := code.Decode(scope.instructions)
+	if err != nil {
+		return err
+	}
+
+	indexes := make(map[int]int, len(instructions)+1)
+	offset := 0
+	for i, instruction := range instructions {
+		indexes[offset] = i
+		offset += instruction.Width
+	}
+	indexes[offset] = len(instructions)
+
+	// The jumps yet to be patched keep their bogus target
+	pending := map[int]bool{}
+	for _, jump := range scope.jumps {
+		if jump >= 0 {
+			pending[indexes[jump]] = true
+		}
+	}
+	for i := range instructions {
+		if code.IsJump(instructions[i].Op) && !pending[i] {
+			instructions[i].Operands = []int{indexes[instructions[i].Operands[0]]}
+		}
+	}
+	widened := &instructions[indexes[pos]]
+	widened.Operands = []int{len(instructions)}
+	widened.Wide = true
+	widened.Width = len(code.MakeWide(widened.Op, 0))
+
+	offsets := relax(instructions, pending)
+	if end := offsets[len(instructions)]; !code.FitsWide(widened.Op, end) {
+		return fmt.Errorf("jump to %d is too far", end)
+	}
+	for i := range instructions {
+		if code.IsJump(instructions[i].Op) && !pending[i] {
+			instructions[i].Operands = []int{offsets[instructions[i].Operands[0]]}
+		}
+	}
+	scope.instructions = code.Encode(instructions)
 
-	c.replaceInstruction(opPos, newInstruction)
+	move := func(pos int) int {
+		if i, ok := indexes[pos]; ok {
+			return offsets[i]
+		}
+		return pos
+	}
+	for i, jump := range scope.jumps {
+		if jump >= 0 {
+			scope.jumps[i] = move(jump)
+		}
+	}
+	scope.lastInstruction.Position = move(scope.lastInstruction.Position)
+	scope.previousInstruction.Position = move(scope.previousInstruction.Position)
+	return nil
 }
 
 func (c *Compiler) currentInstructions() code.Instructions {
@@ -698,6 +969,7 @@ func (c *Compiler) enterScope() {
 	c.scopeIndex++
 
 	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+	c.definitions = append(c.definitions, map[string]token.Token{})
 }
 
 func (c *Compiler) leaveScope() code.Instructions {
@@ -707,10 +979,42 @@ func (c *Compiler) leaveScope() code.Instructions {
 	c.scopeIndex--
 
 	c.symbolTable = c.symbolTable.Outer
+	c.definitions = c.definitions[:len(c.definitions)-1]
 
 	return instructions
 }
 
+// References returns every identifier resolved or defined so far, in the
+// order the compiler met them.
+func (c *Compiler) References() []Reference {
+	return c.references
+}
+
+func (c *Compiler) define(name *ast.Identifier, objectInfo object.Attribute) Symbol {
+	symbol := c.symbolTable.Define(name.Value, objectInfo)
+	c.definitions[len(c.definitions)-1][name.Value] = name.Token
+	c.references = append(c.references, Reference{Token: name.Token, Symbol: symbol, Definition: name.Token})
+	return symbol
+}
+
+func (c *Compiler) resolve(name *ast.Identifier) (Symbol, bool) {
+	symbol, ok := c.symbolTable.Resolve(name.Value)
+	if !ok {
+		return symbol, ok
+	}
+
+	var definition token.Token
+	for i := len(c.definitions) - 1; i >= 0; i-- {
+		if tok, ok := c.definitions[i][name.Value]; ok {
+			definition = tok
+			break
+		}
+	}
+
+	c.references = append(c.references, Reference{Token: name.Token, Symbol: symbol, Definition: definition})
+	return symbol, ok
+}
+
 func (c *Compiler) loadSymbol(s Symbol) {
 	switch s.Scope {
 	case GlobalScope:
@@ -727,8 +1031,9 @@ func (c *Compiler) loadSymbol(s Symbol) {
 }
 
 func (c *Compiler) compileDeclare(
-	nodeName string, nodeValue ast.Node, nullable bool, objectType object.ObjectType,
+	name *ast.Identifier, nodeValue ast.Node, nullable bool, objectType object.ObjectType,
 ) error {
+	nodeName := name.Value
 	infos, err := c.Compile(nodeValue)
 	if err != nil {
 		return err
@@ -751,7 +1056,7 @@ func (c *Compiler) compileDeclare(
 		return errorNullable(nodeName)
 	}
 
-	symbol := c.symbolTable.Define(nodeName, attributes)
+	symbol := c.define(name, attributes)
 
 	if attributes.ObjectType != object.ANY && infos.ObjectType != symbol.ObjectInfo.ObjectType {
 		return errorType(nodeName, symbol.ObjectInfo.ObjectType, infos.ObjectType)
@@ -793,6 +1098,61 @@ func objectType(tk token.TokenType) object.ObjectType {
 	}
 }
 
+// wrapError attaches the statement position to err, unless a nested
+// statement already did.
+func wrapError(s ast.Statement, err error) error {
+	var compileErr *CompileError
+	if errors.As(err, &compileErr) {
+		return err
+	}
+	return &CompileError{Token: statementToken(s), Err: err}
+}
+
+func statementToken(s ast.Statement) token.Token {
+	switch s := s.(type) {
+	case *ast.Declare:
+		return s.Token
+	case *ast.ReassignStatement:
+		return s.Name.Token
+	case *ast.ReturnStatement:
+		return s.Token
+	case *ast.ExpressionStatement:
+		return s.Token
+	case *ast.ImportStatement:
+		return s.Token
+	}
+	return token.Token{}
+}
+
+// checkCallback checks that a function given as argument can be called the
+// way the callee calls it: with values of the expected types, and returning
+// a value of the expected type. Whether it may return null is checked with
+// the nullability of the argument.
+func checkCallback(name string, expected, got object.Attribute) error {
+	if !got.IsFunction {
+		return fmt.Errorf("wrong type used : '%s' expect a function but got '%s'", name, got.ObjectType)
+	}
+	if len(got.ArgsObjectType) != len(expected.ArgsObjectType) {
+		return fmt.Errorf("wrong function used : '%s' expect %d parameters but got %d",
+			name, len(expected.ArgsObjectType), len(got.ArgsObjectType))
+	}
+	for i, argType := range got.ArgsObjectType {
+		expectedType := expected.ArgsObjectType[i]
+		if argType != expectedType && argType != object.ANY && expectedType != object.ANY {
+			return fmt.Errorf("wrong function used : '%s' expect parameter %d of type '%s' but got '%s'",
+				name, i, expectedType, argType)
+		}
+		if expected.ArgsNullable[i] && !got.ArgsNullable[i] {
+			return fmt.Errorf("wrong function used : '%s' parameter %d must be nullable", name, i)
+		}
+	}
+	if !got.IsTypeOf(expected.ObjectType) {
+		return fmt.Errorf("wrong function used : '%s' expect to return '%s' but got '%s'",
+			name, expected.ObjectType, got.ObjectType)
+	}
+	return nil
+}
+
 func errorUndefined(name string) error {
 	return fmt.Errorf("undefined variable : '%s'", name)
 }
@@ -809,6 +1169,12 @@ func errorArgumentCount(expected, got int) error {
 	return fmt.Errorf("wrong argument count : expect %d but got %d", expected, got)
 }
 
+// errorLimit reports a count too large for the operands of the wide
+// instructions.
+func errorLimit(what string, count, limit int) error {
+	return fmt.Errorf("too many %s : %d, the limit is %d", what, count, limit)
+}
+
 func errorTypeAndFunc(name string, previousType object.ObjectType) error {
 	return fmt.Errorf("%s can return a function and %s", name, previousType)
 }
@@ -831,4 +1197,8 @@ type CompilationScope struct {
 	instructions        code.Instructions
 	lastInstruction     EmittedInstruction
 	previousInstruction EmittedInstruction
+
+	// jumps holds the positions of the jumps emitted by emitJump, -1 once
+	// they are patched
+	jumps []int
 }
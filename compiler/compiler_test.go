@@ -7,6 +7,7 @@ import (
 	"gold/lexer"
 	"gold/object"
 	"gold/parser"
+	"strings"
 	"testing"
 )
 
@@ -889,18 +890,18 @@ func TestHashLiterals(t *testing.T) {
 		},
 		{
 			input:             `{"hey": 2, 3.0: {1:2}, 5: [1,2]}`,
-			expectedConstants: []interface{}{3.0, 1, 2, 5, 1, 2, "hey", 2},
+			expectedConstants: []interface{}{"hey", 2, 3.0, 1, 2, 5, 1, 2},
 			expectedInstructions: []code.Instructions{
 				code.Make(code.OpConstant, 0),
 				code.Make(code.OpConstant, 1),
 				code.Make(code.OpConstant, 2),
-				code.Make(code.OpHash, 2),
 				code.Make(code.OpConstant, 3),
 				code.Make(code.OpConstant, 4),
+				code.Make(code.OpHash, 2),
 				code.Make(code.OpConstant, 5),
-				code.Make(code.OpArray, 2),
 				code.Make(code.OpConstant, 6),
 				code.Make(code.OpConstant, 7),
+				code.Make(code.OpArray, 2),
 				code.Make(code.OpHash, 6),
 				code.Make(code.OpPop),
 			},
@@ -1368,8 +1369,7 @@ func TestRecursiveFunctions(t *testing.T) {
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpSub),
-					code.Make(code.OpCall, 1),
-					code.Make(code.OpReturn),
+					code.Make(code.OpTailCall, 1),
 				},
 				1,
 			},
@@ -1397,8 +1397,7 @@ func TestRecursiveFunctions(t *testing.T) {
 					code.Make(code.OpGetLocal, 0),
 					code.Make(code.OpConstant, 0),
 					code.Make(code.OpSub),
-					code.Make(code.OpCall, 1),
-					code.Make(code.OpReturn),
+					code.Make(code.OpTailCall, 1),
 				},
 				1,
 				[]code.Instructions{
@@ -1441,6 +1440,62 @@ func TestBuiltinFunctionsError(t *testing.T) {
 			input:           `push(1)`,
 			expectedMessage: fmt.Errorf("wrong argument count : expect 2 but got 1"),
 		},
+		{
+			input:           `upper(1)`,
+			expectedMessage: fmt.Errorf("wrong type used : '1' expect type 'STRING' but got 'INTEGER'"),
+		},
+		{
+			input:           `let n = parse_int("1")`,
+			expectedMessage: fmt.Errorf("null value error : 'n' is not nullable"),
+		},
+		{
+			input:           `let content = read_file("notes.txt")`,
+			expectedMessage: fmt.Errorf("null value error : 'content' is not nullable"),
+		},
+		{
+			input:           `write_file("notes.txt", 1)`,
+			expectedMessage: fmt.Errorf("wrong type used : '1' expect type 'STRING' but got 'INTEGER'"),
+		},
+		{
+			input:           `format()`,
+			expectedMessage: fmt.Errorf("wrong argument count : expect at least 1 but got 0"),
+		},
+		{
+			input:           `format(1, 2)`,
+			expectedMessage: fmt.Errorf("wrong type used : '1' expect type 'STRING' but got 'INTEGER'"),
+		},
+		{
+			input:           `abs("1")`,
+			expectedMessage: fmt.Errorf("wrong type used : '1' expect type 'NUMBER' but got 'STRING'"),
+		},
+		{
+			input:           `lint x = sqrt(4)`,
+			expectedMessage: fmt.Errorf("wrong type used : 'x' expect type 'INTEGER' but got 'FLOAT'"),
+		},
+		{
+			input:           `lint x = max(1, 2.5)`,
+			expectedMessage: fmt.Errorf("wrong type used : 'x' expect type 'INTEGER' but got 'FLOAT'"),
+		},
+		{
+			input:           `lflt x = abs(2)`,
+			expectedMessage: fmt.Errorf("wrong type used : 'x' expect type 'FLOAT' but got 'INTEGER'"),
+		},
+		{
+			input:           `map([1], 2)`,
+			expectedMessage: fmt.Errorf("wrong type used : '2' expect a function but got 'INTEGER'"),
+		},
+		{
+			input:           `map([1], fn(lint a, lint b) { return a + b })`,
+			expectedMessage: fmt.Errorf("wrong function used : 'fn(lint a, lint b) return (a + b);' expect 1 parameters but got 2"),
+		},
+		{
+			input:           `filter([1], fn(lint a) { return a })`,
+			expectedMessage: fmt.Errorf("wrong function used : 'fn(lint a) return a;' expect to return 'BOOLEAN' but got 'INTEGER'"),
+		},
+		{
+			input:           `sort([2, 1], fn(lint a, lint b) { if (a < b) { return true } })`,
+			expectedMessage: fmt.Errorf("null value error : 'fn(lint a, lint b) if(a < b) return true;' is not nullable"),
+		},
 	}
 
 	runCompilerTestsError(t, tests)
@@ -1766,3 +1821,550 @@ bytecode: %s
 		}
 	}
 }
+
+func TestReferences(t *testing.T) {
+	input := `let x = 1;
+let f = fn(mint a) { return a + x; };
+f(x);`
+
+	compiler := New()
+	_, err := compiler.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	tests := []struct {
+		name           string
+		line, column   int
+		scope          SymbolScope
+		definitionLine int
+		definitionCol  int
+	}{
+		{"x", 1, 5, GlobalScope, 1, 5},
+		{"a", 2, 17, LocalScope, 2, 17},
+		{"a", 2, 29, LocalScope, 2, 17},
+		{"x", 2, 33, GlobalScope, 1, 5},
+		{"f", 2, 5, GlobalScope, 2, 5},
+		{"f", 3, 1, GlobalScope, 2, 5},
+		{"x", 3, 3, GlobalScope, 1, 5},
+	}
+
+	references := compiler.References()
+	if len(references) != len(tests) {
+		t.Fatalf("wrong number of references. want=%d, got=%d", len(tests), len(references))
+	}
+
+	for i, tt := range tests {
+		ref := references[i]
+		if ref.Symbol.Name != tt.name || ref.Symbol.Scope != tt.scope {
+			t.Errorf("references[%d] wrong symbol. want=%s %s, got=%s %s",
+				i, tt.name, tt.scope, ref.Symbol.Name, ref.Symbol.Scope)
+		}
+		if ref.Token.Line != tt.line || ref.Token.Column != tt.column {
+			t.Errorf("references[%d] wrong position. want=%d:%d, got=%d:%d",
+				i, tt.line, tt.column, ref.Token.Line, ref.Token.Column)
+		}
+		if ref.Definition.Line != tt.definitionLine || ref.Definition.Column != tt.definitionCol {
+			t.Errorf("references[%d] wrong definition. want=%d:%d, got=%d:%d",
+				i, tt.definitionLine, tt.definitionCol, ref.Definition.Line, ref.Definition.Column)
+		}
+	}
+}
+
+func TestIndexErrors(t *testing.T) {
+	tests := []compilerTestError{
+		{
+			input:           `[1, 2]["a"]`,
+			expectedMessage: fmt.Errorf("trying to index with non integer"),
+		},
+		{
+			input:           `{"a": 1}[[1]]`,
+			expectedMessage: fmt.Errorf("trying to index with non integer"),
+		},
+		{
+			input:           `tuple(1, 2)[1.0]`,
+			expectedMessage: fmt.Errorf("trying to index with non integer"),
+		},
+		{
+			input:           `1[0]`,
+			expectedMessage: fmt.Errorf("trying to index something other than array, tuple or hash"),
+		},
+	}
+
+	runCompilerTestsError(t, tests)
+}
+
+func TestCompileErrorPosition(t *testing.T) {
+	input := `let x = 1;
+let f = fn() {
+  return y;
+};`
+
+	compiler := New()
+	_, err := compiler.Compile(parse(input))
+
+	compileErr, ok := err.(*CompileError)
+	if !ok {
+		t.Fatalf("error is not CompileError. got=%T (%v)", err, err)
+	}
+	if compileErr.Error() != errorUndefined("y").Error() {
+		t.Errorf("wrong message. got=%q", compileErr.Error())
+	}
+	if compileErr.Token.Line != 3 || compileErr.Token.Column != 3 {
+		t.Errorf("wrong position. want=3:3, got=%d:%d", compileErr.Token.Line, compileErr.Token.Column)
+	}
+}
+
+func TestWideOperands(t *testing.T) {
+	// 65537 integer constants, the last one needs a wide index
+	var input strings.Builder
+	for i := 0; i <= 65536; i++ {
+		fmt.Fprintf(&input, "%d;", i)
+	}
+
+	compiler := New()
+	_, err := compiler.Compile(parse(input.String()))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	instructions := compiler.Bytecode().Instructions
+	last := concatInstructions([]code.Instructions{
+		code.Make(code.OpConstant, 65535),
+		code.Make(code.OpPop),
+		code.MakeWide(code.OpConstant, 65536),
+		code.Make(code.OpPop),
+	})
+	tail := instructions[len(instructions)-len(last):]
+	if string(tail) != string(last) {
+		t.Errorf("wrong instructions at the boundary.\nwant=%s\ngot =%s", last, tail)
+	}
+}
+
+func TestWideLocals(t *testing.T) {
+	var input strings.Builder
+	input.WriteString("fn() {")
+	for i := 0; i <= 256; i++ {
+		fmt.Fprintf(&input, "let %s = %d;", letters(i), i)
+	}
+	fmt.Fprintf(&input, "return %s + %s }", letters(255), letters(256))
+
+	compiler := New()
+	_, err := compiler.Compile(parse(input.String()))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	fn, ok := compiler.Bytecode().Constants[257].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant 257 is not a function. got=%T", compiler.Bytecode().Constants[257])
+	}
+	if fn.NumLocals != 257 {
+		t.Errorf("wrong number of locals. want=257, got=%d", fn.NumLocals)
+	}
+
+	end := concatInstructions([]code.Instructions{
+		code.Make(code.OpGetLocal, 255),
+		code.MakeWide(code.OpGetLocal, 256),
+		code.Make(code.OpAdd),
+		code.Make(code.OpReturn),
+	})
+	tail := fn.Instructions[len(fn.Instructions)-len(end):]
+	if string(tail) != string(end) {
+		t.Errorf("wrong instructions at the boundary.\nwant=%s\ngot =%s", end, tail)
+	}
+}
+
+func TestWideJumps(t *testing.T) {
+	tests := []struct {
+		statements int
+		wide       bool
+	}{
+		{10, false},
+		{20000, true},
+	}
+
+	for _, tt := range tests {
+		input := "if (true) {" + strings.Repeat("1;", tt.statements) + "}"
+
+		compiler := New()
+		_, err := compiler.Compile(parse(input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		instructions := compiler.Bytecode().Instructions
+		jump, err := code.ReadInstruction(instructions[1:])
+		if err != nil {
+			t.Fatalf("could not read the jump: %s", err)
+		}
+		if jump.Op != code.OpJumpNotTruthy || jump.Wide != tt.wide {
+			t.Errorf("wrong jump for %d statements. got=%+v", tt.statements, jump)
+		}
+
+		// The jump lands after the consequence and its own jump
+		target := jump.Operands[0]
+		after, err := code.ReadInstruction(instructions[target:])
+		if err != nil || after.Op != code.OpNull {
+			t.Errorf("jump target %d is not the alternative. got=%+v (%v)", target, after, err)
+		}
+	}
+}
+
+func TestPatchJumpWidens(t *testing.T) {
+	// Nothing is said to be in between, so both jumps are first narrow
+	c := New()
+	outer := c.emitJump(code.OpJumpNotTruthy)
+	c.emit(code.OpTrue)
+	inner := c.emitJump(code.OpJump)
+	c.emit(code.OpFalse)
+	if err := c.patchJump(inner); err != nil {
+		t.Fatalf("patchJump failed: %s", err)
+	}
+	last := c.emitJump(code.OpJump)
+	for i := 0; i < 30000; i++ {
+		c.emit(code.OpConstant, 0)
+	}
+	if err := c.patchJump(outer); err != nil {
+		t.Fatalf("patchJump failed: %s", err)
+	}
+	c.emit(code.OpNull)
+	if err := c.patchJump(last); err != nil {
+		t.Fatalf("patchJump failed: %s", err)
+	}
+
+	instructions, err := code.Decode(c.currentInstructions())
+	if err != nil {
+		t.Fatalf("could not decode the instructions: %s", err)
+	}
+	// The outer jump became wide, moving the inner one and its target
+	jumps := []struct {
+		index  int
+		op     code.Opcode
+		wide   bool
+		target int
+	}{
+		{0, code.OpJumpNotTruthy, true, 90017},
+		{2, code.OpJump, false, 11},
+		{4, code.OpJump, true, 90018},
+	}
+	for _, tt := range jumps {
+		jump := instructions[tt.index]
+		if jump.Op != tt.op || jump.Wide != tt.wide || jump.Operands[0] != tt.target {
+			t.Errorf("wrong jump at %d. want=%+v, got=%+v", tt.index, tt, jump)
+		}
+	}
+	if last := c.scopes[c.scopeIndex].lastInstruction; last.Opcode != code.OpNull || last.Position != 90017 {
+		t.Errorf("last instruction not moved. got=%+v", last)
+	}
+}
+
+// letters spells n with letters only, to be used as an identifier.
+func letters(n int) string {
+	name := ""
+	for {
+		name = string(rune('a'+n%26)) + name
+		n /= 26
+		if n == 0 {
+			return "v" + name
+		}
+	}
+}
+
+func TestOptimization(t *testing.T) {
+	tests := []struct {
+		level int
+		compilerTestCase
+	}{
+		{
+			OptimizationConstants,
+			compilerTestCase{
+				input:             "1 + 2 * 3; -(4 - 1.5); !true; 1 < 2.5",
+				expectedConstants: []interface{}{7, -2.5},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpPop),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpPop),
+					code.Make(code.OpFalse),
+					code.Make(code.OpPop),
+					code.Make(code.OpTrue),
+					code.Make(code.OpPop),
+				},
+			},
+		},
+		{
+			OptimizationConstants,
+			compilerTestCase{
+				input:             `"gold" + "en"; null == null; 1 / 0`,
+				expectedConstants: []interface{}{"golden", 1, 0},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpPop),
+					code.Make(code.OpTrue),
+					code.Make(code.OpPop),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpConstant, 2),
+					code.Make(code.OpDiv),
+					code.Make(code.OpPop),
+				},
+			},
+		},
+		{
+			OptimizationConstants,
+			compilerTestCase{
+				input:             `let s = "gold"; s == "gold"; "go" + "ld"; "a" == "a"`,
+				expectedConstants: []interface{}{"gold"},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetGlobal, 0),
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpEqual),
+					code.Make(code.OpPop),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpPop),
+					code.Make(code.OpTrue),
+					code.Make(code.OpPop),
+				},
+			},
+		},
+		{
+			OptimizationConstants,
+			compilerTestCase{
+				input:             "let x = 7; x * (3 + 4); 7.5; 7.5",
+				expectedConstants: []interface{}{7, 7.5},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetGlobal, 0),
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpMul),
+					code.Make(code.OpPop),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpPop),
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpPop),
+				},
+			},
+		},
+		{
+			OptimizationPeephole,
+			compilerTestCase{
+				input:             "let x = 0; while (x < 3) { x++ }; x",
+				expectedConstants: []interface{}{0, 3},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpConstant, 0),
+					code.Make(code.OpSetGlobal, 0),
+					// 0006
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpJumpNotGreaterThan, 21),
+					code.Make(code.OpIncGlobal, 0),
+					code.Make(code.OpJump, 6),
+					// 0021
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpPop),
+				},
+			},
+		},
+		{
+			OptimizationPeephole,
+			compilerTestCase{
+				input:             "let x = true; if (x) { if (x) { 1 } else { 2 } } else { 3 }",
+				expectedConstants: []interface{}{1, 2, 3},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpTrue),
+					code.Make(code.OpSetGlobal, 0),
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpJumpNotTruthy, 28),
+					code.Make(code.OpGetGlobal, 0),
+					code.Make(code.OpJumpNotTruthy, 22),
+					code.Make(code.OpConstant, 0),
+					// Threaded through the jump after the inner if
+					code.Make(code.OpJump, 31),
+					// 0022
+					code.Make(code.OpConstant, 1),
+					code.Make(code.OpJump, 31),
+					// 0028
+					code.Make(code.OpConstant, 2),
+					// 0031
+					code.Make(code.OpPop),
+				},
+			},
+		},
+		{
+			OptimizationPeephole,
+			compilerTestCase{
+				input: "fn(mint x) { if (x) { return 1 } else { return 2 } }",
+				expectedConstants: []interface{}{
+					1,
+					2,
+					[]code.Instructions{
+						code.Make(code.OpGetLocal, 0),
+						code.Make(code.OpJumpNotTruthy, 9),
+						code.Make(code.OpConstant, 0),
+						code.Make(code.OpReturn),
+						// 0009
+						code.Make(code.OpConstant, 1),
+						code.Make(code.OpReturn),
+					},
+				},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpClosure, 2, 0),
+					code.Make(code.OpPop),
+				},
+			},
+		},
+		{
+			OptimizationPeephole,
+			compilerTestCase{
+				input: "fn(mint x) { x--; if (x != 0) { return 1 } else { return 2 } }",
+				expectedConstants: []interface{}{
+					0,
+					1,
+					2,
+					[]code.Instructions{
+						code.Make(code.OpDecLocal, 0),
+						code.Make(code.OpGetLocal, 0),
+						code.Make(code.OpConstant, 0),
+						code.Make(code.OpJumpEqual, 14),
+						code.Make(code.OpConstant, 1),
+						code.Make(code.OpReturn),
+						// 0014
+						code.Make(code.OpConstant, 2),
+						code.Make(code.OpReturn),
+					},
+				},
+				expectedInstructions: []code.Instructions{
+					code.Make(code.OpClosure, 3, 0),
+					code.Make(code.OpPop),
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		compiler := New()
+		compiler.SetOptimization(tt.level)
+		_, err := compiler.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		bytecode := compiler.Bytecode()
+		err = testInstructions(tt.expectedInstructions, bytecode.Instructions)
+		if err != nil {
+			t.Fatalf("testInstructions failed for %q: %s", tt.input, err)
+		}
+		err = testConstants(t, tt.expectedConstants, bytecode.Constants)
+		if err != nil {
+			t.Fatalf("testConstants failed for %q: %s", tt.input, err)
+		}
+	}
+}
+
+func TestOptimizationKeepsErrors(t *testing.T) {
+	inputs := []string{`"a" - "b"`, "-true", `1 + "a"`}
+
+	for _, input := range inputs {
+		var messages []string
+		for _, level := range []int{OptimizationNone, OptimizationPeephole} {
+			compiler := New()
+			compiler.SetOptimization(level)
+			_, err := compiler.Compile(parse(input))
+			if err == nil {
+				t.Fatalf("no error at optimization level %d for %q", level, input)
+			}
+			messages = append(messages, err.Error())
+		}
+		if messages[0] != messages[1] {
+			t.Errorf("optimization changed the error of %q: %q, %q", input, messages[0], messages[1])
+		}
+	}
+}
+
+func TestOptimizationWidensJumps(t *testing.T) {
+	// The end of the inner conditional is threaded to the end of the outer
+	// one, past an alternative of more than 64 KiB.
+	input := "let x = 0; let a = true; let b = true; if (a) { if (b) { x } } else { " +
+		strings.Repeat("x = x + 1;", 8000) + " }"
+
+	compiler := New()
+	compiler.SetOptimization(OptimizationPeephole)
+	_, err := compiler.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	instructions, err := code.Decode(compiler.Bytecode().Instructions)
+	if err != nil {
+		t.Fatalf("could not decode the instructions: %s", err)
+	}
+	offsets := map[int]bool{}
+	offset := 0
+	for _, instruction := range instructions {
+		offsets[offset] = true
+		offset += instruction.Width
+	}
+	offsets[offset] = true
+
+	wide := 0
+	for _, instruction := range instructions {
+		if !code.IsJump(instruction.Op) {
+			continue
+		}
+		if !offsets[instruction.Operands[0]] {
+			t.Errorf("jump to %d is not on an instruction: %+v", instruction.Operands[0], instruction)
+		}
+		if instruction.Operands[0] > 65535 && !instruction.Wide {
+			t.Errorf("jump to %d is not wide: %+v", instruction.Operands[0], instruction)
+		}
+		if instruction.Wide {
+			wide++
+		}
+	}
+	if wide < 2 {
+		t.Errorf("expected the jumps past the alternative to be wide, got %d wide jumps", wide)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	modules := MapResolver{
+		"main.gold":     `import "main"`,
+		"a.gold":        `let _secret = 1; mint maybe = 1; let double = fn(lint x) { return 2 * x }`,
+		"bad.gold":      "\nlint x = \"s\"",
+		"twice.gold":    "let double = 2",
+		"cycle.gold":    `import "lib/back"`,
+		"lib/back.gold": `import "../cycle"`,
+	}
+	tests := []compilerTestError{
+		{`import "a"; _secret`, fmt.Errorf("undefined variable : '_secret'")},
+		// Imported globals keep their types
+		{`import "a"; lint x = maybe`, fmt.Errorf("null value error : 'x' is not nullable")},
+		{`import "a"; double("x")`, fmt.Errorf("wrong type used : 'x' expect type 'INTEGER' but got 'STRING'")},
+		{`import "a"; import "twice"`, fmt.Errorf(`'double' imported from "twice.gold" is already defined`)},
+		{`let x = 1; import "a"`, fmt.Errorf("imports must come before the other statements")},
+		{`import "bad"`, fmt.Errorf("in module bad.gold:2:1: wrong type used : 'x' expect type 'INTEGER' but got 'STRING'")},
+		{`import "missing"`, fmt.Errorf(`cannot import "missing": module "missing.gold" not found`)},
+		{`import "main"`, fmt.Errorf("import cycle: main.gold -> main.gold")},
+		{`import "cycle"`, fmt.Errorf("in module cycle.gold:1:1: in module lib/back.gold:1:1: import cycle: cycle.gold -> lib/back.gold -> cycle.gold")},
+		{`let f = fn() { import "a"; return 1 }`, fmt.Errorf("import is only allowed at the top level of a module")},
+	}
+
+	for _, tt := range tests {
+		compiler := New()
+		compiler.SetResolver(modules, "main.gold")
+		_, err := compiler.Compile(parse(tt.input))
+		if err == nil || err.Error() != tt.expectedMessage.Error() {
+			t.Errorf("wrong error for %q. want=%q, got=%v", tt.input, tt.expectedMessage, err)
+		}
+	}
+
+	_, err := New().Compile(parse(`import "a"`))
+	if err == nil || err.Error() != `cannot import "a": modules are not available` {
+		t.Errorf("wrong error without resolver, got=%v", err)
+	}
+}
@@ -0,0 +1,171 @@
+package compiler
+
+import (
+	"fmt"
+	"gold/ast"
+	"gold/lexer"
+	"gold/parser"
+	"gold/token"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Resolver finds the modules imported by a program.
+type Resolver interface {
+	// Resolve returns the name of the module imported as path by the module
+	// named from, and its source. The name identifies the module: a module
+	// imported twice under the same name is compiled once.
+	Resolve(from, path string) (name, source string, err error)
+}
+
+// FileResolver reads the modules from files, the path of an import being
+// relative to the directory of the importing file. ".gold" is added to
+// paths without extension.
+type FileResolver struct{}
+
+func (FileResolver) Resolve(from, path string) (string, string, error) {
+	if filepath.Ext(path) == "" {
+		path += ".gold"
+	}
+	name := filepath.FromSlash(path)
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(filepath.Dir(from), name)
+	}
+	name = filepath.Clean(name)
+	source, err := os.ReadFile(name)
+	if err != nil {
+		return "", "", err
+	}
+	return name, string(source), nil
+}
+
+// MapResolver holds the source of the modules by name, the path of an import
+// being relative to the importing module as with FileResolver. It is handy
+// to embed modules in a program and in tests.
+type MapResolver map[string]string
+
+func (r MapResolver) Resolve(from, p string) (string, string, error) {
+	if path.Ext(p) == "" {
+		p += ".gold"
+	}
+	name := path.Clean(path.Join(path.Dir(from), p))
+	source, ok := r[name]
+	if !ok {
+		return "", "", fmt.Errorf("module %q not found", name)
+	}
+	return name, source, nil
+}
+
+// module is a module compiled in the program. Its exports are nil while it
+// is being compiled.
+type module struct {
+	exports []Symbol
+}
+
+// SetResolver allows the program to import modules found by resolver. file
+// is the name of the compiled module, from which the imports are resolved.
+func (c *Compiler) SetResolver(resolver Resolver, file string) {
+	c.resolver = resolver
+	c.file = file
+	c.modules = map[string]*module{}
+	c.importing = nil
+	if file != "" {
+		c.modules[file] = &module{}
+		c.importing = []string{file}
+	}
+}
+
+// compileImport defines the exports of the imported module in the current
+// one. The first import of a module compiles it, so its top-level statements
+// run once, before the importing module.
+func (c *Compiler) compileImport(node *ast.ImportStatement) error {
+	if c.units != nil {
+		return c.importUnit(node)
+	}
+	if c.resolver == nil {
+		return fmt.Errorf("cannot import %q: modules are not available", node.Path.Value)
+	}
+	name, source, err := c.resolver.Resolve(c.file, node.Path.Value)
+	if err != nil {
+		return fmt.Errorf("cannot import %q: %w", node.Path.Value, err)
+	}
+
+	m, ok := c.modules[name]
+	if ok && m.exports == nil {
+		cycle := c.importing
+		for i, importing := range c.importing {
+			if importing == name {
+				cycle = c.importing[i:]
+				break
+			}
+		}
+		return fmt.Errorf("import cycle: %s -> %s", strings.Join(cycle, " -> "), name)
+	}
+	if !ok {
+		m, err = c.compileModule(name, source)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, symbol := range m.exports {
+		existing, ok := c.symbolTable.store[symbol.Name]
+		if ok && existing.Scope == GlobalScope && existing.Index == symbol.Index {
+			continue
+		}
+		if ok && existing.Scope != BuiltinScope {
+			return fmt.Errorf("'%s' imported from %q is already defined", symbol.Name, name)
+		}
+		c.symbolTable.DefineImported(symbol)
+	}
+	return nil
+}
+
+// compileModule compiles the module in the instructions of the program, with
+// its own globals.
+func (c *Compiler) compileModule(name, source string) (*module, error) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if errs := p.ParseErrors(); len(errs) != 0 {
+		return nil, moduleError(name, errs[0].Token, errs[0].Message)
+	}
+
+	m := &module{}
+	c.modules[name] = m
+	c.importing = append(c.importing, name)
+	symbolTable, file, definitions, references := c.symbolTable, c.file, c.definitions, len(c.references)
+	c.symbolTable = NewModuleSymbolTable(symbolTable)
+	c.file = name
+	c.definitions = []map[string]token.Token{{}}
+
+	_, err := c.Compile(program)
+	exports := c.symbolTable.Exports()
+
+	c.symbolTable, c.file, c.definitions, c.references = symbolTable, file, definitions, c.references[:references]
+	c.importing = c.importing[:len(c.importing)-1]
+	if err != nil {
+		delete(c.modules, name)
+		var tok token.Token
+		if compileErr, ok := err.(*CompileError); ok {
+			tok = compileErr.Token
+		}
+		return nil, moduleError(name, tok, err.Error())
+	}
+
+	m.exports = exports
+	if m.exports == nil {
+		m.exports = []Symbol{}
+	}
+	return m, nil
+}
+
+// moduleError reports an error of an imported module with its position in
+// the module, the error of the program being at the import.
+func moduleError(name string, tok token.Token, message string) error {
+	if tok.Line == 0 {
+		return fmt.Errorf("in module %s: %s", name, message)
+	}
+	return fmt.Errorf("in module %s:%d:%d: %s", name, tok.Line, tok.Column, message)
+}
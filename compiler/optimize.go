@@ -0,0 +1,555 @@
+package compiler
+
+import (
+	"fmt"
+	"gold/ast"
+	"gold/code"
+	"gold/object"
+	"math"
+)
+
+// Optimization levels, each one includes the optimizations of the levels
+// below.
+const (
+	// OptimizationNone compiles the program as written.
+	OptimizationNone = 0
+	// OptimizationConstants folds the operations on literals and shares the
+	// numbers and strings of the constant pool.
+	OptimizationConstants = 1
+	// OptimizationPeephole also rewrites the instructions: jumps to jumps are
+	// threaded, unreachable instructions and jumps to the next instruction
+	// are removed, values pushed only to be popped are never pushed, and
+	// common sequences are replaced by superinstructions.
+	OptimizationPeephole = 2
+)
+
+// SetOptimization sets the optimization level used by the next compilations.
+func (c *Compiler) SetOptimization(level int) {
+	c.optimization = level
+}
+
+// constantKey identifies a number or a string in the constant pool. Strings
+// can be shared as the VM compares them by content.
+type constantKey struct {
+	objectType object.ObjectType
+	bits       uint64
+	str        string
+}
+
+func keyOf(obj object.Object) (constantKey, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return constantKey{objectType: object.INTEGER_OBJ, bits: uint64(obj.Value)}, true
+	case *object.Float:
+		return constantKey{objectType: object.FLOAT_OBJ, bits: math.Float64bits(obj.Value)}, true
+	case *object.String:
+		return constantKey{objectType: object.STRING_OBJ, str: obj.Value}, true
+	}
+	return constantKey{}, false
+}
+
+// sharedConstant returns the index of a constant equal to obj, if any.
+func (c *Compiler) sharedConstant(obj object.Object) (int, bool) {
+	key, ok := keyOf(obj)
+	if !ok {
+		return 0, false
+	}
+
+	if c.constantIndexes == nil {
+		c.constantIndexes = map[constantKey]int{}
+		for i, constant := range c.constants {
+			if k, ok := keyOf(constant); ok {
+				if _, exists := c.constantIndexes[k]; !exists {
+					c.constantIndexes[k] = i
+				}
+			}
+		}
+	}
+
+	index, ok := c.constantIndexes[key]
+	if !ok {
+		c.constantIndexes[key] = len(c.constants)
+	}
+	return index, ok
+}
+
+// foldMark is the state of the compiler before an expression that may be
+// folded.
+type foldMark struct {
+	position        int
+	constants       int
+	lastInstruction EmittedInstruction
+}
+
+func (c *Compiler) markFold() foldMark {
+	return foldMark{
+		position:        len(c.currentInstructions()),
+		constants:       len(c.constants),
+		lastInstruction: c.scopes[c.scopeIndex].lastInstruction,
+	}
+}
+
+// foldConstant replaces the instructions emitted for node since mark with its
+// value, when it only operates on literals. The expression must already be
+// compiled, so folding never changes the type checks.
+func (c *Compiler) foldConstant(node ast.Expression, mark foldMark) {
+	if c.optimization < OptimizationConstants {
+		return
+	}
+	value, ok := evaluate(node)
+	if !ok {
+		return
+	}
+
+	for _, constant := range c.constants[mark.constants:] {
+		if key, ok := keyOf(constant); ok && c.constantIndexes[key] >= mark.constants {
+			delete(c.constantIndexes, key)
+		}
+	}
+	c.constants = c.constants[:mark.constants]
+	c.scopes[c.scopeIndex].instructions = c.currentInstructions()[:mark.position]
+	c.scopes[c.scopeIndex].lastInstruction = mark.lastInstruction
+
+	switch value := value.(type) {
+	case *object.Boolean:
+		if value.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+	case *object.Null:
+		c.emit(code.OpNull)
+	default:
+		c.emit(code.OpConstant, c.addConstant(value))
+	}
+}
+
+// evaluate computes the value of an expression made of literals the way the
+// VM would. It fails for anything the VM would reject at runtime.
+func evaluate(node ast.Expression) (object.Object, bool) {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return &object.Integer{Value: node.Value}, true
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}, true
+	case *ast.StringLiteral:
+		return &object.String{Value: node.Value}, true
+	case *ast.Boolean:
+		return &object.Boolean{Value: node.Value}, true
+	case *ast.Null:
+		return &object.Null{}, true
+
+	case *ast.PrefixExpression:
+		right, ok := evaluate(node.Right)
+		if !ok {
+			return nil, false
+		}
+		switch node.Operator {
+		case "!":
+			return &object.Boolean{Value: !truthy(right)}, true
+		case "-":
+			switch right := right.(type) {
+			case *object.Integer:
+				return &object.Integer{Value: -right.Value}, true
+			case *object.Float:
+				return &object.Float{Value: -right.Value}, true
+			}
+		}
+
+	case *ast.InfixExpression:
+		left, ok := evaluate(node.Left)
+		if !ok {
+			return nil, false
+		}
+		right, ok := evaluate(node.Right)
+		if !ok {
+			return nil, false
+		}
+		return evaluateInfix(node.Operator, left, right)
+	}
+	return nil, false
+}
+
+func evaluateInfix(operator string, left, right object.Object) (object.Object, bool) {
+	leftInteger, leftIsInteger := left.(*object.Integer)
+	rightInteger, rightIsInteger := right.(*object.Integer)
+	if leftIsInteger && rightIsInteger {
+		l, r := leftInteger.Value, rightInteger.Value
+		switch operator {
+		case "+":
+			return &object.Integer{Value: l + r}, true
+		case "-":
+			return &object.Integer{Value: l - r}, true
+		case "*":
+			return &object.Integer{Value: l * r}, true
+		case "/":
+			// The VM fails for these, at runtime
+			if r == 0 || (r == -1 && l == math.MinInt64) {
+				return nil, false
+			}
+			return &object.Integer{Value: l / r}, true
+		}
+		return compareNumbers(operator, l, r)
+	}
+
+	switch operator {
+	case "==":
+		return &object.Boolean{Value: object.Equal(left, right)}, true
+	case "!=":
+		return &object.Boolean{Value: !object.Equal(left, right)}, true
+	}
+
+	l, leftIsNumber := number(left)
+	r, rightIsNumber := number(right)
+	if leftIsNumber && rightIsNumber {
+		switch operator {
+		case "+":
+			return &object.Float{Value: l + r}, true
+		case "-":
+			return &object.Float{Value: l - r}, true
+		case "*":
+			return &object.Float{Value: l * r}, true
+		case "/":
+			return &object.Float{Value: l / r}, true
+		}
+		return compareNumbers(operator, l, r)
+	}
+
+	leftString, leftIsString := left.(*object.String)
+	rightString, rightIsString := right.(*object.String)
+	if leftIsString && rightIsString && operator == "+" {
+		return &object.String{Value: leftString.Value + rightString.Value}, true
+	}
+	return nil, false
+}
+
+func compareNumbers[N int64 | float64](operator string, l, r N) (object.Object, bool) {
+	switch operator {
+	case "==":
+		return &object.Boolean{Value: l == r}, true
+	case "!=":
+		return &object.Boolean{Value: l != r}, true
+	case ">":
+		return &object.Boolean{Value: l > r}, true
+	case ">=":
+		return &object.Boolean{Value: l >= r}, true
+	case "<":
+		return &object.Boolean{Value: l < r}, true
+	case "<=":
+		return &object.Boolean{Value: l <= r}, true
+	}
+	return nil, false
+}
+
+func number(obj object.Object) (float64, bool) {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return float64(obj.Value), true
+	case *object.Float:
+		return obj.Value, true
+	}
+	return 0, false
+}
+
+func truthy(obj object.Object) bool {
+	switch obj := obj.(type) {
+	case *object.Boolean:
+		return obj.Value
+	case *object.Null:
+		return false
+	case *object.Integer:
+		return obj.Value != 0
+	case *object.Float:
+		return obj.Value != 0
+	}
+	return true
+}
+
+// pushOnly lists the instructions that only push a value, without any other
+// effect, so they can be dropped with the OpPop that follows them.
+var pushOnly = map[code.Opcode]bool{
+	code.OpConstant:       true,
+	code.OpTrue:           true,
+	code.OpFalse:          true,
+	code.OpNull:           true,
+	code.OpGetGlobal:      true,
+	code.OpGetLocal:       true,
+	code.OpGetFree:        true,
+	code.OpGetBuiltin:     true,
+	code.OpCurrentClosure: true,
+}
+
+// peephole optimizes the instructions of the main program or of a function.
+// Jump operands are turned into instruction indexes while the instructions
+// are rewritten, and back to offsets once they are encoded again.
+type peephole struct {
+	instructions []code.Instruction
+	isMain       bool
+}
+
+func optimizeInstructions(ins code.Instructions, isMain bool) code.Instructions {
+	decoded, err := code.Decode(ins)
+	if err != nil {
+		panic(fmt.Sprintf("optimizing invalid instructions: %s", err))
+	}
+
+	indexes := make(map[int]int, len(decoded))
+	offset := 0
+	for i, instruction := range decoded {
+		indexes[offset] = i
+		offset += instruction.Width
+	}
+	indexes[offset] = len(decoded)
+	for i := range decoded {
+		if code.IsJump(decoded[i].Op) {
+			decoded[i].Operands = []int{indexes[decoded[i].Operands[0]]}
+		}
+	}
+
+	p := &peephole{instructions: decoded, isMain: isMain}
+	for changed := true; changed; {
+		changed = p.threadJumps()
+		changed = p.removeUnreachable() || changed
+		changed = p.removeDeadJumps() || changed
+		changed = p.fusePops() || changed
+		changed = p.combine() || changed
+	}
+	return p.encode()
+}
+
+// threadJumps makes jumps landing on an OpJump go directly to its target.
+func (p *peephole) threadJumps() bool {
+	changed := false
+	for i, instruction := range p.instructions {
+		if !code.IsJump(instruction.Op) {
+			continue
+		}
+		target := instruction.Operands[0]
+		for steps := 0; target < len(p.instructions) && p.instructions[target].Op == code.OpJump && steps < len(p.instructions); steps++ {
+			target = p.instructions[target].Operands[0]
+		}
+		if target != instruction.Operands[0] {
+			p.instructions[i].Operands = []int{target}
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (p *peephole) removeUnreachable() bool {
+	reachable := make([]bool, len(p.instructions))
+	pending := []int{0}
+	for len(pending) > 0 {
+		i := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if i >= len(p.instructions) || reachable[i] {
+			continue
+		}
+		reachable[i] = true
+
+		switch op := p.instructions[i].Op; {
+		case op == code.OpReturn, op == code.OpTailCall:
+			continue
+		case op == code.OpJump:
+			pending = append(pending, p.instructions[i].Operands[0])
+			continue
+		case code.IsJump(op):
+			pending = append(pending, p.instructions[i].Operands[0])
+		}
+		pending = append(pending, i+1)
+	}
+
+	removed := make([]bool, len(p.instructions))
+	for i := range removed {
+		removed[i] = !reachable[i]
+	}
+	return p.remove(removed)
+}
+
+func (p *peephole) removeDeadJumps() bool {
+	removed := make([]bool, len(p.instructions))
+	for i, instruction := range p.instructions {
+		removed[i] = instruction.Op == code.OpJump && instruction.Operands[0] == i+1
+	}
+	return p.remove(removed)
+}
+
+// fusePops drops the values pushed only to be popped, and the copy made by a
+// postfix increment or decrement whose value is not used. The last OpPop of
+// the main program is kept, its value is the result of the program.
+func (p *peephole) fusePops() bool {
+	targets := p.targets()
+	last := -1
+	if p.isMain {
+		for i, instruction := range p.instructions {
+			if instruction.Op == code.OpPop {
+				last = i
+			}
+		}
+	}
+
+	removed := make([]bool, len(p.instructions))
+	is := func(i int, op code.Opcode) bool {
+		return i < len(p.instructions) && !removed[i] && p.instructions[i].Op == op
+	}
+	for i := 0; i < len(p.instructions); i++ {
+		instruction := p.instructions[i]
+		if removed[i] || !pushOnly[instruction.Op] {
+			continue
+		}
+
+		if is(i+1, code.OpPop) && !targets[i+1] && i+1 != last {
+			removed[i], removed[i+1] = true, true
+			continue
+		}
+
+		// OpGet x, OpGet x, OpInc, OpSet x, OpPop
+		set := map[code.Opcode]code.Opcode{code.OpGetGlobal: code.OpSetGlobal, code.OpGetLocal: code.OpSetLocal}[instruction.Op]
+		if set == 0 || !is(i+1, instruction.Op) || !(is(i+2, code.OpInc) || is(i+2, code.OpDec)) ||
+			!is(i+3, set) || !is(i+4, code.OpPop) || i+4 == last {
+			continue
+		}
+		index := instruction.Operands[0]
+		if p.instructions[i+1].Operands[0] != index || p.instructions[i+3].Operands[0] != index {
+			continue
+		}
+		if targets[i+1] || targets[i+2] || targets[i+3] || targets[i+4] {
+			continue
+		}
+		removed[i+1], removed[i+4] = true, true
+	}
+	return p.remove(removed)
+}
+
+// superinstructions maps the sequences of two or three instructions to the
+// single instruction that replaces them. The operand of the first and the
+// last instruction of a sequence is kept, they must be the same if both have
+// one.
+var superinstructions = []struct {
+	sequence []code.Opcode
+	op       code.Opcode
+}{
+	{[]code.Opcode{code.OpGetGlobal, code.OpInc, code.OpSetGlobal}, code.OpIncGlobal},
+	{[]code.Opcode{code.OpGetGlobal, code.OpDec, code.OpSetGlobal}, code.OpDecGlobal},
+	{[]code.Opcode{code.OpGetLocal, code.OpInc, code.OpSetLocal}, code.OpIncLocal},
+	{[]code.Opcode{code.OpGetLocal, code.OpDec, code.OpSetLocal}, code.OpDecLocal},
+	{[]code.Opcode{code.OpGreaterThan, code.OpJumpNotTruthy}, code.OpJumpNotGreaterThan},
+	{[]code.Opcode{code.OpGreaterEqualThan, code.OpJumpNotTruthy}, code.OpJumpNotGreaterEqualThan},
+	{[]code.Opcode{code.OpEqual, code.OpJumpNotTruthy}, code.OpJumpNotEqual},
+	{[]code.Opcode{code.OpNotEqual, code.OpJumpNotTruthy}, code.OpJumpEqual},
+}
+
+// combine replaces sequences by superinstructions, when no jump lands in the
+// middle of the sequence.
+func (p *peephole) combine() bool {
+	targets := p.targets()
+	removed := make([]bool, len(p.instructions))
+	for i := 0; i < len(p.instructions); i++ {
+	sequences:
+		for _, super := range superinstructions {
+			last := i + len(super.sequence) - 1
+			if last >= len(p.instructions) {
+				continue
+			}
+			for j, op := range super.sequence {
+				if p.instructions[i+j].Op != op || (j > 0 && targets[i+j]) {
+					continue sequences
+				}
+			}
+			first, end := p.instructions[i], p.instructions[last]
+			if len(first.Operands) > 0 && first.Operands[0] != end.Operands[0] {
+				continue
+			}
+
+			// The operand keeps its width, which fits the superinstruction
+			wide := first.Wide || end.Wide
+			operands := end.Operands
+			width := len(code.Make(super.op, operands...))
+			if wide {
+				width = len(code.MakeWide(super.op, operands...))
+			}
+			p.instructions[i] = code.Instruction{Op: super.op, Operands: operands, Wide: wide, Width: width}
+			for j := i + 1; j <= last; j++ {
+				removed[j] = true
+			}
+			i = last
+			break
+		}
+	}
+	return p.remove(removed)
+}
+
+// targets returns the indexes of the instructions a jump lands on.
+func (p *peephole) targets() map[int]bool {
+	targets := map[int]bool{}
+	for _, instruction := range p.instructions {
+		if code.IsJump(instruction.Op) {
+			targets[instruction.Operands[0]] = true
+		}
+	}
+	return targets
+}
+
+// remove drops the instructions marked as removed. A jump to a removed
+// instruction goes to the next one kept.
+func (p *peephole) remove(removed []bool) bool {
+	kept := make([]int, len(p.instructions)+1)
+	instructions := []code.Instruction{}
+	for i, instruction := range p.instructions {
+		kept[i] = len(instructions)
+		if !removed[i] {
+			instructions = append(instructions, instruction)
+		}
+	}
+	kept[len(p.instructions)] = len(instructions)
+
+	if len(instructions) == len(p.instructions) {
+		return false
+	}
+	for i := range instructions {
+		if code.IsJump(instructions[i].Op) {
+			instructions[i].Operands = []int{kept[instructions[i].Operands[0]]}
+		}
+	}
+	p.instructions = instructions
+	return true
+}
+
+// encode writes the instructions with the jump targets as offsets.
+func (p *peephole) encode() code.Instructions {
+	offsets := relax(p.instructions, nil)
+	for i := range p.instructions {
+		if code.IsJump(p.instructions[i].Op) {
+			p.instructions[i].Operands = []int{offsets[p.instructions[i].Operands[0]]}
+		}
+	}
+	return code.Encode(p.instructions)
+}
+
+// relax returns the offset of every instruction, and of their end, once
+// encoded. The operands of the jumps are instruction indexes: a narrow jump
+// whose target is out of the reach of its operand is made wide. That moves
+// the instructions after it, which may take other targets out of reach, so
+// it goes on until every jump fits. The jumps marked as pending have no
+// target yet and are left as they are.
+func relax(instructions []code.Instruction, pending map[int]bool) []int {
+	offsets := make([]int, len(instructions)+1)
+	for widened := true; widened; {
+		for i, instruction := range instructions {
+			offsets[i+1] = offsets[i] + instruction.Width
+		}
+
+		widened = false
+		for i, instruction := range instructions {
+			if !code.IsJump(instruction.Op) || instruction.Wide || pending[i] {
+				continue
+			}
+			if !code.Fits(instruction.Op, offsets[instruction.Operands[0]]) {
+				instructions[i].Wide = true
+				instructions[i].Width = len(code.MakeWide(instruction.Op, instruction.Operands...))
+				widened = true
+			}
+		}
+	}
+	return offsets
+}
@@ -2,6 +2,8 @@ package compiler
 
 import (
 	"gold/object"
+	"sort"
+	"strings"
 )
 
 type SymbolScope string
@@ -30,6 +32,12 @@ type SymbolTable struct {
 	store          map[string]Symbol
 	numDefinitions int
 
+	// main is the table of the program when this one holds the globals of
+	// a module: the globals of all the modules are numbered by main.
+	main *SymbolTable
+	// imported are the globals this table got from other modules.
+	imported map[string]bool
+
 	FreeSymbols []Symbol
 }
 
@@ -45,8 +53,29 @@ func NewSymbolTable() *SymbolTable {
 	return &SymbolTable{store: s, FreeSymbols: free}
 }
 
+// NewModuleSymbolTable creates the table of the globals of a module imported
+// by the program of main. It has the builtins of main, and its globals are
+// numbered after the ones of every module already compiled.
+func NewModuleSymbolTable(main *SymbolTable) *SymbolTable {
+	for main.main != nil {
+		main = main.main
+	}
+	s := NewSymbolTable()
+	s.main = main
+	for name, symbol := range main.store {
+		if symbol.Scope == BuiltinScope {
+			s.store[name] = symbol
+		}
+	}
+	return s
+}
+
 func (s *SymbolTable) Define(name string, objectInfo object.Attribute) Symbol {
-	symbol := Symbol{Name: name, Index: s.numDefinitions, ObjectInfo: objectInfo}
+	counter := s
+	if s.main != nil {
+		counter = s.main
+	}
+	symbol := Symbol{Name: name, Index: counter.numDefinitions, ObjectInfo: objectInfo}
 	if s.Outer == nil {
 		symbol.Scope = GlobalScope
 	} else {
@@ -54,10 +83,68 @@ func (s *SymbolTable) Define(name string, objectInfo object.Attribute) Symbol {
 	}
 
 	s.store[name] = symbol
-	s.numDefinitions++
+	counter.numDefinitions++
+	delete(s.imported, name)
 	return symbol
 }
 
+// DefineImported adds symbol, a global of another module, to this table.
+func (s *SymbolTable) DefineImported(symbol Symbol) {
+	if s.imported == nil {
+		s.imported = map[string]bool{}
+	}
+	s.store[symbol.Name] = symbol
+	s.imported[symbol.Name] = true
+}
+
+// DefineExternal defines a global of this table whose value is set by another
+// unit, see Compiler.SetUnitResolver. Unlike a global defined with Define,
+// it is not exported.
+func (s *SymbolTable) DefineExternal(name string, objectInfo object.Attribute) Symbol {
+	symbol := s.Define(name, objectInfo)
+	s.DefineImported(symbol)
+	return symbol
+}
+
+// builtinNames returns the names of the builtins, by index.
+func (s *SymbolTable) builtinNames() []string {
+	var names []string
+	for name, symbol := range s.store {
+		if symbol.Scope == BuiltinScope {
+			for len(names) <= symbol.Index {
+				names = append(names, "")
+			}
+			names[symbol.Index] = name
+		}
+	}
+	return names
+}
+
+// Exports returns the globals defined in this table that other modules can
+// import, by index: the ones whose name doesn't start with an underscore.
+func (s *SymbolTable) Exports() []Symbol {
+	var exports []Symbol
+	for name, symbol := range s.store {
+		if symbol.Scope == GlobalScope && !s.imported[name] && !strings.HasPrefix(name, "_") {
+			exports = append(exports, symbol)
+		}
+	}
+	sort.Slice(exports, func(i, j int) bool { return exports[i].Index < exports[j].Index })
+	return exports
+}
+
+// Names returns the names of the globals or locals defined in this table, by
+// index. Names shadowed by a later definition are left empty.
+func (s *SymbolTable) Names() []string {
+	names := make([]string, s.numDefinitions)
+	for _, symbol := range s.store {
+		if symbol.Scope == GlobalScope || symbol.Scope == LocalScope {
+			names[symbol.Index] = symbol.Name
+		}
+	}
+	return names
+}
+
 func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
 	obj, ok := s.store[name]
 	if !ok && s.Outer != nil {
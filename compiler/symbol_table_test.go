@@ -339,3 +339,16 @@ func TestShadowingFunctionName(t *testing.T) {
 			expected.Name, expected, result)
 	}
 }
+
+func TestNames(t *testing.T) {
+	global := NewSymbolTable()
+	global.DefineBuiltin(0, "len", object.Attribute{})
+	global.Define("a", object.Attribute{})
+	global.Define("b", object.Attribute{})
+	global.Define("a", object.Attribute{})
+
+	expected := []string{"", "b", "a"}
+	if names := global.Names(); !reflect.DeepEqual(names, expected) {
+		t.Errorf("wrong names. want=%q, got=%q", expected, names)
+	}
+}
@@ -0,0 +1,160 @@
+package compiler
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"gold/ast"
+	"gold/object"
+	"hash"
+)
+
+// Unit is a module compiled on its own, to be linked with the units of the
+// modules it imports. Its globals, constants and builtins are numbered as if
+// it were alone: the linker relocates them in the program.
+type Unit struct {
+	// Name is the name of the module, as given by the resolver.
+	Name     string
+	Bytecode *Bytecode
+	// Globals are the names of the globals of the unit, by index, empty for
+	// the shadowed ones. Some of them are externals.
+	Globals []string
+	// Builtins are the names of the builtins the unit was compiled with, by
+	// index.
+	Builtins []string
+	// Imports are the modules imported, in order.
+	Imports   []Dependency
+	Exports   []Export
+	Externals []External
+	// SourceHash identifies the source and the options the unit was
+	// compiled from. The compiler leaves it to the build.
+	SourceHash [sha256.Size]byte
+}
+
+// Dependency is a module imported by a unit, with the hash of the exports it
+// was compiled against.
+type Dependency struct {
+	Name        string
+	ExportsHash [sha256.Size]byte
+}
+
+// Export is a global of a unit that other units can import.
+type Export struct {
+	Name  string
+	Index int
+	Type  object.Attribute
+}
+
+// External is a global of a unit set by the module that exports it.
+type External struct {
+	Module string
+	Name   string
+	Index  int
+}
+
+// UnitResolver finds the units of the modules imported by a unit.
+type UnitResolver interface {
+	// ResolveUnit returns the unit of the module imported as path by the
+	// module named from.
+	ResolveUnit(from, path string) (*Unit, error)
+}
+
+// SetUnitResolver makes the compiler compile the module named file as a
+// unit: the imported modules are not compiled again, their exports are
+// found in the units given by resolver. See Unit.
+func (c *Compiler) SetUnitResolver(resolver UnitResolver, file string) {
+	c.units = resolver
+	c.file = file
+}
+
+// importUnit defines the exports of an imported unit as externals.
+func (c *Compiler) importUnit(node *ast.ImportStatement) error {
+	unit, err := c.units.ResolveUnit(c.file, node.Path.Value)
+	if err != nil {
+		return fmt.Errorf("cannot import %q: %w", node.Path.Value, err)
+	}
+	if unit.Name == c.file {
+		return fmt.Errorf("import cycle: %s -> %s", c.file, unit.Name)
+	}
+	for _, dependency := range c.imports {
+		if dependency.Name == unit.Name {
+			return nil
+		}
+	}
+
+	for _, export := range unit.Exports {
+		existing, ok := c.symbolTable.store[export.Name]
+		if ok && existing.Scope != BuiltinScope {
+			return fmt.Errorf("'%s' imported from %q is already defined", export.Name, unit.Name)
+		}
+		symbol := c.symbolTable.DefineExternal(export.Name, export.Type)
+		c.externals = append(c.externals, External{Module: unit.Name, Name: export.Name, Index: symbol.Index})
+	}
+	c.imports = append(c.imports, Dependency{Name: unit.Name, ExportsHash: unit.ExportsHash()})
+	return nil
+}
+
+// Unit returns the unit compiled after SetUnitResolver.
+func (c *Compiler) Unit() *Unit {
+	unit := &Unit{
+		Name:      c.file,
+		Bytecode:  c.Bytecode(),
+		Globals:   c.symbolTable.Names(),
+		Builtins:  c.symbolTable.builtinNames(),
+		Imports:   c.imports,
+		Externals: c.externals,
+	}
+	for _, symbol := range c.symbolTable.Exports() {
+		unit.Exports = append(unit.Exports, Export{Name: symbol.Name, Index: symbol.Index, Type: symbol.ObjectInfo})
+	}
+	return unit
+}
+
+// ExportsHash identifies the exports of the unit and their types. The units
+// importing this one must be compiled again when it changes.
+func (u *Unit) ExportsHash() [sha256.Size]byte {
+	h := sha256.New()
+	for _, export := range u.Exports {
+		writeString(h, export.Name)
+		writeAttribute(h, &export.Type)
+	}
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+func writeString(h hash.Hash, s string) {
+	h.Write(binary.AppendUvarint(nil, uint64(len(s))))
+	h.Write([]byte(s))
+}
+
+func writeAttribute(h hash.Hash, attribute *object.Attribute) {
+	if attribute == nil {
+		h.Write([]byte{0})
+		return
+	}
+	h.Write([]byte{1})
+	writeString(h, string(attribute.ObjectType))
+	writeAttribute(h, attribute.FunctionAttribute)
+	h.Write(binary.AppendUvarint(nil, uint64(len(attribute.ArgsObjectType))))
+	for i, argument := range attribute.ArgsObjectType {
+		writeString(h, string(argument))
+		h.Write([]byte{boolByte(i < len(attribute.ArgsNullable) && attribute.ArgsNullable[i])})
+		var function *object.Attribute
+		if i < len(attribute.ArgsFunction) {
+			function = attribute.ArgsFunction[i]
+		}
+		writeAttribute(h, function)
+	}
+	h.Write([]byte{
+		boolByte(attribute.Variadic), boolByte(attribute.Promote),
+		boolByte(attribute.Nullable), boolByte(attribute.IsFunction),
+	})
+}
+
+func boolByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
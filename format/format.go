@@ -0,0 +1,387 @@
+// Package format prints Gold source code in its canonical style.
+//
+// The canonical style indents blocks with two spaces, puts one statement per
+// line without trailing semicolons, spaces binary operators, keeps only the
+// parentheses required by precedence and spells typed declarations with their
+// main keyword (`endeavouros` becomes `mint`, `larry` becomes `larr`).
+// Comments are kept, and so are single blank lines between statements.
+package format
+
+import (
+	"bytes"
+	"errors"
+	"gold/ast"
+	"gold/lexer"
+	"gold/parser"
+	"gold/token"
+	"strings"
+)
+
+const indentation = "  "
+
+// Source formats a whole Gold file. It fails if the file doesn't parse.
+func Source(src []byte) ([]byte, error) {
+	l := lexer.New(string(src))
+	p := parser.New(l)
+	program := p.ParseProgram()
+
+	if len(p.Errors()) != 0 {
+		return nil, errors.New(strings.Join(p.Errors(), "\n"))
+	}
+
+	pr := &printer{comments: l.Comments()}
+	pr.statements(program.Statements, token.Token{})
+	pr.flushComments(-1)
+
+	return pr.out.Bytes(), nil
+}
+
+// Node formats a single node, without any comment.
+func Node(node ast.Node) string {
+	pr := &printer{}
+	switch node := node.(type) {
+	case *ast.Program:
+		pr.statements(node.Statements, token.Token{})
+		return pr.out.String()
+	case ast.Statement:
+		return pr.statement(node)
+	case ast.Expression:
+		return pr.expression(node)
+	}
+	return ""
+}
+
+type printer struct {
+	out      bytes.Buffer
+	indent   int
+	comments []token.Token
+
+	// lastLine is the source line of the last thing written, used to keep
+	// blank lines between statements.
+	lastLine int
+}
+
+func (p *printer) line(s string) {
+	if s != "" {
+		p.out.WriteString(strings.Repeat(indentation, p.indent))
+		p.out.WriteString(s)
+	}
+	p.out.WriteString("\n")
+}
+
+// flushComments writes every pending comment placed before the given line,
+// or all of them when line is negative.
+func (p *printer) flushComments(line int) {
+	for len(p.comments) > 0 && (line < 0 || p.comments[0].Line < line) {
+		comment := p.comments[0]
+		p.comments = p.comments[1:]
+
+		p.blankLineBefore(comment.Line)
+		p.line(strings.TrimRight(comment.Literal, " \t\r"))
+		p.lastLine = comment.Line
+	}
+}
+
+// trailingComment returns the comment written at the end of the given line.
+func (p *printer) trailingComment(line int) string {
+	if len(p.comments) > 0 && p.comments[0].Line == line {
+		comment := p.comments[0]
+		p.comments = p.comments[1:]
+		return " " + strings.TrimRight(comment.Literal, " \t\r")
+	}
+	return ""
+}
+
+func (p *printer) blankLineBefore(line int) {
+	if p.lastLine != 0 && line > p.lastLine+1 {
+		p.line("")
+	}
+}
+
+// statements writes a list of statements, then the comments found before
+// the closing brace if there is one.
+func (p *printer) statements(statements []ast.Statement, closing token.Token) {
+	for i, s := range statements {
+		start := firstLine(s)
+		p.flushComments(start)
+		p.blankLineBefore(start)
+
+		text := p.statement(s)
+		if i+1 < len(statements) && needsSemicolon(statements[i+1]) {
+			text += ";"
+		}
+
+		end := lastLine(s)
+		p.line(text + p.trailingComment(end))
+		p.lastLine = end
+	}
+
+	if closing.Line != 0 {
+		p.flushComments(closing.Line)
+	}
+}
+
+// needsSemicolon reports whether s, once printed, would be parsed as the
+// continuation of the previous statement if no semicolon separated them.
+func needsSemicolon(s ast.Statement) bool {
+	es, ok := s.(*ast.ExpressionStatement)
+	if !ok {
+		return false
+	}
+
+	e := es.Expression
+	for {
+		switch node := e.(type) {
+		case *ast.InfixExpression:
+			if parenthesize(node.Left, infixPrecedence(node.Operator), false) {
+				return true
+			}
+			e = node.Left
+		case *ast.CallExpression:
+			if parenthesize(node.Function, parser.CALL, false) {
+				return true
+			}
+			e = node.Function
+		case *ast.IndexExpression:
+			if parenthesize(node.Left, parser.INDEX, false) {
+				return true
+			}
+			e = node.Left
+		case *ast.PrefixExpression:
+			return node.Operator == "-"
+		case *ast.IncPreExpression, *ast.ArrayLiteral:
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+func (p *printer) statement(s ast.Statement) string {
+	switch s := s.(type) {
+	case *ast.Declare:
+		return declareKeyword(s.Token) + " " + s.Name.Value + " = " + p.expression(s.Value)
+	case *ast.ReassignStatement:
+		return s.Name.Value + " = " + p.expression(s.Value)
+	case *ast.ReturnStatement:
+		return "return " + p.expression(s.ReturnValue)
+	case *ast.ExpressionStatement:
+		return p.expression(s.Expression)
+	case *ast.ImportStatement:
+		return "import " + p.expression(s.Path)
+	}
+	return ""
+}
+
+func (p *printer) expression(e ast.Expression) string {
+	switch e := e.(type) {
+	case *ast.Identifier:
+		return e.Value
+	case *ast.IntegerLiteral:
+		return e.Token.Literal
+	case *ast.FloatLiteral:
+		if strings.HasSuffix(e.Token.Literal, ".") {
+			return e.Token.Literal + "0"
+		}
+		return e.Token.Literal
+	case *ast.StringLiteral:
+		return `"` + e.Value + `"`
+	case *ast.Boolean, *ast.Null:
+		return e.TokenLiteral()
+
+	case *ast.PrefixExpression:
+		right := p.operand(e.Right, parser.PREFIX, false)
+		// `- -x` would be read back as a decrement
+		if e.Operator == "-" && strings.HasPrefix(right, "-") {
+			right = "(" + right + ")"
+		}
+		return e.Operator + right
+	case *ast.InfixExpression:
+		precedence := infixPrecedence(e.Operator)
+		return p.operand(e.Left, precedence, false) + " " + e.Operator + " " + p.operand(e.Right, precedence, true)
+	case *ast.IncPostExpression:
+		return e.Left.Value + e.Operator
+	case *ast.IncPreExpression:
+		return e.Operator + e.Right.Value
+
+	case *ast.CallExpression:
+		args := make([]string, len(e.Arguments))
+		for i, a := range e.Arguments {
+			args[i] = p.expression(a)
+		}
+		return p.operand(e.Function, parser.CALL, false) + "(" + strings.Join(args, ", ") + ")"
+	case *ast.IndexExpression:
+		return p.operand(e.Left, parser.INDEX, false) + "[" + p.expression(e.Index) + "]"
+	case *ast.ArrayLiteral:
+		elements := make([]string, len(e.Elements))
+		for i, el := range e.Elements {
+			elements[i] = p.expression(el)
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	case *ast.HashLiteral:
+		pairs := make([]string, len(e.Keys))
+		for i, key := range e.Keys {
+			pairs[i] = p.expression(key) + ": " + p.expression(e.Pairs[key])
+		}
+		return "{" + strings.Join(pairs, ", ") + "}"
+
+	case *ast.IfExpression:
+		out := "if (" + p.expression(e.Condition) + ") " + p.block(e.Consequence)
+		if e.Alternative != nil {
+			out += " else " + p.block(e.Alternative)
+		}
+		return out
+	case *ast.WhileExpression:
+		return "while (" + p.expression(e.Condition) + ") " + p.block(e.Consequence)
+	case *ast.FunctionLiteral:
+		params := make([]string, len(e.Parameters))
+		for i, param := range e.Parameters {
+			params[i] = declareKeyword(param.Token) + " " + param.Name.Value
+		}
+		return "fn(" + strings.Join(params, ", ") + ") " + p.block(e.Body)
+	}
+	return ""
+}
+
+// operand renders e as the operand of an operator with the given precedence,
+// adding parentheses when the parser would otherwise group it differently.
+// Operators are left associative, so a right operand of the same precedence
+// needs them too.
+func (p *printer) operand(e ast.Expression, precedence int, right bool) string {
+	if parenthesize(e, precedence, right) {
+		return "(" + p.expression(e) + ")"
+	}
+	return p.expression(e)
+}
+
+func parenthesize(e ast.Expression, precedence int, right bool) bool {
+	inner := parser.INDEX + 1
+	switch e := e.(type) {
+	case *ast.InfixExpression:
+		inner = infixPrecedence(e.Operator)
+	case *ast.PrefixExpression:
+		inner = parser.PREFIX
+	case *ast.IfExpression, *ast.WhileExpression:
+		inner = parser.LOWEST
+	}
+
+	return inner < precedence || (right && inner == precedence)
+}
+
+func (p *printer) block(b *ast.BlockStatement) string {
+	if len(b.Statements) == 0 && !p.hasCommentsBefore(b.Rbrace.Line) {
+		return "{}"
+	}
+
+	inner := &printer{indent: p.indent + 1, comments: p.comments, lastLine: b.Token.Line}
+	inner.statements(b.Statements, b.Rbrace)
+	p.comments = inner.comments
+
+	return "{\n" + inner.out.String() + strings.Repeat(indentation, p.indent) + "}"
+}
+
+func (p *printer) hasCommentsBefore(line int) bool {
+	return len(p.comments) > 0 && p.comments[0].Line < line
+}
+
+func infixPrecedence(operator string) int {
+	switch operator {
+	case "==", "!=":
+		return parser.EQUALS
+	case "<", ">", "<=", ">=":
+		return parser.LESSGREATER
+	case "+", "-":
+		return parser.SUM
+	case "*", "/":
+		return parser.PRODUCT
+	}
+	return parser.LOWEST
+}
+
+// declareKeyword returns the main spelling of a declaration keyword.
+func declareKeyword(tok token.Token) string {
+	switch tok.Type {
+	case token.MINT:
+		return "mint"
+	case token.LARR:
+		return "larr"
+	}
+	return tok.Literal
+}
+
+func firstLine(s ast.Statement) int {
+	line := 0
+	ast.Inspect(s, func(n ast.Node) bool {
+		if l := nodeToken(n).Line; l != 0 && (line == 0 || l < line) {
+			line = l
+		}
+		return true
+	})
+	return line
+}
+
+func lastLine(s ast.Statement) int {
+	line := 0
+	ast.Inspect(s, func(n ast.Node) bool {
+		if l := nodeToken(n).Line; l > line {
+			line = l
+		}
+		if b, ok := n.(*ast.BlockStatement); ok && b.Rbrace.Line > line {
+			line = b.Rbrace.Line
+		}
+		return true
+	})
+	return line
+}
+
+func nodeToken(n ast.Node) token.Token {
+	switch n := n.(type) {
+	case *ast.Declare:
+		return n.Token
+	case *ast.ReturnStatement:
+		return n.Token
+	case *ast.ExpressionStatement:
+		return n.Token
+	case *ast.ImportStatement:
+		return n.Token
+	case *ast.BlockStatement:
+		return n.Token
+	case *ast.Identifier:
+		return n.Token
+	case *ast.Boolean:
+		return n.Token
+	case *ast.Null:
+		return n.Token
+	case *ast.IntegerLiteral:
+		return n.Token
+	case *ast.FloatLiteral:
+		return n.Token
+	case *ast.StringLiteral:
+		return n.Token
+	case *ast.PrefixExpression:
+		return n.Token
+	case *ast.InfixExpression:
+		return n.Token
+	case *ast.IncPostExpression:
+		return n.Token
+	case *ast.IncPreExpression:
+		return n.Token
+	case *ast.IfExpression:
+		return n.Token
+	case *ast.WhileExpression:
+		return n.Token
+	case *ast.FunctionLiteral:
+		return n.Token
+	case *ast.Parameter:
+		return n.Token
+	case *ast.CallExpression:
+		return n.Token
+	case *ast.ArrayLiteral:
+		return n.Token
+	case *ast.IndexExpression:
+		return n.Token
+	case *ast.HashLiteral:
+		return n.Token
+	}
+	return token.Token{}
+}
@@ -0,0 +1,136 @@
+package format
+
+import (
+	"gold/lexer"
+	"gold/parser"
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x=5;", "let x = 5\n"},
+		{"let   x = 5;let y=x", "let x = 5\nlet y = x\n"},
+		{"endeavouros a = 1; larry b = [1,2 , 3]", "mint a = 1\nlarr b = [1, 2, 3]\n"},
+		{"lflt f = 2.", "lflt f = 2.0\n"},
+		{"(1 + 2) * 3; 1 + (2 * 3); 1 - (2 - 3); (1 - 2) - 3", "(1 + 2) * 3\n1 + 2 * 3\n1 - (2 - 3)\n1 - 2 - 3\n"},
+		{"-(-5); !(1 < 2); -(a + b)", "-(-5)\n!(1 < 2);\n-(a + b)\n"},
+		{"let x = 1; -x; x++; ++x", "let x = 1;\n-x\nx++;\n++x\n"},
+		{"a; (b + c) * d; [1][0]", "a;\n(b + c) * d;\n[1][0]\n"},
+		{`{"a":1,"b" : [1]}["a"]`, "{\"a\": 1, \"b\": [1]}[\"a\"]\n"},
+		{
+			"if (x > 1) { 10 } else { 20 }",
+			"if (x > 1) {\n  10\n} else {\n  20\n}\n",
+		},
+		{
+			"mint f = fn(mint a, lstr b) { while (a < 10) { a++ }; return a; }",
+			"mint f = fn(mint a, lstr b) {\n  while (a < 10) {\n    a++\n  }\n  return a\n}\n",
+		},
+		{"let f = fn() { }; f()", "let f = fn() {}\nf()\n"},
+		{"!(if (false) { 5; })", "!(if (false) {\n  5\n})\n"},
+		{`import   "lib/math";scaled(2)`, "import \"lib/math\"\nscaled(2)\n"},
+	}
+
+	for _, tt := range tests {
+		output, err := Source([]byte(tt.input))
+		if err != nil {
+			t.Fatalf("Source(%q) returned an error: %s", tt.input, err)
+		}
+
+		if string(output) != tt.expected {
+			t.Errorf("wrong output for %q.\nwant=%q\ngot =%q", tt.input, tt.expected, output)
+		}
+	}
+}
+
+func TestSourceComments(t *testing.T) {
+	input := `// Header comment
+
+let x = 5 // five
+// before y
+let y = fn(mint a) {
+    // inside
+    return a // result
+
+
+    // end of body
+}
+
+
+y(x)
+// final`
+
+	expected := `// Header comment
+
+let x = 5 // five
+// before y
+let y = fn(mint a) {
+  // inside
+  return a // result
+
+  // end of body
+}
+
+y(x)
+// final
+`
+
+	output, err := Source([]byte(input))
+	if err != nil {
+		t.Fatalf("Source returned an error: %s", err)
+	}
+
+	if string(output) != expected {
+		t.Errorf("wrong output.\nwant=%q\ngot =%q", expected, output)
+	}
+}
+
+func TestSourceIsStable(t *testing.T) {
+	inputs := []string{
+		"let x = 0\nwhile (x++ < 10) {\n  print(x)\n}\n[[1, 1, 1]][0][0]",
+		"lint x = 0; mint f = fn(mint a) { if (5 > 2) { return a } }; f(x)",
+		"may c = fn(mint a) { return fn() { return a * (2 + a) / -a } }; c(1)()",
+		`ldct d = {1: "one", true: [1, 2.5], "k": {}}; d[1]`,
+		"let a = 1; (a); [a]; -a; ++a; --a; a--",
+	}
+
+	for _, input := range inputs {
+		first, err := Source([]byte(input))
+		if err != nil {
+			t.Fatalf("Source(%q) returned an error: %s", input, err)
+		}
+
+		second, err := Source(first)
+		if err != nil {
+			t.Fatalf("formatted source does not parse: %s\n%s", err, first)
+		}
+		if string(first) != string(second) {
+			t.Errorf("formatting is not stable.\nfirst =%q\nsecond=%q", first, second)
+		}
+
+		if parse(t, input) != parse(t, string(first)) {
+			t.Errorf("formatting changed the program.\nbefore=%s\nafter =%s",
+				parse(t, input), parse(t, string(first)))
+		}
+	}
+}
+
+func TestSourceParseError(t *testing.T) {
+	_, err := Source([]byte("let = ;"))
+	if err == nil {
+		t.Errorf("Source did not report the parse error")
+	}
+}
+
+func parse(t *testing.T, input string) string {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parse errors: %v", p.Errors())
+	}
+	return program.String()
+}
@@ -0,0 +1,240 @@
+// Package gold compiles and runs Gold scripts from a Go application:
+//
+//	program, diagnostics := gold.Compile(`let total = 1 + 2`)
+//	if len(diagnostics) != 0 {
+//		// report them
+//	}
+//	result, err := program.Run(ctx, nil)
+//	var total int
+//	err = result.Get("total", &total)
+//
+// A Program is never modified once compiled, so it can run any number of
+// times, concurrently, each run in its own VM.
+package gold
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gold/compiler"
+	"gold/host"
+	"gold/lexer"
+	"gold/object"
+	"gold/parser"
+	"gold/token"
+	"gold/vm"
+	"sort"
+)
+
+// Options change how a script is compiled and run.
+type Options struct {
+	// Globals declares the variables the host sets before each run, with
+	// their type. The script uses them without defining them.
+	Globals map[string]object.Attribute
+	// Registry holds the Go functions available to the script. Defaults to
+	// the builtins only.
+	Registry *host.Registry
+	// Resolver finds the modules the script imports, relative to the root
+	// of the resolver. Without it, the script cannot import modules.
+	Resolver compiler.Resolver
+	// Optimization is one of the compiler.Optimization levels.
+	Optimization int
+	// Config limits the resources of every run, and gives the script its
+	// arguments, environment, files and standard streams.
+	Config vm.Config
+}
+
+// Diagnostic is an error found in a script, with the position of the token
+// it is about. Lines and columns start at 1.
+type Diagnostic struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (d Diagnostic) Error() string {
+	return fmt.Sprintf("%d:%d: %s", d.Line, d.Column, d.Message)
+}
+
+// Program is a compiled script.
+type Program struct {
+	bytecode *compiler.Bytecode
+	options  Options
+	// indexes maps the name of every global to its index in the store
+	indexes map[string]int
+	// hostGlobals are the names of Options.Globals, sorted
+	hostGlobals []string
+}
+
+// Compile compiles src with the default Options.
+func Compile(src string) (*Program, []Diagnostic) {
+	return CompileWithOptions(src, Options{})
+}
+
+// CompileWithOptions compiles src. It returns a nil Program and the errors
+// found when the script is invalid.
+func CompileWithOptions(src string, options Options) (*Program, []Diagnostic) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if errs := p.ParseErrors(); len(errs) != 0 {
+		diagnostics := make([]Diagnostic, len(errs))
+		for i, e := range errs {
+			diagnostics[i] = diagnostic(e.Token, e.Message)
+		}
+		return nil, diagnostics
+	}
+
+	builtins := object.Builtins
+	if options.Registry != nil {
+		builtins = options.Registry.Builtins()
+	}
+	symbolTable := compiler.NewSymbolTable()
+	for i, v := range builtins {
+		symbolTable.DefineBuiltin(i, v.Name, v.Type)
+	}
+
+	hostGlobals := make([]string, 0, len(options.Globals))
+	for name := range options.Globals {
+		hostGlobals = append(hostGlobals, name)
+	}
+	sort.Strings(hostGlobals)
+	for _, name := range hostGlobals {
+		symbolTable.Define(name, options.Globals[name])
+	}
+
+	comp := compiler.NewWithState(symbolTable, []object.Object{})
+	if options.Resolver != nil {
+		comp.SetResolver(options.Resolver, "")
+	}
+	comp.SetOptimization(options.Optimization)
+	_, err := comp.Compile(program)
+	if err != nil {
+		var compileErr *compiler.CompileError
+		if errors.As(err, &compileErr) {
+			return nil, []Diagnostic{diagnostic(compileErr.Token, compileErr.Error())}
+		}
+		return nil, []Diagnostic{{Line: 1, Column: 1, Message: err.Error()}}
+	}
+
+	indexes := map[string]int{}
+	for i, name := range comp.GlobalNames() {
+		if name != "" {
+			indexes[name] = i
+		}
+	}
+
+	return &Program{
+		bytecode:    comp.Bytecode(),
+		options:     options,
+		indexes:     indexes,
+		hostGlobals: hostGlobals,
+	}, nil
+}
+
+func diagnostic(tok token.Token, message string) Diagnostic {
+	return Diagnostic{Line: tok.Line, Column: tok.Column, Message: message}
+}
+
+// Run runs the program in a new VM until it ends, fails, or ctx is done.
+// globals gives the value of the variables declared in Options.Globals,
+// converted with host.ToObject; the nullable ones may be left out.
+func (p *Program) Run(ctx context.Context, globals map[string]any) (*Result, error) {
+	config := p.options.Config
+	if p.options.Registry != nil {
+		config.Builtins = p.options.Registry.Builtins()
+	}
+	machine := vm.NewWithConfig(p.bytecode, config)
+
+	for name := range globals {
+		if _, ok := p.options.Globals[name]; !ok {
+			return nil, fmt.Errorf("global %q is not declared in the options", name)
+		}
+	}
+	store := machine.Globals()
+	for _, name := range p.hostGlobals {
+		value, err := global(name, p.options.Globals[name], globals)
+		if err != nil {
+			return nil, err
+		}
+		store[p.indexes[name]] = value
+	}
+
+	err := machine.RunContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	exitCode, exited := machine.ExitCode()
+	return &Result{
+		Value:    machine.LastPoppedStackElem(),
+		globals:  machine.Globals(),
+		indexes:  p.indexes,
+		exitCode: exitCode,
+		exited:   exited,
+	}, nil
+}
+
+// global converts the value given for a host global and checks it against
+// the declared type.
+func global(name string, attribute object.Attribute, globals map[string]any) (object.Object, error) {
+	value, ok := globals[name]
+	if !ok && !attribute.Nullable {
+		return nil, fmt.Errorf("global %q is not nullable and has no value", name)
+	}
+
+	obj, err := host.ToObject(value)
+	if err != nil {
+		return nil, fmt.Errorf("global %q: %w", name, err)
+	}
+	if _, ok := obj.(*object.Null); ok {
+		if !attribute.Nullable {
+			return nil, fmt.Errorf("global %q is not nullable", name)
+		}
+		return vm.Null, nil
+	}
+	if attribute.ObjectType != object.ANY && obj.Type() != attribute.ObjectType {
+		return nil, fmt.Errorf("global %q is %s, got %s", name, attribute.ObjectType, obj.Type())
+	}
+	return obj, nil
+}
+
+// Result is the state of a VM after a run.
+type Result struct {
+	// Value is the value of the last expression statement.
+	Value object.Object
+
+	globals  []object.Object
+	indexes  map[string]int
+	exitCode int
+	exited   bool
+}
+
+// ExitCode returns the status the script gave to exit, and false when it
+// ended without calling it.
+func (r *Result) ExitCode() (int, bool) {
+	return r.exitCode, r.exited
+}
+
+// Global returns the value of the global variable name. It is false when the
+// script has no such global.
+func (r *Result) Global(name string) (object.Object, bool) {
+	index, ok := r.indexes[name]
+	if !ok {
+		return nil, false
+	}
+	value := r.globals[index]
+	if value == nil {
+		value = vm.Null
+	}
+	return value, true
+}
+
+// Get stores the value of the global variable name in the Go value target
+// points to, converted with host.FromObject.
+func (r *Result) Get(name string, target any) error {
+	value, ok := r.Global(name)
+	if !ok {
+		return fmt.Errorf("global %q is not defined", name)
+	}
+	return host.FromObject(value, target)
+}
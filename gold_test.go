@@ -0,0 +1,291 @@
+package gold
+
+import (
+	"context"
+	"errors"
+	"gold/compiler"
+	"gold/host"
+	"gold/object"
+	"gold/vm"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCompileAndRun(t *testing.T) {
+	program, diagnostics := Compile(`
+let total = 0
+let i = 0
+while (i < 5) { i++; total = total + i }
+let names = ["a", "b"]
+total * 2`)
+	if len(diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diagnostics)
+	}
+
+	result, err := program.Run(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+	if result.Value.Inspect() != "30" {
+		t.Errorf("wrong value. want=30, got=%s", result.Value.Inspect())
+	}
+
+	var total int
+	err = result.Get("total", &total)
+	if err != nil || total != 15 {
+		t.Errorf("wrong total. want=15, got=%d (%v)", total, err)
+	}
+	var names []string
+	err = result.Get("names", &names)
+	if err != nil || !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("wrong names. got=%v (%v)", names, err)
+	}
+
+	if _, ok := result.Global("missing"); ok {
+		t.Errorf("expected missing not to be a global")
+	}
+	err = result.Get("missing", &total)
+	if err == nil || err.Error() != `global "missing" is not defined` {
+		t.Errorf("wrong error for a missing global. got=%v", err)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []Diagnostic
+	}{
+		{"let x = ", []Diagnostic{{Line: 1, Column: 9, Message: "no prefix parse function for EOF found"}}},
+		{"let x = 1\nx + \"a\"", []Diagnostic{{Line: 2, Column: 1, Message: "trying to do '+' with other than numbers or string. left=INTEGER right=STRING"}}},
+	}
+
+	for _, tt := range tests {
+		program, diagnostics := Compile(tt.input)
+		if program != nil {
+			t.Errorf("expected no program for %q", tt.input)
+		}
+		if !reflect.DeepEqual(diagnostics, tt.expected) {
+			t.Errorf("wrong diagnostics for %q.\nwant=%+v\ngot =%+v", tt.input, tt.expected, diagnostics)
+		}
+	}
+}
+
+func TestHostGlobals(t *testing.T) {
+	options := Options{
+		Globals: map[string]object.Attribute{
+			"limit": {ObjectType: object.INTEGER_OBJ},
+			"name":  {ObjectType: object.STRING_OBJ, Nullable: true},
+		},
+	}
+	program, diagnostics := CompileWithOptions(`let doubled = limit * 2; name`, options)
+	if len(diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diagnostics)
+	}
+
+	result, err := program.Run(context.Background(), map[string]any{"limit": 21, "name": "gold"})
+	if err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+	var doubled int
+	err = result.Get("doubled", &doubled)
+	if err != nil || doubled != 42 {
+		t.Errorf("wrong doubled. want=42, got=%d (%v)", doubled, err)
+	}
+	if result.Value.Inspect() != "gold" {
+		t.Errorf("wrong value. want=gold, got=%s", result.Value.Inspect())
+	}
+
+	result, err = program.Run(context.Background(), map[string]any{"limit": 1})
+	if err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+	if result.Value != vm.Null {
+		t.Errorf("expected null for a nullable global left out, got=%s", result.Value.Inspect())
+	}
+
+	errorTests := []struct {
+		globals  map[string]any
+		expected string
+	}{
+		{map[string]any{}, `global "limit" is not nullable and has no value`},
+		{map[string]any{"limit": nil}, `global "limit" is not nullable`},
+		{map[string]any{"limit": "one"}, `global "limit" is INTEGER, got STRING`},
+		{map[string]any{"limit": 1, "other": 2}, `global "other" is not declared in the options`},
+	}
+	for _, tt := range errorTests {
+		_, err := program.Run(context.Background(), tt.globals)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error for %v. want=%q, got=%v", tt.globals, tt.expected, err)
+		}
+	}
+
+	_, diagnostics = CompileWithOptions(`lstr s = limit`, options)
+	if len(diagnostics) != 1 {
+		t.Errorf("expected the type of limit to be checked, got=%v", diagnostics)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	registry := host.NewRegistry()
+	err := registry.RegisterFunc("square", func(x int) int { return x * x })
+	if err != nil {
+		t.Fatalf("RegisterFunc returned an error: %s", err)
+	}
+
+	program, diagnostics := CompileWithOptions("square(7)", Options{Registry: registry})
+	if len(diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diagnostics)
+	}
+	result, err := program.Run(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+	if result.Value.Inspect() != "49" {
+		t.Errorf("wrong value. want=49, got=%s", result.Value.Inspect())
+	}
+}
+
+func TestImports(t *testing.T) {
+	modules := compiler.MapResolver{"lib/rates.gold": "let _base = 2; let rate = _base * 10"}
+	program, diagnostics := CompileWithOptions(`import "lib/rates"; rate + 1`, Options{Resolver: modules})
+	if len(diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diagnostics)
+	}
+	result, err := program.Run(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+	var rate int
+	err = result.Get("rate", &rate)
+	if err != nil || rate != 20 || result.Value.Inspect() != "21" {
+		t.Errorf("wrong result. rate=%d, value=%s, err=%v", rate, result.Value.Inspect(), err)
+	}
+
+	_, diagnostics = Compile(`import "lib/rates"`)
+	if len(diagnostics) != 1 || diagnostics[0].Error() != `1:1: cannot import "lib/rates": modules are not available` {
+		t.Errorf("wrong diagnostics without resolver: %v", diagnostics)
+	}
+}
+
+func TestConcurrentRuns(t *testing.T) {
+	options := Options{Globals: map[string]object.Attribute{"n": {ObjectType: object.INTEGER_OBJ}}}
+	program, diagnostics := CompileWithOptions(`
+may fib = fn(lint x) {
+  if (x < 2) { return x }
+  return fib(x - 1) + fib(x - 2)
+}
+let counter = 0
+counter++
+fib(n)`, options)
+	if len(diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diagnostics)
+	}
+
+	expected := []int64{0, 1, 1, 2, 3, 5, 8, 13, 21, 34, 55, 89}
+	var wg sync.WaitGroup
+	errs := make(chan error, len(expected))
+	for n := range expected {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			result, err := program.Run(context.Background(), map[string]any{"n": n})
+			if err != nil {
+				errs <- err
+				return
+			}
+			var counter int
+			err = result.Get("counter", &counter)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if counter != 1 {
+				errs <- errors.New("runs share their globals")
+			}
+			if value := result.Value.(*object.Integer).Value; value != expected[n] {
+				errs <- errors.New("wrong result " + result.Value.Inspect())
+			}
+		}(n)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	program, diagnostics := Compile("while (true) { }")
+	if len(diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diagnostics)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := program.Run(ctx, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to stop the run, got=%v", err)
+	}
+}
+
+func TestArgsAndExit(t *testing.T) {
+	program, diagnostics := CompileWithOptions(`
+let arguments = args()
+may home = env("HOME")
+if (len(arguments) == 1) { exit(2) }
+let done = true`, Options{Config: vm.Config{
+		Args:   []string{"stop"},
+		Getenv: func(name string) (string, bool) { return "/home/gold", name == "HOME" },
+	}})
+	if len(diagnostics) != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diagnostics)
+	}
+
+	result, err := program.Run(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+	code, exited := result.ExitCode()
+	if !exited || code != 2 {
+		t.Errorf("wrong exit. want=2, true, got=%d, %t", code, exited)
+	}
+	var home string
+	err = result.Get("home", &home)
+	if err != nil || home != "/home/gold" {
+		t.Errorf("wrong home. want=/home/gold, got=%q (%v)", home, err)
+	}
+	if done, _ := result.Global("done"); done != vm.Null {
+		t.Errorf("expected the script to stop before done, got=%s", done.Inspect())
+	}
+}
+
+func TestIntegerDivisionErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x = 0; 1 / x", "integer division by zero"},
+		{"1 / 0", "integer division by zero"},
+		{"let x = -1; (-9223372036854775807 - 1) / x", "integer overflow: -9223372036854775808 / -1"},
+		{"let x = 0; 1.0 / x", ""},
+	}
+
+	for _, tt := range tests {
+		program, diagnostics := Compile(tt.input)
+		if len(diagnostics) != 0 {
+			t.Fatalf("unexpected diagnostics for %q: %v", tt.input, diagnostics)
+		}
+		_, err := program.Run(context.Background(), nil)
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("unexpected error for %q: %s", tt.input, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
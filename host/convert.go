@@ -0,0 +1,389 @@
+package host
+
+import (
+	"fmt"
+	"gold/object"
+	"math"
+	"reflect"
+	"sort"
+)
+
+var (
+	objectInterface = reflect.TypeOf((*object.Object)(nil)).Elem()
+	anyType         = reflect.TypeOf((*any)(nil)).Elem()
+)
+
+// ToObject converts a Go value to a Gold one. Booleans, integers, floats and
+// strings become their Gold counterpart, slices and arrays become arrays,
+// maps become hashes and structs become hashes keyed by the name of their
+// exported fields, or by their `gold` tag. nil, and nil pointers, become
+// null. An object.Object is returned as is.
+func ToObject(v any) (object.Object, error) {
+	return toObject(reflect.ValueOf(v))
+}
+
+func toObject(v reflect.Value) (object.Object, error) {
+	if !v.IsValid() {
+		return object.NULL, nil
+	}
+	if v.Type().Implements(objectInterface) {
+		if v.Kind() == reflect.Interface && v.IsNil() {
+			return object.NULL, nil
+		}
+		return v.Interface().(object.Object), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return object.NativeBoolToBooleanObject(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &object.Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%d overflows a Gold integer", v.Uint())
+		}
+		return &object.Integer{Value: int64(v.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return &object.Float{Value: v.Float()}, nil
+	case reflect.String:
+		return &object.String{Value: v.String()}, nil
+
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return object.NULL, nil
+		}
+		return toObject(v.Elem())
+
+	case reflect.Slice, reflect.Array:
+		elements := make([]object.Object, v.Len())
+		for i := range elements {
+			element, err := toObject(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = element
+		}
+		return &object.Array{Elements: elements}, nil
+
+	case reflect.Map:
+		// Go maps have no order, so the pairs are sorted by key to
+		// convert a map the same way every time.
+		pairs := make([]object.HashPair, 0, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := toObject(iter.Key())
+			if err != nil {
+				return nil, err
+			}
+			value, err := toObject(iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			pairs = append(pairs, object.HashPair{Key: key, Value: value})
+		}
+		sort.Slice(pairs, func(i, j int) bool {
+			return pairs[i].Key.Inspect() < pairs[j].Key.Inspect()
+		})
+		hash := object.NewHash(len(pairs))
+		for _, pair := range pairs {
+			err := hash.Set(pair.Key, pair.Value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return hash, nil
+
+	case reflect.Struct:
+		hash := &object.Hash{}
+		for _, field := range fields(v.Type()) {
+			value, err := toObject(v.FieldByIndex(field.index))
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %w", field.name, err)
+			}
+			hash.Set(&object.String{Value: field.name}, value)
+		}
+		return hash, nil
+	}
+
+	return nil, fmt.Errorf("cannot convert %s to a Gold value", v.Type())
+}
+
+// FromObject stores a Gold value in the Go value target points to, with the
+// conversions of ToObject the other way around. An integer is accepted where
+// a float is expected, and null sets pointers, slices, maps and interfaces
+// to nil. Hash keys without a matching field are ignored. A tuple fills a
+// slice, an array or the fields of a struct by position. An any receives an
+// int64, a float64, a string, a bool, nil, a []any or a map[any]any, whose
+// keys are arrays of any for tuples.
+func FromObject(obj object.Object, target any) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return fmt.Errorf("target must be a non-nil pointer, got %T", target)
+	}
+	return fromObject(obj, v.Elem())
+}
+
+func fromObject(obj object.Object, v reflect.Value) error {
+	if obj == nil {
+		obj = object.NULL
+	}
+	if v.Type() == objectInterface {
+		v.Set(reflect.ValueOf(obj))
+		return nil
+	}
+
+	if _, ok := obj.(*object.Null); ok {
+		switch v.Kind() {
+		case reflect.Pointer, reflect.Interface, reflect.Slice, reflect.Map:
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		return mismatch(obj, v.Type())
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		element := reflect.New(v.Type().Elem())
+		err := fromObject(obj, element.Elem())
+		if err != nil {
+			return err
+		}
+		v.Set(element)
+		return nil
+
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return mismatch(obj, v.Type())
+		}
+		value, err := toGo(obj)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(&value).Elem())
+		return nil
+	}
+
+	switch obj := obj.(type) {
+	case *object.Boolean:
+		if v.Kind() == reflect.Bool {
+			v.SetBool(obj.Value)
+			return nil
+		}
+
+	case *object.Integer:
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if v.OverflowInt(obj.Value) {
+				return fmt.Errorf("%d overflows %s", obj.Value, v.Type())
+			}
+			v.SetInt(obj.Value)
+			return nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if obj.Value < 0 || v.OverflowUint(uint64(obj.Value)) {
+				return fmt.Errorf("%d overflows %s", obj.Value, v.Type())
+			}
+			v.SetUint(uint64(obj.Value))
+			return nil
+		case reflect.Float32, reflect.Float64:
+			v.SetFloat(float64(obj.Value))
+			return nil
+		}
+
+	case *object.Float:
+		switch v.Kind() {
+		case reflect.Float32, reflect.Float64:
+			v.SetFloat(obj.Value)
+			return nil
+		}
+
+	case *object.String:
+		if v.Kind() == reflect.String {
+			v.SetString(obj.Value)
+			return nil
+		}
+
+	case *object.Array:
+		if ok, err := fromElements("an array", obj.Elements, v); ok {
+			return err
+		}
+
+	case *object.Tuple:
+		if v.Kind() == reflect.Struct {
+			fields := fields(v.Type())
+			if len(obj.Elements) != len(fields) {
+				return fmt.Errorf("cannot convert a tuple of %d elements to %s", len(obj.Elements), v.Type())
+			}
+			for i, field := range fields {
+				err := fromObject(obj.Elements[i], v.FieldByIndex(field.index))
+				if err != nil {
+					return fmt.Errorf("field %s: %w", field.name, err)
+				}
+			}
+			return nil
+		}
+		if ok, err := fromElements("a tuple", obj.Elements, v); ok {
+			return err
+		}
+
+	case *object.Hash:
+		switch v.Kind() {
+		case reflect.Map:
+			m := reflect.MakeMapWithSize(v.Type(), obj.Len())
+			for _, pair := range obj.Pairs() {
+				key := reflect.New(v.Type().Key()).Elem()
+				err := fromObject(pair.Key, key)
+				if err != nil {
+					return err
+				}
+				value := reflect.New(v.Type().Elem()).Elem()
+				err = fromObject(pair.Value, value)
+				if err != nil {
+					return err
+				}
+				m.SetMapIndex(key, value)
+			}
+			v.Set(m)
+			return nil
+		case reflect.Struct:
+			for _, field := range fields(v.Type()) {
+				value, ok, _ := obj.Get(&object.String{Value: field.name})
+				if !ok {
+					continue
+				}
+				err := fromObject(value, v.FieldByIndex(field.index))
+				if err != nil {
+					return fmt.Errorf("field %s: %w", field.name, err)
+				}
+			}
+			return nil
+		}
+	}
+
+	return mismatch(obj, v.Type())
+}
+
+// fromElements stores the elements of an array or a tuple in a slice or an
+// array. It returns false when v is neither.
+func fromElements(what string, elements []object.Object, v reflect.Value) (bool, error) {
+	switch v.Kind() {
+	case reflect.Slice:
+		slice := reflect.MakeSlice(v.Type(), len(elements), len(elements))
+		for i, element := range elements {
+			err := fromObject(element, slice.Index(i))
+			if err != nil {
+				return true, err
+			}
+		}
+		v.Set(slice)
+		return true, nil
+	case reflect.Array:
+		if len(elements) != v.Len() {
+			return true, fmt.Errorf("cannot convert %s of %d elements to %s", what, len(elements), v.Type())
+		}
+		for i, element := range elements {
+			err := fromObject(element, v.Index(i))
+			if err != nil {
+				return true, err
+			}
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// toGo converts obj to the Go type closest to it, for an any.
+func toGo(obj object.Object) (any, error) {
+	switch obj := obj.(type) {
+	case *object.Null:
+		return nil, nil
+	case *object.Boolean:
+		return obj.Value, nil
+	case *object.Integer:
+		return obj.Value, nil
+	case *object.Float:
+		return obj.Value, nil
+	case *object.String:
+		return obj.Value, nil
+	case *object.Array:
+		return elementsToGo(obj.Elements)
+	case *object.Tuple:
+		return elementsToGo(obj.Elements)
+	case *object.Hash:
+		m := make(map[any]any, obj.Len())
+		for _, pair := range obj.Pairs() {
+			key, err := keyToGo(pair.Key)
+			if err != nil {
+				return nil, err
+			}
+			value, err := toGo(pair.Value)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = value
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("cannot convert %s to a Go value", obj.Type())
+}
+
+func elementsToGo(elements []object.Object) ([]any, error) {
+	values := make([]any, len(elements))
+	for i, element := range elements {
+		value, err := toGo(element)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// keyToGo is toGo for a hash key. A tuple becomes an array of any, since a
+// slice can't be the key of a map.
+func keyToGo(obj object.Object) (any, error) {
+	tuple, ok := obj.(*object.Tuple)
+	if !ok {
+		return toGo(obj)
+	}
+	key := reflect.New(reflect.ArrayOf(len(tuple.Elements), anyType)).Elem()
+	for i, element := range tuple.Elements {
+		value, err := keyToGo(element)
+		if err != nil {
+			return nil, err
+		}
+		if value != nil {
+			key.Index(i).Set(reflect.ValueOf(value))
+		}
+	}
+	return key.Interface(), nil
+}
+
+func mismatch(obj object.Object, t reflect.Type) error {
+	return fmt.Errorf("cannot convert %s to %s", obj.Type(), t)
+}
+
+type field struct {
+	name  string
+	index []int
+}
+
+// fields lists the exported fields of a struct type with the key they have
+// in a hash: the `gold` tag if any, the name of the field otherwise. A tag of
+// "-" leaves the field out.
+func fields(t reflect.Type) []field {
+	var out []field
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("gold"); ok {
+			if tag == "-" {
+				continue
+			}
+			name = tag
+		}
+		out = append(out, field{name: name, index: f.Index})
+	}
+	return out
+}
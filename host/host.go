@@ -0,0 +1,212 @@
+// Package host embeds Gold in a Go application. A Registry holds the Go
+// functions exposed to the programs, next to the builtins, with the
+// signature the compiler checks their calls against:
+//
+//	r := host.NewRegistry()
+//	err := r.RegisterFunc("greet", func(name string) string {
+//		return "hello " + name
+//	})
+//	comp := r.Compiler()
+//	// parse and compile the program with comp
+//	machine := r.VM(comp.Bytecode(), vm.Config{})
+//	err = machine.Run()
+package host
+
+import (
+	"errors"
+	"fmt"
+	"gold/compiler"
+	"gold/object"
+	"gold/token"
+	"gold/vm"
+	"reflect"
+)
+
+var errorInterface = reflect.TypeOf((*error)(nil)).Elem()
+
+// Registry is the list of functions available to the programs: the
+// builtins of object.Builtins followed by the registered ones.
+type Registry struct {
+	builtins []object.BuiltinDefinition
+}
+
+func NewRegistry() *Registry {
+	builtins := make([]object.BuiltinDefinition, len(object.Builtins))
+	copy(builtins, object.Builtins)
+	return &Registry{builtins: builtins}
+}
+
+// Register exposes fn under name. Calls are checked by the compiler against
+// signature, whose ArgsObjectType and ArgsNullable give the parameters and
+// ObjectType and Nullable the result, so fn receives as many arguments as
+// there are parameters. Like a builtin, fn reports an error by returning an
+// *object.Error, and nil is null.
+func (r *Registry) Register(name string, signature object.Attribute, fn object.BuiltinFunction) error {
+	if !isIdentifier(name) {
+		return fmt.Errorf("invalid function name %q, only letters and _ are allowed", name)
+	}
+	if token.LookupIdent(name) != token.IDENT {
+		return fmt.Errorf("invalid function name %q, it is a keyword", name)
+	}
+	for _, def := range r.builtins {
+		if def.Name == name {
+			return fmt.Errorf("function %q is already defined", name)
+		}
+	}
+	if fn == nil {
+		return fmt.Errorf("function %q has no implementation", name)
+	}
+	if len(signature.ArgsObjectType) != len(signature.ArgsNullable) {
+		return fmt.Errorf("function %q has %d argument types but %d nullable flags",
+			name, len(signature.ArgsObjectType), len(signature.ArgsNullable))
+	}
+
+	signature.IsFunction = true
+	r.builtins = append(r.builtins, object.BuiltinDefinition{
+		Name:    name,
+		Builtin: &object.Builtin{Fn: fn},
+		Type:    signature,
+	})
+	return nil
+}
+
+// RegisterFunc exposes the Go function fn under name, with a signature
+// derived from its type. Pointers and interfaces are nullable, the other
+// types are not. fn returns nothing, a value, an error, or a value and an
+// error; a non-nil error becomes an error object. Arguments and results are
+// converted with FromObject and ToObject.
+func (r *Registry) RegisterFunc(name string, fn any) error {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return fmt.Errorf("function %q: expected a func, got %T", name, fn)
+	}
+	t := v.Type()
+	if t.IsVariadic() {
+		return fmt.Errorf("function %q: variadic functions are not supported", name)
+	}
+
+	signature := object.Attribute{
+		ArgsObjectType: make([]object.ObjectType, t.NumIn()),
+		ArgsNullable:   make([]bool, t.NumIn()),
+	}
+	for i := 0; i < t.NumIn(); i++ {
+		objectType, nullable, err := typeOf(t.In(i))
+		if err != nil {
+			return fmt.Errorf("function %q, argument %d: %w", name, i, err)
+		}
+		signature.ArgsObjectType[i] = objectType
+		signature.ArgsNullable[i] = nullable
+	}
+
+	results := t.NumOut()
+	returnsError := results > 0 && t.Out(results-1) == errorInterface
+	if returnsError {
+		results--
+	}
+	switch results {
+	case 0:
+		signature.ObjectType = object.NULL_OBJ
+		signature.Nullable = true
+	case 1:
+		objectType, nullable, err := typeOf(t.Out(0))
+		if err != nil {
+			return fmt.Errorf("function %q, result: %w", name, err)
+		}
+		signature.ObjectType = objectType
+		signature.Nullable = nullable
+	default:
+		return fmt.Errorf("function %q: at most one result and an error are supported", name)
+	}
+
+	return r.Register(name, signature, func(args ...object.Object) object.Object {
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			in[i] = reflect.New(t.In(i)).Elem()
+			err := fromObject(arg, in[i])
+			if err != nil {
+				return &object.Error{Message: fmt.Sprintf("argument %d to `%s`: %s", i, name, err)}
+			}
+		}
+
+		out := v.Call(in)
+		if returnsError {
+			if err, _ := out[len(out)-1].Interface().(error); err != nil {
+				return &object.Error{Message: err.Error()}
+			}
+		}
+		if results == 0 {
+			return nil
+		}
+		result, err := toObject(out[0])
+		if err != nil {
+			return &object.Error{Message: fmt.Sprintf("result of `%s`: %s", name, err)}
+		}
+		if _, ok := result.(*object.Null); ok {
+			return nil
+		}
+		return result
+	})
+}
+
+// Builtins returns the functions of the registry, by index.
+func (r *Registry) Builtins() []object.BuiltinDefinition {
+	return r.builtins
+}
+
+// Compiler returns a compiler for programs calling the functions of the
+// registry.
+func (r *Registry) Compiler() *compiler.Compiler {
+	return compiler.NewWithBuiltins(r.builtins)
+}
+
+// VM returns a VM running bytecode compiled by r.Compiler. The Builtins of
+// config are replaced by the functions of the registry.
+func (r *Registry) VM(bytecode *compiler.Bytecode, config vm.Config) *vm.VM {
+	config.Builtins = r.builtins
+	return vm.NewWithConfig(bytecode, config)
+}
+
+// typeOf gives the Gold type of the values of a Go type, and whether they
+// can be null.
+func typeOf(t reflect.Type) (object.ObjectType, bool, error) {
+	if t == objectInterface {
+		return object.ANY, true, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return object.BOOLEAN_OBJ, false, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return object.INTEGER_OBJ, false, nil
+	case reflect.Float32, reflect.Float64:
+		return object.FLOAT_OBJ, false, nil
+	case reflect.String:
+		return object.STRING_OBJ, false, nil
+	case reflect.Slice, reflect.Array:
+		return object.ARRAY_OBJ, false, nil
+	case reflect.Map, reflect.Struct:
+		return object.HASH_OBJ, false, nil
+	case reflect.Interface:
+		if t.NumMethod() == 0 {
+			return object.ANY, true, nil
+		}
+	case reflect.Pointer:
+		objectType, _, err := typeOf(t.Elem())
+		return objectType, true, err
+	}
+	return "", false, errors.New("unsupported type " + t.String())
+}
+
+// isIdentifier reports whether the lexer reads name as one identifier.
+func isIdentifier(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, ch := range name {
+		if !('a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,358 @@
+package host
+
+import (
+	"errors"
+	"gold/compiler"
+	"gold/lexer"
+	"gold/object"
+	"gold/parser"
+	"gold/verifier"
+	"gold/vm"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type point struct {
+	X     int64
+	Y     int64
+	Label string `gold:"label"`
+	skip  bool
+}
+
+func newRegistry(t *testing.T) *Registry {
+	t.Helper()
+
+	r := NewRegistry()
+	funcs := map[string]any{
+		"double": func(x int) int { return 2 * x },
+		"half":   func(x float64) float64 { return x / 2 },
+		"greet":  func(name string) string { return "hello " + name },
+		"sum": func(xs []int) int {
+			total := 0
+			for _, x := range xs {
+				total += x
+			}
+			return total
+		},
+		"origin": func() point { return point{Label: "origin"} },
+		"norm":   func(p point) int64 { return p.X*p.X + p.Y*p.Y },
+		"lookup": func(key string) *string {
+			if key == "gold" {
+				value := "found"
+				return &value
+			}
+			return nil
+		},
+		"fail": func(msg string) (int, error) { return 0, errors.New(msg) },
+		"yes":  func() bool { return true },
+	}
+	for name, fn := range funcs {
+		err := r.RegisterFunc(name, fn)
+		if err != nil {
+			t.Fatalf("RegisterFunc(%q) returned an error: %s", name, err)
+		}
+	}
+
+	err := r.Register("count", object.Attribute{
+		ObjectType:     object.INTEGER_OBJ,
+		ArgsObjectType: []object.ObjectType{object.ANY},
+		ArgsNullable:   []bool{true},
+	}, func(args ...object.Object) object.Object {
+		if arr, ok := args[0].(*object.Array); ok {
+			return &object.Integer{Value: int64(len(arr.Elements))}
+		}
+		return &object.Integer{Value: 0}
+	})
+	if err != nil {
+		t.Fatalf("Register returned an error: %s", err)
+	}
+	return r
+}
+
+func run(t *testing.T, r *Registry, input string) (object.Object, error) {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parse errors: %v", p.Errors())
+	}
+
+	comp := r.Compiler()
+	_, err := comp.Compile(program)
+	if err != nil {
+		return nil, err
+	}
+	bytecode := comp.Bytecode()
+	err = verifier.VerifyWithBuiltins(bytecode, len(r.Builtins()))
+	if err != nil {
+		t.Fatalf("verifier error: %s", err)
+	}
+
+	machine := r.VM(bytecode, vm.Config{})
+	err = machine.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	return machine.LastPoppedStackElem(), nil
+}
+
+func TestHostFunctions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"double(21)", "42"},
+		{"let x = double(2); x + 1", "5"},
+		{"half(3.0)", "1.500000"},
+		{`greet("gold")`, "hello gold"},
+		{"sum([1, 2, 3])", "6"},
+		{"norm({\"X\": 3, \"Y\": 4})", "25"},
+		{"norm(origin())", "0"},
+		{`lookup("gold")`, "found"},
+		{`lookup("lead")`, "null"},
+		{`fail("broken")`, "ERROR: broken"},
+		{"count([1, 2])", "2"},
+		{`len(greet("")); count(1)`, "0"},
+		{"let f = fn(lint a) { return double(a) }; f(4)", "8"},
+		{"yes() == true", "true"},
+		{"yes() != true", "false"},
+		{"if (yes() == true) { 1 } else { 2 }", "1"},
+		{`lookup("lead") == null`, "true"},
+		{`if (lookup("lead") == null) { 1 } else { 2 }`, "1"},
+	}
+
+	r := newRegistry(t)
+	for _, tt := range tests {
+		result, err := run(t, r, tt.input)
+		if err != nil {
+			t.Errorf("compiler error for %q: %s", tt.input, err)
+			continue
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s", tt.input, tt.expected, result.Inspect())
+		}
+	}
+}
+
+func TestHostFunctionsAreTypeChecked(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`double("two")`, "expect type 'INTEGER'"},
+		{"double(1, 2)", "argument"},
+		{"half(1)", "expect type 'FLOAT'"},
+		{`let s = lookup("gold"); double(len(s))`, "nullable"},
+		{`lstr s = double(1)`, "s"},
+	}
+
+	r := newRegistry(t)
+	for _, tt := range tests {
+		_, err := run(t, r, tt.input)
+		if err == nil {
+			t.Errorf("expected a compiler error for %q", tt.input)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("wrong error for %q. want it to mention %q, got=%q", tt.input, tt.expected, err)
+		}
+	}
+
+	// Without the registry, the functions are unknown
+	comp := compiler.New()
+	_, err := comp.Compile(parser.New(lexer.New("double(1)")).ParseProgram())
+	if err == nil {
+		t.Errorf("expected double to be undefined for the default compiler")
+	}
+}
+
+func TestRegisterErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		fn       any
+		expected string
+	}{
+		{"len", func() {}, `function "len" is already defined`},
+		{"two words", func() {}, `invalid function name "two words", only letters and _ are allowed`},
+		{"let", func() {}, `invalid function name "let", it is a keyword`},
+		{"notfunc", 3, `function "notfunc": expected a func, got int`},
+		{"variadic", func(xs ...int) {}, `function "variadic": variadic functions are not supported`},
+		{"channel", func(c chan int) {}, `function "channel", argument 0: unsupported type chan int`},
+		{"pair", func() (int, int) { return 0, 0 }, `function "pair": at most one result and an error are supported`},
+	}
+
+	for _, tt := range tests {
+		err := NewRegistry().RegisterFunc(tt.name, tt.fn)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%v", tt.name, tt.expected, err)
+		}
+	}
+}
+
+func TestConversions(t *testing.T) {
+	tests := []struct {
+		value    any
+		target   any
+		expected string
+	}{
+		{int8(-3), new(int8), "-3"},
+		{uint(7), new(uint), "7"},
+		{float32(0.5), new(float32), "0.500000"},
+		{"gold", new(string), "gold"},
+		{true, new(bool), "true"},
+		{[]string{"a", "b"}, new([]string), "[a, b]"},
+		{[2]int{1, 2}, new([2]int), "[1, 2]"},
+		{map[int]string{1: "one"}, new(map[int]string), "{1: one}"},
+		{point{X: 1, Y: 2}, new(point), "{X: 1, Y: 2, label: }"},
+		{[]*int{nil}, new([]*int), "[null]"},
+		{nil, new(any), "null"},
+	}
+
+	for _, tt := range tests {
+		obj, err := ToObject(tt.value)
+		if err != nil {
+			t.Errorf("ToObject(%#v) returned an error: %s", tt.value, err)
+			continue
+		}
+		if inspect := obj.Inspect(); inspect != tt.expected {
+			t.Errorf("wrong object for %#v. want=%s, got=%s", tt.value, tt.expected, inspect)
+		}
+
+		err = FromObject(obj, tt.target)
+		if err != nil {
+			t.Errorf("FromObject(%s) returned an error: %s", obj.Inspect(), err)
+			continue
+		}
+		got := reflect.ValueOf(tt.target).Elem().Interface()
+		if !reflect.DeepEqual(got, tt.value) && tt.value != nil {
+			t.Errorf("round trip of %#v gave %#v", tt.value, got)
+		}
+	}
+}
+
+func TestConversionsShareSingletons(t *testing.T) {
+	// Converted values share the booleans and null of the VM
+	for value, expected := range map[any]object.Object{true: object.TRUE, false: object.FALSE, nil: object.NULL} {
+		obj, err := ToObject(value)
+		if err != nil || obj != expected {
+			t.Errorf("ToObject(%v) is not the VM value. got=%p (%v), want=%p", value, obj, err, expected)
+		}
+	}
+	var missing *point
+	if obj, _ := ToObject(missing); obj != object.NULL {
+		t.Errorf("ToObject of a nil pointer is not the VM null. got=%p", obj)
+	}
+}
+
+func TestFromObjectToAny(t *testing.T) {
+	obj, err := ToObject(map[string]any{"list": []any{1, 2.5, "x", nil}})
+	if err != nil {
+		t.Fatalf("ToObject returned an error: %s", err)
+	}
+
+	var got any
+	err = FromObject(obj, &got)
+	if err != nil {
+		t.Fatalf("FromObject returned an error: %s", err)
+	}
+	expected := map[any]any{"list": []any{int64(1), 2.5, "x", nil}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong value. want=%#v, got=%#v", expected, got)
+	}
+}
+
+func TestTupleConversions(t *testing.T) {
+	tuple := &object.Tuple{Elements: []object.Object{
+		&object.Integer{Value: 1}, &object.Integer{Value: 2}, &object.String{Value: "a"},
+	}}
+
+	var slice []any
+	var array [3]any
+	var pt point
+	tests := []struct {
+		target   any
+		expected any
+	}{
+		{&slice, []any{int64(1), int64(2), "a"}},
+		{&array, [3]any{int64(1), int64(2), "a"}},
+		{&pt, point{X: 1, Y: 2, Label: "a"}},
+	}
+	for _, tt := range tests {
+		err := FromObject(tuple, tt.target)
+		if err != nil {
+			t.Errorf("FromObject to %T returned an error: %s", tt.target, err)
+			continue
+		}
+		if got := reflect.ValueOf(tt.target).Elem().Interface(); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("wrong value for %T. want=%#v, got=%#v", tt.target, tt.expected, got)
+		}
+	}
+
+	// In an any, a tuple is a slice, but an array as a key
+	hash := object.NewHash(1)
+	err := hash.Set(&object.Tuple{Elements: []object.Object{&object.Integer{Value: 1}, &object.String{Value: "b"}}}, tuple)
+	if err != nil {
+		t.Fatalf("Set returned an error: %s", err)
+	}
+	var got any
+	err = FromObject(hash, &got)
+	if err != nil {
+		t.Fatalf("FromObject returned an error: %s", err)
+	}
+	expected := map[any]any{[2]any{int64(1), "b"}: []any{int64(1), int64(2), "a"}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong value. want=%#v, got=%#v", expected, got)
+	}
+}
+
+func TestConversionErrors(t *testing.T) {
+	var small int8
+	var unsigned uint
+	var number int
+	var fixed [3]int
+	var pt point
+
+	tests := []struct {
+		obj      object.Object
+		target   any
+		expected string
+	}{
+		{&object.Integer{Value: 300}, &small, "300 overflows int8"},
+		{&object.Integer{Value: -1}, &unsigned, "-1 overflows uint"},
+		{&object.String{Value: "1"}, &number, "cannot convert STRING to int"},
+		{&object.Null{}, &number, "cannot convert NULL to int"},
+		{&object.Array{}, &fixed, "cannot convert an array of 0 elements to [3]int"},
+		{&object.Tuple{}, &fixed, "cannot convert a tuple of 0 elements to [3]int"},
+		{&object.Tuple{}, &pt, "cannot convert a tuple of 0 elements to host.point"},
+		{&object.Tuple{Elements: []object.Object{&object.String{Value: "1"}, &object.Integer{Value: 2}, &object.String{Value: ""}}}, &pt, "field X: cannot convert STRING to int64"},
+		{mustObject(t, map[string]any{"X": "one"}), &pt, "field X: cannot convert STRING to int64"},
+		{&object.Integer{Value: 1}, number, "target must be a non-nil pointer, got int"},
+	}
+
+	for _, tt := range tests {
+		err := FromObject(tt.obj, tt.target)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error for %s. want=%q, got=%v", tt.obj.Inspect(), tt.expected, err)
+		}
+	}
+
+	_, err := ToObject(map[string]func(){"f": nil})
+	if err == nil || err.Error() != "cannot convert func() to a Gold value" {
+		t.Errorf("wrong error for a func. got=%v", err)
+	}
+	_, err = ToObject(uint64(1) << 63)
+	if err == nil || err.Error() != "9223372036854775808 overflows a Gold integer" {
+		t.Errorf("wrong error for a large uint64. got=%v", err)
+	}
+}
+
+func mustObject(t *testing.T, v any) object.Object {
+	t.Helper()
+	obj, err := ToObject(v)
+	if err != nil {
+		t.Fatalf("ToObject(%#v) returned an error: %s", v, err)
+	}
+	return obj
+}
@@ -2,6 +2,7 @@ package lexer
 
 import (
 	"gold/token"
+	"reflect"
 	"testing"
 )
 
@@ -199,3 +200,130 @@ any
 		}
 	}
 }
+
+func TestTokenPosition(t *testing.T) {
+	input := `let x = 5;
+  x + "ab"
+`
+	tests := []struct {
+		expectedType   token.TokenType
+		expectedLine   int
+		expectedColumn int
+	}{
+		{token.LET, 1, 1},
+		{token.IDENT, 1, 5},
+		{token.ASSIGN, 1, 7},
+		{token.INT, 1, 9},
+		{token.SEMICOLON, 1, 10},
+		{token.IDENT, 2, 3},
+		{token.PLUS, 2, 5},
+		{token.STRING, 2, 7},
+		{token.EOF, 3, 1},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Line != tt.expectedLine || tok.Column != tt.expectedColumn {
+			t.Fatalf("tests[%d] - position wrong. expected=%d:%d, got=%d:%d",
+				i, tt.expectedLine, tt.expectedColumn, tok.Line, tok.Column)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// first
+let x = 5; // trailing
+x / 2 //last`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.LET, "let"},
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.INT, "5"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.SLASH, "/"},
+		{token.INT, "2"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q",
+				i, tt.expectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q",
+				i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+
+	expectedComments := []token.Token{
+		{Type: token.COMMENT, Literal: "// first", Line: 1, Column: 1},
+		{Type: token.COMMENT, Literal: "// trailing", Line: 2, Column: 12},
+		{Type: token.COMMENT, Literal: "//last", Line: 3, Column: 7},
+	}
+
+	comments := l.Comments()
+	if len(comments) != len(expectedComments) {
+		t.Fatalf("wrong number of comments. want=%d, got=%d", len(expectedComments), len(comments))
+	}
+	for i, want := range expectedComments {
+		if comments[i] != want {
+			t.Errorf("comments[%d] wrong. want=%+v, got=%+v", i, want, comments[i])
+		}
+	}
+}
+
+func TestCommentBoundaries(t *testing.T) {
+	tests := []struct {
+		input            string
+		expectedTokens   []token.TokenType
+		expectedComments []string
+	}{
+		// A comment ends at the end of its line
+		{"1 // one\n2", []token.TokenType{token.INT, token.INT}, []string{"// one"}},
+		{"1 //\n//\n2 // two", []token.TokenType{token.INT, token.INT}, []string{"//", "//", "// two"}},
+		// Slashes in a string are part of it
+		{`"http://gold" // url`, []token.TokenType{token.STRING}, []string{"// url"}},
+		{`"//" + "a"`, []token.TokenType{token.STRING, token.PLUS, token.STRING}, nil},
+		// A single slash is a division
+		{"4 / 2 /1", []token.TokenType{token.INT, token.SLASH, token.INT, token.SLASH, token.INT}, nil},
+		{"// only", nil, []string{"// only"}},
+	}
+
+	for _, tt := range tests {
+		l := New(tt.input)
+		var types []token.TokenType
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+			types = append(types, tok.Type)
+		}
+		var comments []string
+		for _, comment := range l.Comments() {
+			comments = append(comments, comment.Literal)
+		}
+
+		if !reflect.DeepEqual(types, tt.expectedTokens) {
+			t.Errorf("wrong tokens for %q. want=%v, got=%v", tt.input, tt.expectedTokens, types)
+		}
+		if !reflect.DeepEqual(comments, tt.expectedComments) {
+			t.Errorf("wrong comments for %q. want=%q, got=%q", tt.input, tt.expectedComments, comments)
+		}
+	}
+}
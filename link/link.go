@@ -0,0 +1,199 @@
+// Package link joins the units of a program, compiled separately, into a
+// single bytecode that the VM runs.
+//
+// Each unit numbers its constants, globals and builtins on its own. The
+// linker appends the constants of every unit to the pool of the program,
+// gives its own slots to the globals of every unit and the slot of the
+// exporting unit to its externals, and maps the builtins by name. The main
+// instructions of the units run one after the other, the imported modules
+// before the modules importing them.
+package link
+
+import (
+	"fmt"
+	"gold/code"
+	"gold/cold"
+	"gold/compiler"
+	"gold/object"
+)
+
+// Link links units with the default builtins. The first unit is the main
+// module, the others are the modules it imports, directly or not.
+func Link(units ...*compiler.Unit) (*compiler.Bytecode, *cold.Debug, error) {
+	return LinkWithBuiltins(object.Builtins, units...)
+}
+
+// LinkWithBuiltins links units for a VM running with builtins, see
+// compiler.NewWithBuiltins.
+func LinkWithBuiltins(builtins []object.BuiltinDefinition, units ...*compiler.Unit) (*compiler.Bytecode, *cold.Debug, error) {
+	if len(units) == 0 {
+		return nil, nil, fmt.Errorf("no unit to link")
+	}
+
+	byName := map[string]*compiler.Unit{}
+	for _, unit := range units {
+		if _, ok := byName[unit.Name]; ok {
+			return nil, nil, fmt.Errorf("module %s is given twice", unit.Name)
+		}
+		byName[unit.Name] = unit
+	}
+
+	order, err := sortUnits(units[0], byName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(order) != len(units) {
+		linked := map[string]bool{}
+		for _, unit := range order {
+			linked[unit.Name] = true
+		}
+		for _, unit := range units {
+			if !linked[unit.Name] {
+				return nil, nil, fmt.Errorf("module %s is not imported by %s", unit.Name, units[0].Name)
+			}
+		}
+	}
+
+	builtinIndexes := map[string]int{}
+	for i, builtin := range builtins {
+		builtinIndexes[builtin.Name] = i
+	}
+
+	l := &linker{builtins: builtinIndexes, linked: map[string]linked{}, debug: &cold.Debug{}}
+	var main []code.Instruction
+	for _, unit := range order {
+		instructions, err := l.add(unit)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", unit.Name, err)
+		}
+		main = append(main, shift(instructions, len(main))...)
+	}
+
+	return &compiler.Bytecode{Instructions: encode(main), Constants: l.constants}, l.debug, nil
+}
+
+// sortUnits returns the units in the order they run: every module after the
+// ones it imports, in the order of the imports.
+func sortUnits(main *compiler.Unit, byName map[string]*compiler.Unit) ([]*compiler.Unit, error) {
+	var order []*compiler.Unit
+	state := map[string]int{} // 1 while visiting the imports, 2 once added
+	var visit func(unit *compiler.Unit, path []string) error
+	visit = func(unit *compiler.Unit, path []string) error {
+		path = append(path, unit.Name)
+		for _, dependency := range unit.Imports {
+			imported, ok := byName[dependency.Name]
+			if !ok {
+				return fmt.Errorf("module %s imported by %s is missing", dependency.Name, unit.Name)
+			}
+			if imported.ExportsHash() != dependency.ExportsHash {
+				return fmt.Errorf("%s was compiled with another version of %s, compile it again", unit.Name, dependency.Name)
+			}
+			switch state[dependency.Name] {
+			case 1:
+				return fmt.Errorf("import cycle: %s", cycle(path, dependency.Name))
+			case 0:
+				state[dependency.Name] = 1
+				err := visit(imported, path)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		state[unit.Name] = 2
+		order = append(order, unit)
+		return nil
+	}
+
+	state[main.Name] = 1
+	return order, visit(main, nil)
+}
+
+func cycle(path []string, name string) string {
+	out := name
+	for i := len(path) - 1; i >= 0; i-- {
+		out = path[i] + " -> " + out
+		if path[i] == name {
+			break
+		}
+	}
+	return out
+}
+
+type linker struct {
+	constants  []object.Object
+	numGlobals int
+	linked     map[string]linked
+	builtins   map[string]int
+	debug      *cold.Debug
+}
+
+// linked is a unit already linked, with the slot in the program of each of
+// its globals.
+type linked struct {
+	slots   []int
+	exports []compiler.Export
+}
+
+// add appends the constants and the globals of unit to the program and
+// returns its main instructions, relocated, with the jump targets as
+// instruction indexes.
+func (l *linker) add(unit *compiler.Unit) ([]code.Instruction, error) {
+	slots := make([]int, len(unit.Globals))
+	for i := range slots {
+		slots[i] = -1
+	}
+	for _, external := range unit.Externals {
+		if external.Index >= len(slots) {
+			return nil, fmt.Errorf("external %s is out of range", external.Name)
+		}
+		slot, ok := l.linked[external.Module].slot(external.Name)
+		if !ok {
+			return nil, fmt.Errorf("%s doesn't export %s", external.Module, external.Name)
+		}
+		slots[external.Index] = slot
+	}
+	for i, name := range unit.Globals {
+		if slots[i] == -1 {
+			slots[i] = l.numGlobals
+			l.numGlobals++
+			l.debug.Globals = append(l.debug.Globals, name)
+		}
+	}
+	l.linked[unit.Name] = linked{slots: slots, exports: unit.Exports}
+
+	builtins := make([]int, len(unit.Builtins))
+	for i, name := range unit.Builtins {
+		index, ok := l.builtins[name]
+		if !ok {
+			index = -1
+		}
+		builtins[i] = index
+	}
+
+	r := &relocation{constants: len(l.constants), globals: slots, builtins: builtins, builtinNames: unit.Builtins}
+	for i, constant := range unit.Bytecode.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			l.constants = append(l.constants, constant)
+			continue
+		}
+		instructions, err := r.relocate(fn.Instructions)
+		if err != nil {
+			return nil, fmt.Errorf("function of constant %d: %w", i, err)
+		}
+		relocated := *fn
+		relocated.Instructions = encode(instructions)
+		l.constants = append(l.constants, &relocated)
+	}
+	return r.relocate(unit.Bytecode.Instructions)
+}
+
+// slot returns the slot of the global exported as name.
+func (u linked) slot(name string) (int, bool) {
+	for _, export := range u.exports {
+		if export.Name == name && export.Index < len(u.slots) {
+			return u.slots[export.Index], true
+		}
+	}
+	return 0, false
+}
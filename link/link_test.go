@@ -0,0 +1,139 @@
+package link
+
+import (
+	"fmt"
+	"gold/code"
+	"gold/compiler"
+	"gold/lexer"
+	"gold/object"
+	"gold/parser"
+	"gold/verifier"
+	"gold/vm"
+	"path"
+	"strings"
+	"testing"
+)
+
+// units compiles the modules of sources to units, in the order given: a
+// module must come after the ones it imports.
+type units map[string]*compiler.Unit
+
+func (u units) ResolveUnit(from, p string) (*compiler.Unit, error) {
+	name := path.Join(path.Dir(from), p) + ".gold"
+	unit, ok := u[name]
+	if !ok {
+		return nil, fmt.Errorf("module %s is not compiled", name)
+	}
+	return unit, nil
+}
+
+func compileUnits(t *testing.T, level int, sources ...string) []*compiler.Unit {
+	t.Helper()
+
+	compiled := units{}
+	var list []*compiler.Unit
+	for i := 0; i < len(sources); i += 2 {
+		name, source := sources[i], sources[i+1]
+		comp := compiler.New()
+		comp.SetUnitResolver(compiled, name)
+		comp.SetOptimization(level)
+		_, err := comp.Compile(parser.New(lexer.New(source)).ParseProgram())
+		if err != nil {
+			t.Fatalf("compiler error in %s: %s", name, err)
+		}
+		compiled[name] = comp.Unit()
+		list = append(list, compiled[name])
+	}
+	// The main module comes first
+	return append(list[len(list)-1:], list[:len(list)-1]...)
+}
+
+func run(t *testing.T, bytecode *compiler.Bytecode) object.Object {
+	t.Helper()
+
+	err := verifier.Verify(bytecode)
+	if err != nil {
+		t.Fatalf("linked program is invalid: %s", err)
+	}
+	machine := vm.New(bytecode)
+	err = machine.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	return machine.LastPoppedStackElem()
+}
+
+func TestLink(t *testing.T) {
+	sources := []string{
+		"lib/math.gold", `let _scale = 10; let scaled = fn(lint x) { return x * _scale }; lint loaded = 0; loaded++`,
+		"lib/twice.gold", `import "math"; let twice = fn(lint x) { return scaled(scaled(x)) }; let label = "twice"`,
+		"main.gold", `import "lib/twice"; import "lib/math"; let total = twice(1) + scaled(2); [total, loaded, len(label)]`,
+	}
+
+	levels := []int{compiler.OptimizationNone, compiler.OptimizationConstants, compiler.OptimizationPeephole}
+	for _, level := range levels {
+		bytecode, debug, err := Link(compileUnits(t, level, sources...)...)
+		if err != nil {
+			t.Fatalf("link error: %s", err)
+		}
+		if got := run(t, bytecode).Inspect(); got != "[120, 1, 5]" {
+			t.Errorf("wrong result at optimization level %d. want=[120, 1, 5], got=%s", level, got)
+		}
+		if !strings.Contains(strings.Join(debug.Globals, " "), "total") {
+			t.Errorf("debug globals don't name total: %q", debug.Globals)
+		}
+	}
+}
+
+func TestLinkErrors(t *testing.T) {
+	lib := compileUnits(t, 0, "lib.gold", "let value = 1")[0]
+	main := compileUnits(t, 0, "lib.gold", "let value = 1", "main.gold", `import "lib"; value`)[0]
+	changed := compileUnits(t, 0, "lib.gold", "let value = \"one\"")[0]
+	other := compileUnits(t, 0, "other.gold", "1")[0]
+
+	tests := []struct {
+		units    []*compiler.Unit
+		expected string
+	}{
+		{nil, "no unit to link"},
+		{[]*compiler.Unit{main}, "module lib.gold imported by main.gold is missing"},
+		{[]*compiler.Unit{main, lib, lib}, "module lib.gold is given twice"},
+		{[]*compiler.Unit{main, lib, other}, "module other.gold is not imported by main.gold"},
+		{[]*compiler.Unit{main, changed}, "main.gold was compiled with another version of lib.gold, compile it again"},
+	}
+
+	for _, tt := range tests {
+		_, _, err := Link(tt.units...)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error. want=%q, got=%v", tt.expected, err)
+		}
+	}
+
+	// A unit compiled with a builtin the linked program doesn't have
+	_, _, err := LinkWithBuiltins(object.Builtins[:1], compileUnits(t, 0, "main.gold", "print(1)")...)
+	if err == nil || err.Error() != "main.gold: builtin print is not available" {
+		t.Errorf("wrong error for a missing builtin, got=%v", err)
+	}
+}
+
+func TestEncodeWideJumps(t *testing.T) {
+	// A jump over 70000 instructions needs a wide operand, which moves the
+	// instructions after it.
+	instructions := []code.Instruction{{Op: code.OpJump, Operands: []int{70001}}}
+	for i := 0; i < 70000; i++ {
+		instructions = append(instructions, code.Instruction{Op: code.OpNull})
+	}
+	instructions = append(instructions, code.Instruction{Op: code.OpJump, Operands: []int{0}})
+
+	decoded, err := code.Decode(encode(instructions))
+	if err != nil {
+		t.Fatalf("invalid instructions: %s", err)
+	}
+	first, last := decoded[0], decoded[len(decoded)-1]
+	if !first.Wide || first.Operands[0] != first.Width+70000 {
+		t.Errorf("wrong first jump: %+v", first)
+	}
+	if last.Wide || last.Operands[0] != 0 {
+		t.Errorf("wrong last jump: %+v", last)
+	}
+}
@@ -0,0 +1,120 @@
+package link
+
+import (
+	"fmt"
+	"gold/code"
+)
+
+// relocation maps the constants, globals and builtins of a unit to the ones
+// of the program.
+type relocation struct {
+	constants int
+	globals   []int
+	// builtins is -1 for the builtins the program doesn't have, whose
+	// names are in builtinNames.
+	builtins     []int
+	builtinNames []string
+}
+
+// relocate decodes instructions and relocates their operands. The jump
+// targets become instruction indexes, since relocated instructions may need
+// another width.
+func (r *relocation) relocate(ins code.Instructions) ([]code.Instruction, error) {
+	decoded, err := code.Decode(ins)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := make(map[int]int, len(decoded)+1)
+	offset := 0
+	for i, instruction := range decoded {
+		indexes[offset] = i
+		offset += instruction.Width
+	}
+	indexes[offset] = len(decoded)
+
+	for i := range decoded {
+		instruction := &decoded[i]
+		operands := instruction.Operands
+		switch {
+		case code.IsJump(instruction.Op):
+			target, ok := indexes[operands[0]]
+			if !ok {
+				return nil, fmt.Errorf("jump to %d is not on an instruction", operands[0])
+			}
+			operands[0] = target
+
+		case instruction.Op == code.OpConstant || instruction.Op == code.OpClosure:
+			operands[0] += r.constants
+
+		case instruction.Op == code.OpGetGlobal || instruction.Op == code.OpSetGlobal ||
+			instruction.Op == code.OpIncGlobal || instruction.Op == code.OpDecGlobal:
+			if operands[0] >= len(r.globals) {
+				return nil, fmt.Errorf("global %d is out of range", operands[0])
+			}
+			operands[0] = r.globals[operands[0]]
+
+		case instruction.Op == code.OpGetBuiltin:
+			if operands[0] >= len(r.builtins) {
+				return nil, fmt.Errorf("builtin %d is out of range", operands[0])
+			}
+			if r.builtins[operands[0]] == -1 {
+				return nil, fmt.Errorf("builtin %s is not available", r.builtinNames[operands[0]])
+			}
+			operands[0] = r.builtins[operands[0]]
+		}
+	}
+	return decoded, nil
+}
+
+// shift moves the jump targets of instructions placed after base others.
+func shift(instructions []code.Instruction, base int) []code.Instruction {
+	for i := range instructions {
+		if code.IsJump(instructions[i].Op) {
+			instructions[i].Operands[0] += base
+		}
+	}
+	return instructions
+}
+
+// encode writes instructions whose jump targets are instruction indexes.
+// An instruction is wide when its operands don't fit otherwise: a jump
+// becoming wide moves the instructions after it, so the widths are computed
+// again until no other jump needs to become wide.
+func encode(instructions []code.Instruction) code.Instructions {
+	wide := make([]bool, len(instructions))
+	for i, instruction := range instructions {
+		wide[i] = !code.IsJump(instruction.Op) && !code.Fits(instruction.Op, instruction.Operands...)
+	}
+
+	offsets := make([]int, len(instructions)+1)
+	for changed := true; changed; {
+		for i, instruction := range instructions {
+			offsets[i+1] = offsets[i] + len(makeInstruction(instruction.Op, wide[i], nil))
+		}
+		changed = false
+		for i, instruction := range instructions {
+			if code.IsJump(instruction.Op) && !wide[i] && !code.Fits(instruction.Op, offsets[instruction.Operands[0]]) {
+				wide[i] = true
+				changed = true
+			}
+		}
+	}
+
+	var out code.Instructions
+	for i, instruction := range instructions {
+		operands := instruction.Operands
+		if code.IsJump(instruction.Op) {
+			operands = []int{offsets[operands[0]]}
+		}
+		out = append(out, makeInstruction(instruction.Op, wide[i], operands)...)
+	}
+	return out
+}
+
+func makeInstruction(op code.Opcode, wide bool, operands []int) []byte {
+	if wide {
+		return code.MakeWide(op, operands...)
+	}
+	return code.Make(op, operands...)
+}
@@ -0,0 +1,293 @@
+package lsp
+
+import (
+	"errors"
+	"fmt"
+	"gold/ast"
+	"gold/compiler"
+	"gold/lexer"
+	"gold/object"
+	"gold/parser"
+	"gold/token"
+	"net/url"
+	"strings"
+	"unicode/utf16"
+)
+
+// document is an open file with the result of its last analysis.
+type document struct {
+	uri   string
+	lines []string
+
+	program     *ast.Program
+	references  []compiler.Reference
+	diagnostics []Diagnostic
+}
+
+func newDocument(uri, text string) *document {
+	d := &document{
+		uri:         uri,
+		lines:       strings.Split(text, "\n"),
+		diagnostics: []Diagnostic{},
+	}
+
+	l := lexer.New(text)
+	p := parser.New(l)
+	d.program = p.ParseProgram()
+
+	if errs := p.ParseErrors(); len(errs) != 0 {
+		for _, e := range errs {
+			d.addDiagnostic(e.Token, e.Message)
+		}
+		return d
+	}
+
+	comp := compiler.New()
+	if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+		comp.SetResolver(compiler.FileResolver{}, u.Path)
+	}
+	_, err := comp.Compile(d.program)
+	d.references = comp.References()
+	if err != nil {
+		var compileErr *compiler.CompileError
+		if errors.As(err, &compileErr) {
+			d.addDiagnostic(compileErr.Token, compileErr.Error())
+		} else {
+			d.addDiagnostic(token.Token{Line: 1, Column: 1}, err.Error())
+		}
+	}
+
+	return d
+}
+
+func (d *document) addDiagnostic(tok token.Token, msg string) {
+	d.diagnostics = append(d.diagnostics, Diagnostic{
+		Range:    d.tokenRange(tok),
+		Severity: SeverityError,
+		Source:   "gold",
+		Message:  msg,
+	})
+}
+
+// referenceAt returns the reference whose identifier covers pos.
+func (d *document) referenceAt(pos Position) (compiler.Reference, bool) {
+	for _, ref := range d.references {
+		r := d.tokenRange(ref.Token)
+		if r.Start.Line == pos.Line && r.Start.Character <= pos.Character && pos.Character <= r.End.Character {
+			return ref, true
+		}
+	}
+	return compiler.Reference{}, false
+}
+
+func (d *document) hover(pos Position) *Hover {
+	ref, ok := d.referenceAt(pos)
+	if !ok {
+		return nil
+	}
+
+	return &Hover{
+		Contents: MarkupContent{Kind: "plaintext", Value: describeSymbol(ref.Symbol)},
+		Range:    d.tokenRange(ref.Token),
+	}
+}
+
+func (d *document) definition(pos Position) *Location {
+	ref, ok := d.referenceAt(pos)
+	if !ok || ref.Definition.Line == 0 {
+		return nil
+	}
+
+	return &Location{URI: d.uri, Range: d.tokenRange(ref.Definition)}
+}
+
+// completion proposes keywords, builtins and the names defined before pos.
+func (d *document) completion(pos Position) []CompletionItem {
+	items := []CompletionItem{}
+
+	for _, keyword := range token.Keywords() {
+		items = append(items, CompletionItem{Label: keyword, Kind: CompletionKindKeyword})
+	}
+
+	for _, def := range object.Builtins {
+		items = append(items, CompletionItem{
+			Label:  def.Name,
+			Kind:   CompletionKindFunction,
+			Detail: describeAttribute(def.Type),
+		})
+	}
+
+	functions := d.functionRanges()
+	seen := map[string]bool{}
+	for _, ref := range d.references {
+		if ref.Token != ref.Definition || seen[ref.Symbol.Name] {
+			continue
+		}
+		start := d.tokenRange(ref.Token).Start
+		if !before(start, pos) {
+			continue
+		}
+		// A local is only in scope inside the function that defines it
+		if ref.Symbol.Scope != compiler.GlobalScope {
+			enclosing, ok := innermost(functions, start)
+			if !ok || !contains(enclosing, pos) {
+				continue
+			}
+		}
+
+		seen[ref.Symbol.Name] = true
+		kind := CompletionKindVariable
+		if ref.Symbol.ObjectInfo.IsFunction {
+			kind = CompletionKindFunction
+		}
+		items = append(items, CompletionItem{
+			Label:  ref.Symbol.Name,
+			Kind:   kind,
+			Detail: describeAttribute(ref.Symbol.ObjectInfo),
+		})
+	}
+
+	return items
+}
+
+// functionRanges returns the extent of every function literal, from the `fn`
+// keyword to the closing brace.
+func (d *document) functionRanges() []Range {
+	ranges := []Range{}
+	ast.Inspect(d.program, func(n ast.Node) bool {
+		if fn, ok := n.(*ast.FunctionLiteral); ok && fn.Body != nil {
+			ranges = append(ranges, Range{
+				Start: d.tokenRange(fn.Token).Start,
+				End:   d.tokenRange(fn.Body.Rbrace).End,
+			})
+		}
+		return true
+	})
+	return ranges
+}
+
+func innermost(ranges []Range, pos Position) (Range, bool) {
+	var found Range
+	ok := false
+	for _, r := range ranges {
+		if contains(r, pos) && (!ok || contains(found, r.Start)) {
+			found = r
+			ok = true
+		}
+	}
+	return found, ok
+}
+
+func contains(r Range, pos Position) bool {
+	return !before(pos, r.Start) && before(pos, r.End)
+}
+
+func before(a, b Position) bool {
+	return a.Line < b.Line || (a.Line == b.Line && a.Character < b.Character)
+}
+
+func (d *document) symbols() []DocumentSymbol {
+	return d.declarations(d.program.Statements)
+}
+
+func (d *document) declarations(statements []ast.Statement) []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+
+	for _, s := range statements {
+		declare, ok := s.(*ast.Declare)
+		if !ok || declare == nil || declare.Name == nil {
+			continue
+		}
+
+		nameRange := d.tokenRange(declare.Name.Token)
+		symbol := DocumentSymbol{
+			Name:           declare.Name.Value,
+			Detail:         declare.Token.Literal,
+			Kind:           SymbolKindVariable,
+			Range:          Range{Start: d.tokenRange(declare.Token).Start, End: nameRange.End},
+			SelectionRange: nameRange,
+		}
+
+		if fn, ok := declare.Value.(*ast.FunctionLiteral); ok && fn.Body != nil {
+			symbol.Kind = SymbolKindFunction
+			symbol.Children = d.declarations(fn.Body.Statements)
+		}
+
+		symbols = append(symbols, symbol)
+	}
+
+	return symbols
+}
+
+// tokenRange converts the 1-based byte position of a token to a protocol
+// range, where characters are counted in UTF-16 code units.
+func (d *document) tokenRange(tok token.Token) Range {
+	line := tok.Line - 1
+	if line < 0 {
+		line = 0
+	}
+
+	width := len(tok.Literal)
+	if tok.Type == token.STRING {
+		// The literal of a string doesn't hold its quotes
+		width += 2
+	}
+	start := d.character(line, tok.Column-1)
+	end := d.character(line, tok.Column-1+width)
+
+	return Range{
+		Start: Position{Line: line, Character: start},
+		End:   Position{Line: line, Character: end},
+	}
+}
+
+func (d *document) character(line, byteOffset int) int {
+	if line >= len(d.lines) || byteOffset <= 0 {
+		return 0
+	}
+
+	text := d.lines[line]
+	if byteOffset > len(text) {
+		byteOffset = len(text)
+	}
+
+	return len(utf16.Encode([]rune(text[:byteOffset])))
+}
+
+func describeSymbol(s compiler.Symbol) string {
+	return fmt.Sprintf("%s %s: %s", strings.ToLower(string(s.Scope)), s.Name, describeAttribute(s.ObjectInfo))
+}
+
+// describeAttribute renders an attribute the way a user would read it, for
+// instance `fn(INTEGER, STRING?) INTEGER` for a function.
+func describeAttribute(a object.Attribute) string {
+	if !a.IsFunction {
+		return describeType(a.ObjectType, a.Nullable)
+	}
+
+	args := make([]string, len(a.ArgsObjectType))
+	for i, t := range a.ArgsObjectType {
+		nullable := i < len(a.ArgsNullable) && a.ArgsNullable[i]
+		args[i] = describeType(t, nullable)
+	}
+	if a.Variadic && len(args) > 0 {
+		args[len(args)-1] = "..." + args[len(args)-1]
+	}
+
+	result := describeType(a.ObjectType, a.Nullable)
+	if a.FunctionAttribute != nil && a.FunctionAttribute.IsFunction {
+		result = describeAttribute(*a.FunctionAttribute)
+	}
+
+	return fmt.Sprintf("fn(%s) %s", strings.Join(args, ", "), result)
+}
+
+func describeType(t object.ObjectType, nullable bool) string {
+	if t == "" {
+		t = object.ANY
+	}
+	if nullable && t != object.NULL_OBJ {
+		return string(t) + "?"
+	}
+	return string(t)
+}
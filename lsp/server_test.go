@@ -0,0 +1,235 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"testing"
+)
+
+const testURI = "file:///test.gold"
+
+const testSource = `let x = 1;
+let add = fn(mint a, mint b) {
+  let c = a + b;
+  return c;
+};
+add(x, 2);`
+
+func TestServer(t *testing.T) {
+	requests := []string{
+		request(1, "initialize", map[string]any{}),
+		notification("initialized", map[string]any{}),
+		notification("textDocument/didOpen", map[string]any{
+			"textDocument": map[string]any{"uri": testURI, "text": testSource},
+		}),
+		request(2, "textDocument/hover", positionParams(5, 1)),
+		request(3, "textDocument/definition", positionParams(5, 4)),
+		request(4, "textDocument/completion", positionParams(5, 0)),
+		request(9, "textDocument/completion", positionParams(3, 2)),
+		request(5, "textDocument/documentSymbol", map[string]any{
+			"textDocument": map[string]any{"uri": testURI},
+		}),
+		request(6, "textDocument/hover", positionParams(0, 2)),
+		request(7, "unknown/method", map[string]any{}),
+		request(8, "shutdown", nil),
+		notification("exit", nil),
+	}
+
+	var out bytes.Buffer
+	in := bytes.NewBufferString(joinMessages(requests))
+	err := NewServer(in, &out).Serve()
+	if err != nil {
+		t.Fatalf("Serve returned an error: %s", err)
+	}
+
+	responses := readMessages(t, &out)
+
+	diagnostics := responses["textDocument/publishDiagnostics"]
+	if string(diagnostics) != `{"uri":"file:///test.gold","diagnostics":[]}` {
+		t.Errorf("wrong diagnostics. got=%s", diagnostics)
+	}
+
+	var hover Hover
+	decode(t, responses["2"], &hover)
+	if hover.Contents.Value != "global add: fn(INTEGER?, INTEGER?) INTEGER" {
+		t.Errorf("wrong hover content. got=%q", hover.Contents.Value)
+	}
+	if hover.Range.Start != (Position{Line: 5, Character: 0}) {
+		t.Errorf("wrong hover range. got=%+v", hover.Range)
+	}
+
+	var location Location
+	decode(t, responses["3"], &location)
+	want := Range{Start: Position{Line: 0, Character: 4}, End: Position{Line: 0, Character: 5}}
+	if location.URI != testURI || location.Range != want {
+		t.Errorf("wrong definition. got=%+v", location)
+	}
+
+	var items []CompletionItem
+	decode(t, responses["4"], &items)
+	labels := map[string]bool{}
+	for _, item := range items {
+		labels[item.Label] = true
+	}
+	for _, label := range []string{"let", "while", "len", "push", "x", "add"} {
+		if !labels[label] {
+			t.Errorf("completion is missing %q", label)
+		}
+	}
+	if labels["c"] || labels["a"] {
+		t.Errorf("completion proposes a local outside of its function")
+	}
+
+	decode(t, responses["9"], &items)
+	labels = map[string]bool{}
+	for _, item := range items {
+		labels[item.Label] = true
+	}
+	for _, label := range []string{"a", "b", "c", "add", "x"} {
+		if !labels[label] {
+			t.Errorf("completion inside the function is missing %q", label)
+		}
+	}
+
+	var symbols []DocumentSymbol
+	decode(t, responses["5"], &symbols)
+	if len(symbols) != 2 || symbols[0].Name != "x" || symbols[1].Name != "add" {
+		t.Fatalf("wrong symbols. got=%+v", symbols)
+	}
+	if symbols[1].Kind != SymbolKindFunction || len(symbols[1].Children) != 1 || symbols[1].Children[0].Name != "c" {
+		t.Errorf("wrong function symbol. got=%+v", symbols[1])
+	}
+
+	if string(responses["6"]) != "null" {
+		t.Errorf("hover on a keyword should be null. got=%s", responses["6"])
+	}
+
+	if string(responses["7"]) != fmt.Sprintf(`{"code":%d,"message":"method not found: unknown/method"}`, codeMethodNotFound) {
+		t.Errorf("wrong error for unknown method. got=%s", responses["7"])
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected Diagnostic
+	}{
+		{
+			"let x = 1;\nlet 5 = 2;",
+			Diagnostic{
+				Range:    Range{Start: Position{Line: 1, Character: 4}, End: Position{Line: 1, Character: 5}},
+				Severity: SeverityError,
+				Source:   "gold",
+				Message:  "expected next token to be IDENT, got INT instead",
+			},
+		},
+		{
+			"let x = 1;\n  lstr y = x;",
+			Diagnostic{
+				Range:    Range{Start: Position{Line: 1, Character: 2}, End: Position{Line: 1, Character: 6}},
+				Severity: SeverityError,
+				Source:   "gold",
+				Message:  "wrong type used : 'y' expect type 'STRING' but got 'INTEGER'",
+			},
+		},
+		{
+			`let x = 1;` + "\n" + `x; "héllo" + 1;`,
+			Diagnostic{
+				Range:    Range{Start: Position{Line: 1, Character: 3}, End: Position{Line: 1, Character: 10}},
+				Severity: SeverityError,
+				Source:   "gold",
+				Message:  "trying to do '+' with other than numbers or string. left=STRING right=INTEGER",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		doc := newDocument(testURI, tt.input)
+		if len(doc.diagnostics) == 0 {
+			t.Fatalf("no diagnostic for %q", tt.input)
+		}
+		if doc.diagnostics[0] != tt.expected {
+			t.Errorf("wrong diagnostic. want=%+v, got=%+v", tt.expected, doc.diagnostics[0])
+		}
+	}
+}
+
+func TestExitWithoutShutdown(t *testing.T) {
+	in := bytes.NewBufferString(joinMessages([]string{notification("exit", nil)}))
+	err := NewServer(in, io.Discard).Serve()
+	if err != ErrExitWithoutShutdown {
+		t.Errorf("wrong error. want=%v, got=%v", ErrExitWithoutShutdown, err)
+	}
+}
+
+func positionParams(line, character int) map[string]any {
+	return map[string]any{
+		"textDocument": map[string]any{"uri": testURI},
+		"position":     map[string]any{"line": line, "character": character},
+	}
+}
+
+func request(id int, method string, params any) string {
+	body, _ := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": id, "method": method, "params": params})
+	return string(body)
+}
+
+func notification(method string, params any) string {
+	body, _ := json.Marshal(map[string]any{"jsonrpc": "2.0", "method": method, "params": params})
+	return string(body)
+}
+
+func joinMessages(bodies []string) string {
+	var out bytes.Buffer
+	for _, body := range bodies {
+		fmt.Fprintf(&out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	}
+	return out.String()
+}
+
+// readMessages indexes responses by id and notifications by method.
+func readMessages(t *testing.T, r io.Reader) map[string]json.RawMessage {
+	t.Helper()
+
+	messages := map[string]json.RawMessage{}
+	reader := bufio.NewReader(r)
+	for {
+		headers, err := textproto.NewReader(reader).ReadMIMEHeader()
+		if err == io.EOF {
+			return messages
+		}
+		if err != nil {
+			t.Fatalf("could not read headers: %s", err)
+		}
+
+		length, _ := strconv.Atoi(headers.Get("Content-Length"))
+		body := make([]byte, length)
+		io.ReadFull(reader, body)
+
+		var msg message
+		decode(t, body, &msg)
+		switch {
+		case msg.Error != nil:
+			errBody, _ := json.Marshal(msg.Error)
+			messages[string(*msg.ID)] = errBody
+		case msg.ID != nil:
+			messages[string(*msg.ID)] = msg.Result
+		default:
+			messages[msg.Method] = msg.Params
+		}
+	}
+}
+
+func decode(t *testing.T, data []byte, v any) {
+	t.Helper()
+
+	err := json.Unmarshal(data, v)
+	if err != nil {
+		t.Fatalf("could not decode %s: %s", data, err)
+	}
+}
@@ -2,11 +2,19 @@ package object
 
 import "fmt"
 
-var Builtins = []struct {
+// BuiltinDefinition is a function implemented in Go and callable from Gold.
+// Type is the signature the compiler checks calls against: the ObjectType
+// and Nullable of the result, and the types of the arguments.
+type BuiltinDefinition struct {
 	Name    string
 	Builtin *Builtin
 	Type    Attribute
-}{
+}
+
+// Builtins are available to every program, in this order. The compiler
+// refers to a builtin by its index, so hosts add their own functions after
+// these.
+var Builtins = []BuiltinDefinition{
 	{
 		"len",
 		&Builtin{
@@ -19,6 +27,8 @@ var Builtins = []struct {
 				switch arg := args[0].(type) {
 				case *Array:
 					return &Integer{Value: int64(len(arg.Elements))}
+				case *Tuple:
+					return &Integer{Value: int64(len(arg.Elements))}
 				case *String:
 					return &Integer{Value: int64(len(arg.Value))}
 				default:
@@ -27,20 +37,12 @@ var Builtins = []struct {
 				}
 			},
 		},
-		Attribute{ObjectType: INTEGER_OBJ, Nullable: false, ArgsNullable: []bool{false}, ArgsObjectType: []ObjectType{ANY}},
+		Attribute{ObjectType: INTEGER_OBJ, Nullable: false, ArgsNullable: []bool{false}, ArgsObjectType: []ObjectType{ANY}, IsFunction: true},
 	},
 	{
 		"print",
-		&Builtin{
-			Fn: func(args ...Object) Object {
-				for _, arg := range args {
-					fmt.Println(arg.Inspect())
-				}
-
-				return nil
-			},
-		},
-		Attribute{ObjectType: NULL_OBJ, Nullable: true, ArgsNullable: []bool{true}, ArgsObjectType: []ObjectType{ANY}},
+		&Builtin{RuntimeFn: printValues},
+		Attribute{ObjectType: NULL_OBJ, Nullable: true, ArgsNullable: []bool{true}, ArgsObjectType: []ObjectType{ANY}, IsFunction: true},
 	},
 	{
 		"first",
@@ -63,7 +65,7 @@ var Builtins = []struct {
 				return nil
 			},
 		},
-		Attribute{ObjectType: ANY, Nullable: true, ArgsNullable: []bool{false}, ArgsObjectType: []ObjectType{ARRAY_OBJ}},
+		Attribute{ObjectType: ANY, Nullable: true, ArgsNullable: []bool{false}, ArgsObjectType: []ObjectType{ARRAY_OBJ}, IsFunction: true},
 	},
 	{
 		"last",
@@ -87,7 +89,7 @@ var Builtins = []struct {
 				return nil
 			},
 		},
-		Attribute{ObjectType: ANY, Nullable: true, ArgsNullable: []bool{false}, ArgsObjectType: []ObjectType{ARRAY_OBJ}},
+		Attribute{ObjectType: ANY, Nullable: true, ArgsNullable: []bool{false}, ArgsObjectType: []ObjectType{ARRAY_OBJ}, IsFunction: true},
 	},
 	{
 		"push",
@@ -112,7 +114,148 @@ var Builtins = []struct {
 				return &Array{Elements: newElements}
 			},
 		},
-		Attribute{ObjectType: ARRAY_OBJ, Nullable: false, ArgsNullable: []bool{false, false}, ArgsObjectType: []ObjectType{ARRAY_OBJ, ANY}},
+		Attribute{ObjectType: ARRAY_OBJ, Nullable: false, ArgsNullable: []bool{false, false}, ArgsObjectType: []ObjectType{ARRAY_OBJ, ANY}, IsFunction: true},
+	},
+	{
+		"map",
+		&Builtin{RuntimeFn: mapArray},
+		Attribute{
+			ObjectType: ARRAY_OBJ, Nullable: false, IsFunction: true,
+			ArgsNullable: []bool{false, false}, ArgsObjectType: []ObjectType{ARRAY_OBJ, ANY},
+			ArgsFunction: []*Attribute{nil, callback(ANY, ANY)},
+		},
+	},
+	{
+		"filter",
+		&Builtin{RuntimeFn: filterArray},
+		Attribute{
+			ObjectType: ARRAY_OBJ, Nullable: false, IsFunction: true,
+			ArgsNullable: []bool{false, false}, ArgsObjectType: []ObjectType{ARRAY_OBJ, ANY},
+			ArgsFunction: []*Attribute{nil, callback(BOOLEAN_OBJ, ANY)},
+		},
+	},
+	{
+		"reduce",
+		&Builtin{RuntimeFn: reduceArray},
+		Attribute{
+			ObjectType: ANY, Nullable: false, IsFunction: true,
+			ArgsNullable: []bool{false, false, false}, ArgsObjectType: []ObjectType{ARRAY_OBJ, ANY, ANY},
+			ArgsFunction: []*Attribute{nil, callback(ANY, ANY, ANY), nil},
+		},
+	},
+	{
+		"sort",
+		&Builtin{RuntimeFn: sortArray},
+		Attribute{
+			ObjectType: ARRAY_OBJ, Nullable: false, IsFunction: true,
+			ArgsNullable: []bool{false, false}, ArgsObjectType: []ObjectType{ARRAY_OBJ, ANY},
+			ArgsFunction: []*Attribute{nil, callback(BOOLEAN_OBJ, ANY, ANY)},
+		},
+	},
+	{"split", split, signature(ARRAY_OBJ, STRING_OBJ, STRING_OBJ)},
+	{"join", &Builtin{RuntimeFn: join}, signature(STRING_OBJ, ARRAY_OBJ, STRING_OBJ)},
+	{"trim", trim, signature(STRING_OBJ, STRING_OBJ)},
+	{"upper", upper, signature(STRING_OBJ, STRING_OBJ)},
+	{"lower", lower, signature(STRING_OBJ, STRING_OBJ)},
+	{"contains", contains, signature(BOOLEAN_OBJ, STRING_OBJ, STRING_OBJ)},
+	{"index_of", indexOf, signature(INTEGER_OBJ, STRING_OBJ, STRING_OBJ)},
+	{"replace", &Builtin{RuntimeFn: replace}, signature(STRING_OBJ, STRING_OBJ, STRING_OBJ, STRING_OBJ)},
+	{"starts_with", startsWith, signature(BOOLEAN_OBJ, STRING_OBJ, STRING_OBJ)},
+	{"ends_with", endsWith, signature(BOOLEAN_OBJ, STRING_OBJ, STRING_OBJ)},
+	{"repeat", &Builtin{RuntimeFn: repeat}, signature(STRING_OBJ, STRING_OBJ, INTEGER_OBJ)},
+	{
+		"format",
+		&Builtin{Fn: format},
+		Attribute{
+			ObjectType: STRING_OBJ, Nullable: false, IsFunction: true, Variadic: true,
+			ArgsNullable: []bool{false, true}, ArgsObjectType: []ObjectType{STRING_OBJ, ANY},
+		},
+	},
+	{
+		"to_str",
+		&Builtin{Fn: toStr},
+		Attribute{ObjectType: STRING_OBJ, Nullable: false, IsFunction: true, ArgsNullable: []bool{true}, ArgsObjectType: []ObjectType{ANY}},
+	},
+	{"parse_int", parseInt, nullableSignature(INTEGER_OBJ, STRING_OBJ)},
+	{"parse_float", parseFloat, nullableSignature(FLOAT_OBJ, STRING_OBJ)},
+	{"abs", &Builtin{Fn: abs}, numberSignature(1, false)},
+	{"min", minimum, numberSignature(2, true)},
+	{"max", maximum, numberSignature(2, true)},
+	{"floor", floor, numberSignature(1, false)},
+	{"ceil", ceil, numberSignature(1, false)},
+	{"round", round, numberSignature(1, false)},
+	{"pow", &Builtin{Fn: pow}, numberSignature(2, false)},
+	{"sqrt", sqrt, floatSignature(1)},
+	{"sin", sin, floatSignature(1)},
+	{"cos", cos, floatSignature(1)},
+	{"tan", tan, floatSignature(1)},
+	{"log", log, floatSignature(1)},
+	{"exp", exp, floatSignature(1)},
+	{"pi", pi, floatSignature(0)},
+	{"int", &Builtin{Fn: toInt}, signature(INTEGER_OBJ, NUMBER)},
+	{"float", &Builtin{Fn: toFloat}, signature(FLOAT_OBJ, NUMBER)},
+	{"args", &Builtin{RuntimeFn: programArgs}, signature(ARRAY_OBJ)},
+	{"env", &Builtin{RuntimeFn: getenv}, nullableSignature(STRING_OBJ, STRING_OBJ)},
+	{"exit", &Builtin{RuntimeFn: exit}, nullableSignature(NULL_OBJ, INTEGER_OBJ)},
+	{"read_file", readFile, nullableSignature(STRING_OBJ, STRING_OBJ)},
+	{"read_lines", readLines, nullableSignature(ARRAY_OBJ, STRING_OBJ)},
+	{"write_file", writeFile, nullableSignature(BOOLEAN_OBJ, STRING_OBJ, STRING_OBJ)},
+	{"append_file", appendFile, nullableSignature(BOOLEAN_OBJ, STRING_OBJ, STRING_OBJ)},
+	{"list_dir", listDir, nullableSignature(ARRAY_OBJ, STRING_OBJ)},
+	{"exists", exists, nullableSignature(BOOLEAN_OBJ, STRING_OBJ)},
+	{"last_error", &Builtin{RuntimeFn: lastError}, nullableSignature(STRING_OBJ)},
+	{
+		"eprint",
+		&Builtin{RuntimeFn: eprint},
+		Attribute{ObjectType: NULL_OBJ, Nullable: true, ArgsNullable: []bool{true}, ArgsObjectType: []ObjectType{ANY}, IsFunction: true},
+	},
+	{
+		"input",
+		&Builtin{RuntimeFn: input},
+		Attribute{
+			ObjectType: STRING_OBJ, Nullable: true, IsFunction: true, Variadic: true,
+			ArgsNullable: []bool{false}, ArgsObjectType: []ObjectType{STRING_OBJ},
+		},
+	},
+	{"read_line", &Builtin{RuntimeFn: readLine}, nullableSignature(STRING_OBJ)},
+	{
+		"printf",
+		&Builtin{RuntimeFn: printf},
+		Attribute{
+			ObjectType: NULL_OBJ, Nullable: true, IsFunction: true, Variadic: true,
+			ArgsNullable: []bool{false, true}, ArgsObjectType: []ObjectType{STRING_OBJ, ANY},
+		},
+	},
+	{
+		"sprintf",
+		&Builtin{RuntimeFn: sprintf},
+		Attribute{
+			ObjectType: STRING_OBJ, Nullable: false, IsFunction: true, Variadic: true,
+			ArgsNullable: []bool{false, true}, ArgsObjectType: []ObjectType{STRING_OBJ, ANY},
+		},
+	},
+	{
+		"json_parse",
+		&Builtin{RuntimeFn: jsonParse},
+		Attribute{ObjectType: ANY, Nullable: true, IsFunction: true, ArgsNullable: []bool{true}, ArgsObjectType: []ObjectType{STRING_OBJ}},
+	},
+	{
+		"json_stringify",
+		&Builtin{RuntimeFn: jsonStringify},
+		Attribute{
+			ObjectType: STRING_OBJ, Nullable: true, IsFunction: true,
+			ArgsNullable: []bool{true, false}, ArgsObjectType: []ObjectType{ANY, INTEGER_OBJ},
+		},
+	},
+	{
+		"tuple",
+		&Builtin{Fn: func(args ...Object) Object {
+			return &Tuple{Elements: append([]Object{}, args...)}
+		}},
+		Attribute{
+			ObjectType: TUPLE_OBJ, Nullable: false, IsFunction: true, Variadic: true,
+			ArgsNullable: []bool{true}, ArgsObjectType: []ObjectType{ANY},
+		},
 	},
 }
 
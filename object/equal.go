@@ -0,0 +1,119 @@
+package object
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+)
+
+// Equal tells if two values are equal, as == does. Numbers are equal when
+// they have the same value, an integer and a float included, and strings
+// when they have the same content. Arrays and tuples are equal when their
+// elements are, and hashes when they have equal keys with equal values,
+// whatever their order. Any other value, a function, is only equal to
+// itself, and NaN to nothing.
+func Equal(a, b Object) bool {
+	switch a := a.(type) {
+	case *Integer:
+		switch b := b.(type) {
+		case *Integer:
+			return a.Value == b.Value
+		case *Float:
+			return floatEqualsInteger(b.Value, a.Value)
+		}
+		return false
+	case *Float:
+		switch b := b.(type) {
+		case *Float:
+			return a.Value == b.Value
+		case *Integer:
+			return floatEqualsInteger(a.Value, b.Value)
+		}
+		return false
+	case *String:
+		b, ok := b.(*String)
+		return ok && a.Value == b.Value
+	case *Boolean:
+		b, ok := b.(*Boolean)
+		return ok && a.Value == b.Value
+	case *Null:
+		_, ok := b.(*Null)
+		return ok
+	case *Array:
+		b, ok := b.(*Array)
+		return ok && elementsEqual(a.Elements, b.Elements)
+	case *Tuple:
+		b, ok := b.(*Tuple)
+		return ok && elementsEqual(a.Elements, b.Elements)
+	case *Hash:
+		b, ok := b.(*Hash)
+		if !ok || a.Len() != b.Len() {
+			return false
+		}
+		for _, pair := range a.Pairs() {
+			value, ok, _ := b.Get(pair.Key)
+			if !ok || !Equal(pair.Value, value) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+func elementsEqual(a, b []Object) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// floatEqualsInteger compares f and i exactly, where converting i to a
+// float could round it.
+func floatEqualsInteger(f float64, i int64) bool {
+	n, ok := floatToInteger(f)
+	return ok && n == i
+}
+
+// floatToInteger returns the integer of the same value as f, false when f
+// has a fraction or is out of the range of the integers.
+func floatToInteger(f float64) (int64, bool) {
+	if f < -(1<<63) || f >= 1<<63 || math.IsNaN(f) {
+		return 0, false
+	}
+	n := int64(f)
+	return n, float64(n) == f
+}
+
+// HashKeyOf returns the key of a value in a hash. Numbers, strings,
+// booleans and the tuples of such values are usable as keys; arrays and
+// hashes are not, as they would be lost in a hash once changed. Equal values
+// have the same key, values that are not equal may have it too.
+func HashKeyOf(obj Object) (HashKey, error) {
+	if tuple, ok := obj.(*Tuple); ok {
+		h := fnv.New64a()
+		var value [8]byte
+		for _, element := range tuple.Elements {
+			key, err := HashKeyOf(element)
+			if err != nil {
+				return HashKey{}, fmt.Errorf("%w in %s", err, TUPLE_OBJ)
+			}
+			h.Write([]byte(key.Type))
+			binary.LittleEndian.PutUint64(value[:], key.Value)
+			h.Write(value[:])
+		}
+		return HashKey{Type: TUPLE_OBJ, Value: h.Sum64()}, nil
+	}
+
+	hashable, ok := obj.(Hashable)
+	if !ok {
+		return HashKey{}, fmt.Errorf("unusable as hash key: %s", obj.Type())
+	}
+	return hashable.HashKey(), nil
+}
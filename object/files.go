@@ -0,0 +1,203 @@
+package object
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FilePolicy restricts the files the file builtins may access. A program
+// run without a policy can't access any file.
+type FilePolicy struct {
+	// Roots are the directories the program may access, with everything
+	// under them. Without roots, every file may be accessed.
+	Roots []string
+	// ReadOnly rejects the writes.
+	ReadOnly bool
+}
+
+// Files are the files a program accesses within its policy. Every run has
+// its own.
+type Files struct {
+	policy *FilePolicy
+	roots  []string
+}
+
+// NewFiles gives access to the files allowed by policy, none when it is nil.
+func NewFiles(policy *FilePolicy) *Files {
+	files := &Files{policy: policy}
+	if policy != nil {
+		for _, root := range policy.Roots {
+			resolved, err := resolvePath(root)
+			if err != nil {
+				// No file is under a root that can't be resolved
+				continue
+			}
+			files.roots = append(files.roots, resolved)
+		}
+	}
+	return files
+}
+
+// resolvePath makes name absolute and follows its symbolic links, so a link
+// can't leave the roots. For a file that doesn't exist yet, the links of its
+// directory are followed. A link that can't be followed is rejected, as a
+// write through it would create its target, wherever it is.
+func resolvePath(name string) (string, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return filepath.Clean(name), nil
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		return resolved, nil
+	}
+	if info, err := os.Lstat(abs); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return "", fmt.Errorf("%s: broken symbolic link", name)
+	}
+	if dir, err := filepath.EvalSymlinks(filepath.Dir(abs)); err == nil {
+		return filepath.Join(dir, filepath.Base(abs)), nil
+	}
+	return abs, nil
+}
+
+// check returns the path to access for name, or why it can't be accessed.
+func (f *Files) check(name string, write bool) (string, error) {
+	if f.policy == nil {
+		return "", errors.New("file access is disabled")
+	}
+	if write && f.policy.ReadOnly {
+		return "", fmt.Errorf("%s: file access is read-only", name)
+	}
+	resolved, err := resolvePath(name)
+	if err != nil {
+		return "", err
+	}
+	if len(f.roots) == 0 {
+		return resolved, nil
+	}
+	for _, root := range f.roots {
+		rel, err := filepath.Rel(root, resolved)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return resolved, nil
+		}
+	}
+	return "", fmt.Errorf("%s: outside of the allowed directories", name)
+}
+
+// fileBuiltin makes a builtin of a function on the files of the runtime,
+// whose first argument is a path. The other arguments are strings.
+func fileBuiltin(name string, write bool, fn func(rt Runtime, path string, args []string) (Object, error)) *Builtin {
+	return &Builtin{
+		RuntimeFn: func(rt Runtime, args ...Object) (Object, error) {
+			values, err := stringArgs(name, args...)
+			if err != nil {
+				return err, nil
+			}
+			path, checkErr := rt.Files().check(values[0], write)
+			if checkErr != nil {
+				return fail(rt, checkErr)
+			}
+			return fn(rt, path, values[1:])
+		},
+	}
+}
+
+// readLimited reads the file at path and returns the result of build on
+// its content. The content is checked against the allocation limit of rt as
+// it is read, since the size of some files is unknown until then: going over
+// it stops the program, while failing to read the file gives null.
+func readLimited(rt Runtime, path string, build func(data []byte) Object) (Object, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return fail(rt, err)
+	}
+	defer file.Close()
+
+	var data bytes.Buffer
+	chunk := make([]byte, 32*1024)
+	for {
+		n, err := file.Read(chunk)
+		data.Write(chunk[:n])
+		if allocErr := rt.Allocate(data.Len()); allocErr != nil {
+			return nil, allocErr
+		}
+		if errors.Is(err, io.EOF) {
+			return build(data.Bytes()), nil
+		}
+		if err != nil {
+			return fail(rt, err)
+		}
+	}
+}
+
+var (
+	readFile = fileBuiltin("read_file", false, func(rt Runtime, path string, args []string) (Object, error) {
+		return readLimited(rt, path, func(data []byte) Object {
+			return &String{Value: string(data)}
+		})
+	})
+	readLines = fileBuiltin("read_lines", false, func(rt Runtime, path string, args []string) (Object, error) {
+		return readLimited(rt, path, func(data []byte) Object {
+			text := strings.TrimSuffix(string(data), "\n")
+			elements := []Object{}
+			if len(data) > 0 {
+				for _, line := range strings.Split(text, "\n") {
+					elements = append(elements, &String{Value: strings.TrimSuffix(line, "\r")})
+				}
+			}
+			return &Array{Elements: elements}
+		})
+	})
+	writeFile = fileBuiltin("write_file", true, func(rt Runtime, path string, args []string) (Object, error) {
+		err := os.WriteFile(path, []byte(args[0]), 0644)
+		if err != nil {
+			return fail(rt, err)
+		}
+		return TRUE, nil
+	})
+	appendFile = fileBuiltin("append_file", true, func(rt Runtime, path string, args []string) (Object, error) {
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fail(rt, err)
+		}
+		_, err = file.WriteString(args[0])
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fail(rt, err)
+		}
+		return TRUE, nil
+	})
+	listDir = fileBuiltin("list_dir", false, func(rt Runtime, path string, args []string) (Object, error) {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return fail(rt, err)
+		}
+		names := make([]string, len(entries))
+		for i, entry := range entries {
+			names[i] = entry.Name()
+		}
+		sort.Strings(names)
+		elements := make([]Object, len(names))
+		for i, name := range names {
+			elements[i] = &String{Value: name}
+		}
+		return &Array{Elements: elements}, nil
+	})
+	exists = fileBuiltin("exists", false, func(rt Runtime, path string, args []string) (Object, error) {
+		_, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return FALSE, nil
+		}
+		if err != nil {
+			return fail(rt, err)
+		}
+		return TRUE, nil
+	})
+)
@@ -0,0 +1,116 @@
+package object
+
+import (
+	"fmt"
+	"sort"
+)
+
+// callback is the signature of a function argument of a builtin: it takes
+// non-null values of the args types and returns a non-null result.
+func callback(result ObjectType, args ...ObjectType) *Attribute {
+	return &Attribute{
+		ObjectType:     result,
+		ArgsObjectType: args,
+		ArgsNullable:   make([]bool, len(args)),
+		IsFunction:     true,
+	}
+}
+
+// mapArray returns the array of the results of the function on every
+// element: map([1, 2], fn(lint x) { return x * 2 }) is [2, 4].
+func mapArray(rt Runtime, args ...Object) (Object, error) {
+	arr, ok := args[0].(*Array)
+	if !ok {
+		return newError("argument to `map` must be ARRAY, got %s", args[0].Type()), nil
+	}
+
+	elements := make([]Object, len(arr.Elements))
+	for i, element := range arr.Elements {
+		result, err := rt.Call(args[1], element)
+		if err != nil {
+			return nil, err
+		}
+		elements[i] = result
+	}
+	return &Array{Elements: elements}, nil
+}
+
+// filterArray returns the elements for which the function is true.
+func filterArray(rt Runtime, args ...Object) (Object, error) {
+	arr, ok := args[0].(*Array)
+	if !ok {
+		return newError("argument to `filter` must be ARRAY, got %s", args[0].Type()), nil
+	}
+
+	elements := []Object{}
+	for _, element := range arr.Elements {
+		keep, err := predicate(rt, "filter", args[1], element)
+		if err != nil {
+			return nil, err
+		}
+		if keep {
+			elements = append(elements, element)
+		}
+	}
+	return &Array{Elements: elements}, nil
+}
+
+// reduceArray folds the elements from the first one: the function is
+// called with the result so far, starting with the third argument, and the
+// element.
+func reduceArray(rt Runtime, args ...Object) (Object, error) {
+	arr, ok := args[0].(*Array)
+	if !ok {
+		return newError("argument to `reduce` must be ARRAY, got %s", args[0].Type()), nil
+	}
+
+	accumulator := args[2]
+	for _, element := range arr.Elements {
+		result, err := rt.Call(args[1], accumulator, element)
+		if err != nil {
+			return nil, err
+		}
+		accumulator = result
+	}
+	return accumulator, nil
+}
+
+// sortArray returns the elements sorted by the function, which tells if its
+// first argument goes before the second one. Equal elements keep their
+// order.
+func sortArray(rt Runtime, args ...Object) (Object, error) {
+	arr, ok := args[0].(*Array)
+	if !ok {
+		return newError("argument to `sort` must be ARRAY, got %s", args[0].Type()), nil
+	}
+
+	elements := make([]Object, len(arr.Elements))
+	copy(elements, arr.Elements)
+
+	var err error
+	sort.SliceStable(elements, func(i, j int) bool {
+		if err != nil {
+			return false
+		}
+		var less bool
+		less, err = predicate(rt, "sort", args[1], elements[i], elements[j])
+		return less
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Array{Elements: elements}, nil
+}
+
+// predicate calls a function returning a boolean.
+func predicate(rt Runtime, name string, fn Object, args ...Object) (bool, error) {
+	result, err := rt.Call(fn, args...)
+	if err != nil {
+		return false, err
+	}
+	boolean, ok := result.(*Boolean)
+	if !ok {
+		return false, fmt.Errorf("function given to `%s` must return BOOLEAN, got %s", name, result.Type())
+	}
+	return boolean.Value, nil
+}
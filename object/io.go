@@ -0,0 +1,117 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// writeValues writes every value on its own line.
+func writeValues(w io.Writer, args []Object) error {
+	for _, arg := range args {
+		_, err := fmt.Fprintln(w, arg.Inspect())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printValues writes its arguments to the standard output of the runtime.
+func printValues(rt Runtime, args ...Object) (Object, error) {
+	return nil, writeValues(rt.Stdout(), args)
+}
+
+// eprint is print on the standard error.
+func eprint(rt Runtime, args ...Object) (Object, error) {
+	return nil, writeValues(rt.Stderr(), args)
+}
+
+// readLine returns the next line of the standard input, without its line
+// ending, or null at the end of the input.
+func readLine(rt Runtime, args ...Object) (Object, error) {
+	line, err := rt.Stdin().ReadString('\n')
+	if errors.Is(err, io.EOF) {
+		if line == "" {
+			return nil, nil
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\n")
+	return &String{Value: strings.TrimSuffix(line, "\r")}, nil
+}
+
+// input writes its arguments, a prompt, to the standard output and reads a
+// line like read_line.
+func input(rt Runtime, args ...Object) (Object, error) {
+	values, err := stringArgs("input", args...)
+	if err != nil {
+		return err, nil
+	}
+	_, writeErr := io.WriteString(rt.Stdout(), strings.Join(values, ""))
+	if writeErr != nil {
+		return nil, writeErr
+	}
+	return readLine(rt)
+}
+
+// formatEscapes are the escapes of the formats of sprintf, since string
+// literals have none.
+var formatEscapes = strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\t`, "\t")
+
+// sprintf formats its arguments with the verbs of the Go fmt package:
+// sprintf("%5.2f|%-4d|%q", 3.14159, 42, "go") is " 3.14|42  |\"go\"".
+// Numbers, strings and booleans are given as their Go value, the other
+// values as the string printed for them. In the format, \n is a line
+// ending, \t a tab and \\ a backslash.
+func sprintf(rt Runtime, args ...Object) (Object, error) {
+	if len(args) == 0 {
+		return newError("wrong number of arguments. got=0, want=at least 1"), nil
+	}
+	format, ok := args[0].(*String)
+	if !ok {
+		return newError("argument to `sprintf` must be STRING, got %s", args[0].Type()), nil
+	}
+	template := formatEscapes.Replace(format.Value)
+	values := make([]any, len(args)-1)
+	for i, arg := range args[1:] {
+		switch arg := arg.(type) {
+		case *Integer:
+			values[i] = arg.Value
+		case *Float:
+			values[i] = arg.Value
+		case *String:
+			values[i] = arg.Value
+		case *Boolean:
+			values[i] = arg.Value
+		default:
+			values[i] = arg.Inspect()
+		}
+	}
+
+	// fmt pads a verb to a million bytes at most, so the result is checked
+	// once formatted
+	var out strings.Builder
+	fmt.Fprintf(&out, template, values...)
+	if err := rt.Allocate(out.Len()); err != nil {
+		return nil, err
+	}
+	return &String{Value: out.String()}, nil
+}
+
+// printf writes the result of sprintf to the standard output, without
+// adding a line ending.
+func printf(rt Runtime, args ...Object) (Object, error) {
+	formatted, err := sprintf(rt, args...)
+	if err != nil {
+		return nil, err
+	}
+	str, ok := formatted.(*String)
+	if !ok {
+		return formatted, nil
+	}
+	_, err = io.WriteString(rt.Stdout(), str.Value)
+	return nil, err
+}
@@ -0,0 +1,245 @@
+package object
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxJSONDepth bounds the nesting of the arrays and objects of a JSON
+// document, parsed or written.
+const maxJSONDepth = 512
+
+// jsonParse returns the value of a JSON document: objects become hashes with
+// string keys, arrays become arrays, and numbers become integers when they
+// have neither a fraction nor an exponent and fit in 64 bits, floats
+// otherwise. It returns null when the document is invalid, or null itself,
+// so that json_parse(read_file(path)) is null when either fails.
+func jsonParse(rt Runtime, args ...Object) (Object, error) {
+	if _, ok := args[0].(*Null); ok {
+		return nil, nil
+	}
+	str, ok := args[0].(*String)
+	if !ok {
+		return newError("argument to `json_parse` must be STRING, got %s", args[0].Type()), nil
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(str.Value))
+	decoder.UseNumber()
+	value, err := parseJSONValue(decoder, 0)
+	if err == nil {
+		_, err = decoder.Token()
+		if err == io.EOF {
+			return value, nil
+		}
+		if err == nil {
+			err = errors.New("unexpected data after the value")
+		}
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fail(rt, fmt.Errorf("json_parse: %w", err))
+}
+
+func parseJSONValue(decoder *json.Decoder, depth int) (Object, error) {
+	if depth > maxJSONDepth {
+		return nil, errors.New("the document is nested too deeply")
+	}
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token := token.(type) {
+	case json.Delim:
+		if token == '[' {
+			elements := []Object{}
+			for decoder.More() {
+				element, err := parseJSONValue(decoder, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				elements = append(elements, element)
+			}
+			_, err := decoder.Token()
+			return &Array{Elements: elements}, err
+		}
+
+		hash := &Hash{}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := parseJSONValue(decoder, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			hash.Set(&String{Value: key.(string)}, value)
+		}
+		_, err := decoder.Token()
+		return hash, err
+	case json.Number:
+		if !strings.ContainsAny(string(token), ".eE") {
+			if n, err := strconv.ParseInt(string(token), 10, 64); err == nil {
+				return &Integer{Value: n}, nil
+			}
+		}
+		f, err := strconv.ParseFloat(string(token), 64)
+		if err != nil {
+			return nil, fmt.Errorf("number %s is out of range", token)
+		}
+		return &Float{Value: f}, nil
+	case string:
+		return &String{Value: token}, nil
+	case bool:
+		return NativeBoolToBooleanObject(token), nil
+	}
+	return NULL, nil
+}
+
+// jsonStringify returns the JSON document of a value, indented by the
+// number of spaces of its second argument, on a single line when it is 0.
+// The keys of the hashes are written in insertion order, integer and boolean
+// keys as strings, and tuples are written as arrays. It returns null for the
+// values JSON can't represent: functions, and floats that are not finite.
+func jsonStringify(rt Runtime, args ...Object) (Object, error) {
+	indent, ok := args[1].(*Integer)
+	if !ok {
+		return newError("second argument to `json_stringify` must be INTEGER, got %s", args[1].Type()), nil
+	}
+	if indent.Value < 0 || indent.Value > 16 {
+		return newError("indent must be between 0 and 16, got %d", indent.Value), nil
+	}
+
+	w := &jsonWriter{indent: strings.Repeat(" ", int(indent.Value))}
+	err := w.value(args[0], 0)
+	if err != nil {
+		return fail(rt, fmt.Errorf("json_stringify: %w", err))
+	}
+	return &String{Value: w.out.String()}, nil
+}
+
+type jsonWriter struct {
+	out    bytes.Buffer
+	indent string
+}
+
+func (w *jsonWriter) value(obj Object, depth int) error {
+	if depth > maxJSONDepth {
+		return errors.New("the value is nested too deeply")
+	}
+
+	switch obj := obj.(type) {
+	case *Null:
+		w.out.WriteString("null")
+	case *Boolean:
+		w.out.WriteString(strconv.FormatBool(obj.Value))
+	case *Integer:
+		w.out.WriteString(strconv.FormatInt(obj.Value, 10))
+	case *Float:
+		if math.IsNaN(obj.Value) || math.IsInf(obj.Value, 0) {
+			return fmt.Errorf("%s can't be represented in JSON", obj.Inspect())
+		}
+		f := strconv.FormatFloat(obj.Value, 'g', -1, 64)
+		if !strings.ContainsAny(f, ".e") {
+			// Keep the value a float when it is parsed again
+			f += ".0"
+		}
+		w.out.WriteString(f)
+	case *String:
+		w.string(obj.Value)
+	case *Array:
+		return w.array(obj.Elements, depth)
+	case *Tuple:
+		return w.array(obj.Elements, depth)
+	case *Hash:
+		return w.hash(obj, depth)
+	default:
+		return fmt.Errorf("%s can't be represented in JSON", obj.Type())
+	}
+	return nil
+}
+
+func (w *jsonWriter) array(elements []Object, depth int) error {
+	if len(elements) == 0 {
+		w.out.WriteString("[]")
+		return nil
+	}
+	w.out.WriteByte('[')
+	for i, element := range elements {
+		if i > 0 {
+			w.out.WriteByte(',')
+		}
+		w.newline(depth + 1)
+		err := w.value(element, depth+1)
+		if err != nil {
+			return err
+		}
+	}
+	w.newline(depth)
+	w.out.WriteByte(']')
+	return nil
+}
+
+func (w *jsonWriter) hash(hash *Hash, depth int) error {
+	if hash.Len() == 0 {
+		w.out.WriteString("{}")
+		return nil
+	}
+
+	w.out.WriteByte('{')
+	for i, pair := range hash.Pairs() {
+		var key string
+		switch k := pair.Key.(type) {
+		case *String:
+			key = k.Value
+		case *Integer, *Boolean:
+			key = k.Inspect()
+		default:
+			return fmt.Errorf("%s keys can't be represented in JSON", pair.Key.Type())
+		}
+		if i > 0 {
+			w.out.WriteByte(',')
+		}
+		w.newline(depth + 1)
+		w.string(key)
+		w.out.WriteByte(':')
+		if w.indent != "" {
+			w.out.WriteByte(' ')
+		}
+		err := w.value(pair.Value, depth+1)
+		if err != nil {
+			return err
+		}
+	}
+	w.newline(depth)
+	w.out.WriteByte('}')
+	return nil
+}
+
+// newline starts a line indented for depth, when the output is indented.
+func (w *jsonWriter) newline(depth int) {
+	if w.indent == "" {
+		return
+	}
+	w.out.WriteByte('\n')
+	for i := 0; i < depth; i++ {
+		w.out.WriteString(w.indent)
+	}
+}
+
+// string writes s quoted, without the HTML escapes of json.Marshal.
+func (w *jsonWriter) string(s string) {
+	var quoted bytes.Buffer
+	encoder := json.NewEncoder(&quoted)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(s)
+	w.out.Write(bytes.TrimSuffix(quoted.Bytes(), []byte("\n")))
+}
@@ -0,0 +1,187 @@
+package object
+
+import (
+	"math"
+)
+
+// The math builtins follow the arithmetic of the VM. Integers wrap around
+// on overflow, as with + and *: abs of the smallest integer is itself and
+// pow(2, 64) is 0. Floats follow IEEE 754, so sqrt(-1) is NaN and log(0) is
+// -Inf, and converting NaN or an infinite float to an integer is an error.
+
+// numberSignature is the type of a builtin taking numbers and returning an
+// integer when they are all integers, a float otherwise.
+func numberSignature(args int, variadic bool) Attribute {
+	attribute := signature(INTEGER_OBJ)
+	for i := 0; i < args; i++ {
+		attribute.ArgsObjectType = append(attribute.ArgsObjectType, NUMBER)
+		attribute.ArgsNullable = append(attribute.ArgsNullable, false)
+	}
+	attribute.Promote = true
+	attribute.Variadic = variadic
+	return attribute
+}
+
+// floatSignature is the type of a builtin taking numbers and returning a
+// float.
+func floatSignature(args int) Attribute {
+	attribute := signature(FLOAT_OBJ)
+	for i := 0; i < args; i++ {
+		attribute.ArgsObjectType = append(attribute.ArgsObjectType, NUMBER)
+		attribute.ArgsNullable = append(attribute.ArgsNullable, false)
+	}
+	return attribute
+}
+
+// numbers returns the values of the number arguments of a builtin as floats,
+// and whether they are all integers.
+func numbers(name string, args ...Object) ([]float64, bool, *Error) {
+	values := make([]float64, len(args))
+	allIntegers := true
+	for i, arg := range args {
+		switch arg := arg.(type) {
+		case *Integer:
+			values[i] = float64(arg.Value)
+		case *Float:
+			values[i] = arg.Value
+			allIntegers = false
+		default:
+			return nil, false, newError("argument to `%s` must be INTEGER or FLOAT, got %s", name, arg.Type())
+		}
+	}
+	return values, allIntegers, nil
+}
+
+// floatFunction makes a builtin of a Go function on floats.
+func floatFunction(name string, fn func(args []float64) float64) *Builtin {
+	return &Builtin{
+		Fn: func(args ...Object) Object {
+			values, _, err := numbers(name, args...)
+			if err != nil {
+				return err
+			}
+			return &Float{Value: fn(values)}
+		},
+	}
+}
+
+// roundingFunction makes a builtin that rounds floats and leaves integers
+// as they are.
+func roundingFunction(name string, fn func(float64) float64) *Builtin {
+	return &Builtin{
+		Fn: func(args ...Object) Object {
+			switch arg := args[0].(type) {
+			case *Integer:
+				return arg
+			case *Float:
+				return &Float{Value: fn(arg.Value)}
+			}
+			return newError("argument to `%s` must be INTEGER or FLOAT, got %s", name, args[0].Type())
+		},
+	}
+}
+
+var (
+	sqrt = floatFunction("sqrt", func(args []float64) float64 { return math.Sqrt(args[0]) })
+	sin  = floatFunction("sin", func(args []float64) float64 { return math.Sin(args[0]) })
+	cos  = floatFunction("cos", func(args []float64) float64 { return math.Cos(args[0]) })
+	tan  = floatFunction("tan", func(args []float64) float64 { return math.Tan(args[0]) })
+	log  = floatFunction("log", func(args []float64) float64 { return math.Log(args[0]) })
+	exp  = floatFunction("exp", func(args []float64) float64 { return math.Exp(args[0]) })
+	pi   = floatFunction("pi", func(args []float64) float64 { return math.Pi })
+
+	floor = roundingFunction("floor", math.Floor)
+	ceil  = roundingFunction("ceil", math.Ceil)
+	round = roundingFunction("round", math.Round)
+)
+
+func abs(args ...Object) Object {
+	switch arg := args[0].(type) {
+	case *Integer:
+		if arg.Value < 0 {
+			return &Integer{Value: -arg.Value}
+		}
+		return arg
+	case *Float:
+		return &Float{Value: math.Abs(arg.Value)}
+	}
+	return newError("argument to `abs` must be INTEGER or FLOAT, got %s", args[0].Type())
+}
+
+// extremum makes min or max, which return a float as soon as one of the
+// arguments is a float.
+func extremum(name string, better func(a, b float64) bool) *Builtin {
+	return &Builtin{
+		Fn: func(args ...Object) Object {
+			values, allIntegers, err := numbers(name, args...)
+			if err != nil {
+				return err
+			}
+
+			best := 0
+			for i := range values {
+				if better(values[i], values[best]) || math.IsNaN(values[i]) {
+					best = i
+				}
+			}
+			if allIntegers {
+				return args[best]
+			}
+			return &Float{Value: values[best]}
+		},
+	}
+}
+
+var (
+	minimum = extremum("min", func(a, b float64) bool { return a < b })
+	maximum = extremum("max", func(a, b float64) bool { return a > b })
+)
+
+func pow(args ...Object) Object {
+	values, allIntegers, err := numbers("pow", args...)
+	if err != nil {
+		return err
+	}
+	if !allIntegers {
+		return &Float{Value: math.Pow(values[0], values[1])}
+	}
+
+	base, exponent := args[0].(*Integer).Value, args[1].(*Integer).Value
+	if exponent < 0 {
+		return newError("negative exponent for integers in `pow`: %d", exponent)
+	}
+	result := int64(1)
+	for exponent > 0 {
+		if exponent&1 == 1 {
+			result *= base
+		}
+		base *= base
+		exponent >>= 1
+	}
+	return &Integer{Value: result}
+}
+
+// toInt truncates a float toward zero.
+func toInt(args ...Object) Object {
+	switch arg := args[0].(type) {
+	case *Integer:
+		return arg
+	case *Float:
+		value := math.Trunc(arg.Value)
+		if math.IsNaN(value) || value < math.MinInt64 || value >= math.MaxInt64 {
+			return newError("%s is out of the range of integers", arg.Inspect())
+		}
+		return &Integer{Value: int64(value)}
+	}
+	return newError("argument to `int` must be INTEGER or FLOAT, got %s", args[0].Type())
+}
+
+func toFloat(args ...Object) Object {
+	switch arg := args[0].(type) {
+	case *Integer:
+		return &Float{Value: float64(arg.Value)}
+	case *Float:
+		return arg
+	}
+	return newError("argument to `float` must be INTEGER or FLOAT, got %s", args[0].Type())
+}
@@ -1,23 +1,67 @@
 package object
 
 import (
+	"bufio"
 	"bytes"
 	"fmt"
 	"gold/ast"
 	"gold/code"
 	"hash/fnv"
+	"io"
+	"math"
 	"strings"
 )
 
 type BuiltinFunction func(args ...Object) Object
 
+// RuntimeFunction is a builtin calling back the functions of the program
+// through rt. A non-nil error stops the program.
+type RuntimeFunction func(rt Runtime, args ...Object) (Object, error)
+
+// Runtime is the VM running a builtin.
+type Runtime interface {
+	// Call runs fn, a closure or a builtin, with args until it returns.
+	Call(fn Object, args ...Object) (Object, error)
+	// Args returns the command-line arguments of the program.
+	Args() []string
+	// Getenv returns the value of the environment variable name, false when
+	// it is not set or the host doesn't give access to the environment.
+	Getenv(name string) (string, bool)
+	// Exit returns the error stopping the program with code as its exit
+	// status, for the builtin to return.
+	Exit(code int) error
+	// Files returns the files the program may access.
+	Files() *Files
+	// SetLastError records the error of a builtin returning null when it
+	// fails, for last_error.
+	SetLastError(err error)
+	// LastError returns the error recorded last, nil when there is none.
+	LastError() error
+	// Allocate fails when a value of size elements, pairs or bytes is larger
+	// than the program may build. Builtins whose result can be much larger
+	// than their arguments call it before building it.
+	Allocate(size int) error
+	// Stdin, Stdout and Stderr are the standard streams of the program.
+	Stdin() *bufio.Reader
+	Stdout() io.Writer
+	Stderr() io.Writer
+}
+
 type Attribute struct {
 	ObjectType        ObjectType
 	FunctionAttribute *Attribute
 	ArgsObjectType    []ObjectType
 	ArgsNullable      []bool
-	Nullable          bool
-	IsFunction        bool
+	// ArgsFunction is the signature of the functions expected as arguments,
+	// nil for the arguments that are not functions.
+	ArgsFunction []*Attribute
+	// Variadic functions take any number of arguments of the last type.
+	Variadic bool
+	// Promote makes the result an INTEGER when every argument is an integer,
+	// a FLOAT otherwise, as for the operators.
+	Promote    bool
+	Nullable   bool
+	IsFunction bool
 }
 
 type ObjectType string
@@ -26,6 +70,8 @@ const (
 	NULL_OBJ  = "NULL"
 	ERROR_OBJ = "ERROR"
 	ANY       = "ANY"
+	// NUMBER is an INTEGER or a FLOAT, for the parameters of builtins.
+	NUMBER = "NUMBER"
 
 	INTEGER_OBJ = "INTEGER"
 	FLOAT_OBJ   = "FLOAT"
@@ -38,6 +84,7 @@ const (
 	BUILTIN_OBJ  = "BUILTIN"
 
 	ARRAY_OBJ = "ARRAY"
+	TUPLE_OBJ = "TUPLE"
 	HASH_OBJ  = "HASH"
 
 	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION_OBJ"
@@ -50,6 +97,9 @@ func (info *Attribute) IsTypeOf(s ...ObjectType) bool {
 		if info.ObjectType == c || c == ANY {
 			return true
 		}
+		if c == NUMBER && (info.ObjectType == INTEGER_OBJ || info.ObjectType == FLOAT_OBJ) {
+			return true
+		}
 	}
 	return info.ObjectType == ANY
 }
@@ -84,8 +134,15 @@ type Float struct {
 
 func (i *Float) Type() ObjectType { return FLOAT_OBJ }
 func (i *Float) Inspect() string  { return fmt.Sprintf("%f", i.Value) }
+
+// HashKey gives a float with an integer value the key of the integer, as
+// they are equal, and the other floats a key of their bits. 0.0 and -0.0 are
+// both the integer 0, and NaN is never found, as it isn't equal to itself.
 func (i *Float) HashKey() HashKey {
-	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
+	if n, ok := floatToInteger(i.Value); ok {
+		return (&Integer{Value: n}).HashKey()
+	}
+	return HashKey{Type: i.Type(), Value: math.Float64bits(i.Value)}
 }
 
 type Boolean struct {
@@ -106,6 +163,21 @@ func (b *Boolean) HashKey() HashKey {
 	return HashKey{Type: b.Type(), Value: value}
 }
 
+// TRUE, FALSE and NULL are the only booleans and null of a running program,
+// shared rather than allocated for each result.
+var (
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+	NULL  = &Null{}
+)
+
+func NativeBoolToBooleanObject(input bool) *Boolean {
+	if input {
+		return TRUE
+	}
+	return FALSE
+}
+
 type Null struct{}
 
 func (n *Null) Type() ObjectType { return NULL_OBJ }
@@ -163,8 +235,11 @@ func (s *String) HashKey() HashKey {
 	return HashKey{Type: s.Type(), Value: h.Sum64()}
 }
 
+// Builtin is a function implemented in Go. It is called through RuntimeFn
+// when it is set, through Fn otherwise.
 type Builtin struct {
-	Fn BuiltinFunction
+	Fn        BuiltinFunction
+	RuntimeFn RuntimeFunction
 }
 
 func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
@@ -190,21 +265,108 @@ func (ao *Array) Inspect() string {
 	return out.String()
 }
 
+// Tuple is an array that can't change, so it can be a hash key when its
+// elements can.
+type Tuple struct {
+	Elements []Object
+}
+
+func (t *Tuple) Type() ObjectType { return TUPLE_OBJ }
+func (t *Tuple) Inspect() string {
+	var out bytes.Buffer
+
+	elements := []string{}
+	for _, e := range t.Elements {
+		elements = append(elements, e.Inspect())
+	}
+
+	out.WriteString("(")
+	out.WriteString(strings.Join(elements, ", "))
+	if len(elements) == 1 {
+		out.WriteString(",")
+	}
+	out.WriteString(")")
+
+	return out.String()
+}
+
 type HashPair struct {
 	Key   Object
 	Value Object
 }
 
+// Hash is a dictionary keeping its pairs in the order their keys were first
+// set, with the lookups of a map. Keys with the same HashKey share a bucket,
+// where they are told apart with Equal. The zero value is an empty hash.
 type Hash struct {
-	Pairs map[HashKey]HashPair
+	pairs   []HashPair
+	buckets map[HashKey][]int
+}
+
+// NewHash returns an empty hash with room for size pairs.
+func NewHash(size int) *Hash {
+	return &Hash{
+		pairs:   make([]HashPair, 0, size),
+		buckets: make(map[HashKey][]int, size),
+	}
 }
 
+// find returns the hash key of key and the index of its pair, -1 when the
+// hash has none.
+func (h *Hash) find(key Object) (HashKey, int, error) {
+	hashKey, err := HashKeyOf(key)
+	if err != nil {
+		return hashKey, -1, err
+	}
+	for _, i := range h.buckets[hashKey] {
+		if Equal(h.pairs[i].Key, key) {
+			return hashKey, i, nil
+		}
+	}
+	return hashKey, -1, nil
+}
+
+// Set sets the value of key, or fails when key isn't usable as a hash key. A
+// key set again keeps its place, and the key it was first set with.
+func (h *Hash) Set(key, value Object) error {
+	hashKey, i, err := h.find(key)
+	if err != nil {
+		return err
+	}
+	if i >= 0 {
+		h.pairs[i].Value = value
+		return nil
+	}
+	if h.buckets == nil {
+		h.buckets = map[HashKey][]int{}
+	}
+	h.buckets[hashKey] = append(h.buckets[hashKey], len(h.pairs))
+	h.pairs = append(h.pairs, HashPair{Key: key, Value: value})
+	return nil
+}
+
+// Get returns the value of key, false when the hash has none. It fails when
+// key isn't usable as a hash key.
+func (h *Hash) Get(key Object) (Object, bool, error) {
+	_, i, err := h.find(key)
+	if err != nil || i < 0 {
+		return nil, false, err
+	}
+	return h.pairs[i].Value, true, nil
+}
+
+// Len returns the number of pairs.
+func (h *Hash) Len() int { return len(h.pairs) }
+
+// Pairs returns the pairs in insertion order. The slice must not be modified.
+func (h *Hash) Pairs() []HashPair { return h.pairs }
+
 func (h *Hash) Type() ObjectType { return HASH_OBJ }
 func (h *Hash) Inspect() string {
 	var out bytes.Buffer
 
 	pairs := []string{}
-	for _, pair := range h.Pairs {
+	for _, pair := range h.pairs {
 		pairs = append(pairs, fmt.Sprintf("%s: %s",
 			pair.Key.Inspect(), pair.Value.Inspect()))
 	}
@@ -220,6 +382,9 @@ type CompiledFunction struct {
 	Instructions  code.Instructions
 	NumLocals     int
 	NumParameters int
+	// Name is the name the function was declared with, empty if it has none.
+	// It is only used to report errors.
+	Name string
 }
 
 func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
@@ -1,6 +1,9 @@
 package object
 
-import "testing"
+import (
+	"math"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -58,3 +61,83 @@ func TestIntegerHashKey(t *testing.T) {
 		t.Errorf("integers with twoerent content have same hash keys")
 	}
 }
+
+func TestHashOrder(t *testing.T) {
+	hash := &Hash{}
+	for i, key := range []string{"b", "a", "c", "a"} {
+		hash.Set(&String{Value: key}, &Integer{Value: int64(i)})
+	}
+
+	if hash.Len() != 3 {
+		t.Errorf("hash has wrong number of pairs. want=3, got=%d", hash.Len())
+	}
+
+	expected := "{b: 0, a: 3, c: 2}"
+	if hash.Inspect() != expected {
+		t.Errorf("hash.Inspect() wrong. want=%q, got=%q", expected, hash.Inspect())
+	}
+
+	value, ok, _ := hash.Get(&String{Value: "a"})
+	if !ok || value.(*Integer).Value != 3 {
+		t.Errorf("hash.Get wrong. got=%+v, %t", value, ok)
+	}
+
+	if _, ok, _ := hash.Get(&String{Value: "d"}); ok {
+		t.Errorf("hash.Get found a missing key")
+	}
+}
+
+func TestFloatHashKey(t *testing.T) {
+	if (&Float{Value: 1.5}).HashKey() == (&Float{Value: 1.9}).HashKey() {
+		t.Errorf("floats with different values have same hash keys")
+	}
+
+	if (&Float{Value: 2.0}).HashKey() != (&Integer{Value: 2}).HashKey() {
+		t.Errorf("equal float and integer have different hash keys")
+	}
+
+	if (&Float{Value: -0.0}).HashKey() != (&Float{Value: 0.0}).HashKey() {
+		t.Errorf("zeros have different hash keys")
+	}
+}
+
+func TestEqual(t *testing.T) {
+	nan := &Float{Value: math.NaN()}
+	big := &Integer{Value: 1<<53 + 1}
+	tests := []struct {
+		a, b     Object
+		expected bool
+	}{
+		{&Integer{Value: 1}, &Float{Value: 1}, true},
+		{big, &Float{Value: float64(big.Value)}, false},
+		{nan, nan, false},
+		{&String{Value: "a"}, &String{Value: "a"}, true},
+		{&Array{Elements: []Object{TRUE}}, &Tuple{Elements: []Object{TRUE}}, false},
+		{&Tuple{Elements: []Object{NULL}}, &Tuple{Elements: []Object{&Null{}}}, true},
+	}
+
+	for _, tt := range tests {
+		if Equal(tt.a, tt.b) != tt.expected {
+			t.Errorf("Equal(%s, %s) wrong. want=%t", tt.a.Inspect(), tt.b.Inspect(), tt.expected)
+		}
+	}
+}
+
+func TestHashCollisions(t *testing.T) {
+	hash := &Hash{}
+	hash.Set(&String{Value: "a"}, &Integer{Value: 1})
+	// Make "b" collide with "a"
+	key := (&String{Value: "a"}).HashKey()
+	hash.buckets[key] = append(hash.buckets[key], len(hash.pairs))
+	hash.pairs = append(hash.pairs, HashPair{Key: &String{Value: "b"}, Value: &Integer{Value: 2}})
+
+	value, ok, _ := hash.Get(&String{Value: "a"})
+	if !ok || value.(*Integer).Value != 1 {
+		t.Errorf("hash.Get wrong. got=%v, %t", value, ok)
+	}
+
+	hash.Set(&String{Value: "a"}, &Integer{Value: 3})
+	if hash.Len() != 2 || hash.pairs[1].Value.(*Integer).Value != 2 {
+		t.Errorf("hash.Set replaced the colliding pair. got=%s", hash.Inspect())
+	}
+}
@@ -0,0 +1,84 @@
+package object
+
+import "math"
+
+// programArgs returns the command-line arguments of the program, as an
+// array of strings.
+func programArgs(rt Runtime, args ...Object) (Object, error) {
+	values := rt.Args()
+	elements := make([]Object, len(values))
+	for i, value := range values {
+		elements[i] = &String{Value: value}
+	}
+	return &Array{Elements: elements}, nil
+}
+
+// getenv returns the value of an environment variable, or null when it is
+// not set or the host doesn't give access to it.
+func getenv(rt Runtime, args ...Object) (Object, error) {
+	name, ok := args[0].(*String)
+	if !ok {
+		return newError("argument to `env` must be STRING, got %s", args[0].Type()), nil
+	}
+	value, ok := rt.Getenv(name.Value)
+	if !ok {
+		return nil, nil
+	}
+	return &String{Value: value}, nil
+}
+
+// exit stops the program with its argument as exit status, from 0 to 255.
+func exit(rt Runtime, args ...Object) (Object, error) {
+	code, ok := args[0].(*Integer)
+	if !ok {
+		return newError("argument to `exit` must be INTEGER, got %s", args[0].Type()), nil
+	}
+	if code.Value < 0 || code.Value > 255 {
+		return newError("exit status must be between 0 and 255, got %d", code.Value), nil
+	}
+	return nil, rt.Exit(int(code.Value))
+}
+
+// fail records err for last_error and returns the null result of a builtin
+// that failed.
+func fail(rt Runtime, err error) (Object, error) {
+	rt.SetLastError(err)
+	return nil, nil
+}
+
+// allocate checks a result of size elements, pairs or bytes against the
+// allocation limit of rt before a builtin builds it. A negative size stands
+// for one that overflowed, which gives an error value; a size over the
+// limit gives the error of rt, which stops the program.
+func allocate(rt Runtime, name string, size int) (Object, error) {
+	if size < 0 {
+		return newError("result of `%s` is too large", name), nil
+	}
+	return nil, rt.Allocate(size)
+}
+
+// addSize and mulSize compute sizes for allocate, negative when they
+// overflow.
+func addSize(a, b int) int {
+	if a < 0 || b < 0 || a > math.MaxInt-b {
+		return -1
+	}
+	return a + b
+}
+
+func mulSize(a, b int) int {
+	if a < 0 || b < 0 || (b > 0 && a > math.MaxInt/b) {
+		return -1
+	}
+	return a * b
+}
+
+// lastError returns the error of the last builtin that failed, null when
+// none did.
+func lastError(rt Runtime, args ...Object) (Object, error) {
+	err := rt.LastError()
+	if err == nil {
+		return nil, nil
+	}
+	return &String{Value: err.Error()}, nil
+}
@@ -0,0 +1,213 @@
+package object
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// signature is the type of a builtin taking non-null arguments of the args
+// types and returning a non-null result.
+func signature(result ObjectType, args ...ObjectType) Attribute {
+	return Attribute{
+		ObjectType:     result,
+		ArgsObjectType: args,
+		ArgsNullable:   make([]bool, len(args)),
+		IsFunction:     true,
+	}
+}
+
+// nullableSignature is signature for a builtin that may return null.
+func nullableSignature(result ObjectType, args ...ObjectType) Attribute {
+	attribute := signature(result, args...)
+	attribute.Nullable = true
+	return attribute
+}
+
+// stringArgs returns the values of the string arguments of a builtin, in
+// order, or an error for the first argument that is not a string.
+func stringArgs(name string, args ...Object) ([]string, *Error) {
+	values := make([]string, len(args))
+	for i, arg := range args {
+		str, ok := arg.(*String)
+		if !ok {
+			return nil, newError("argument to `%s` must be STRING, got %s", name, arg.Type())
+		}
+		values[i] = str.Value
+	}
+	return values, nil
+}
+
+// stringFunction makes a builtin of a Go function on strings.
+func stringFunction(name string, fn func(args []string) Object) *Builtin {
+	return &Builtin{
+		Fn: func(args ...Object) Object {
+			values, err := stringArgs(name, args...)
+			if err != nil {
+				return err
+			}
+			return fn(values)
+		},
+	}
+}
+
+var (
+	split = stringFunction("split", func(args []string) Object {
+		parts := strings.Split(args[0], args[1])
+		elements := make([]Object, len(parts))
+		for i, part := range parts {
+			elements[i] = &String{Value: part}
+		}
+		return &Array{Elements: elements}
+	})
+	trim = stringFunction("trim", func(args []string) Object {
+		return &String{Value: strings.TrimSpace(args[0])}
+	})
+	upper = stringFunction("upper", func(args []string) Object {
+		return &String{Value: strings.ToUpper(args[0])}
+	})
+	lower = stringFunction("lower", func(args []string) Object {
+		return &String{Value: strings.ToLower(args[0])}
+	})
+	contains = stringFunction("contains", func(args []string) Object {
+		return NativeBoolToBooleanObject(strings.Contains(args[0], args[1]))
+	})
+	indexOf = stringFunction("index_of", func(args []string) Object {
+		return &Integer{Value: int64(strings.Index(args[0], args[1]))}
+	})
+	startsWith = stringFunction("starts_with", func(args []string) Object {
+		return NativeBoolToBooleanObject(strings.HasPrefix(args[0], args[1]))
+	})
+	endsWith = stringFunction("ends_with", func(args []string) Object {
+		return NativeBoolToBooleanObject(strings.HasSuffix(args[0], args[1]))
+	})
+	parseInt = stringFunction("parse_int", func(args []string) Object {
+		value, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return nil
+		}
+		return &Integer{Value: value}
+	})
+	parseFloat = stringFunction("parse_float", func(args []string) Object {
+		value, err := strconv.ParseFloat(args[0], 64)
+		if err != nil {
+			return nil
+		}
+		return &Float{Value: value}
+	})
+)
+
+// join concatenates the elements of an array, strings as they are and the
+// other values as they are printed, with a separator between them.
+func join(rt Runtime, args ...Object) (Object, error) {
+	arr, ok := args[0].(*Array)
+	if !ok {
+		return newError("argument to `join` must be ARRAY, got %s", args[0].Type()), nil
+	}
+	sep, ok := args[1].(*String)
+	if !ok {
+		return newError("argument to `join` must be STRING, got %s", args[1].Type()), nil
+	}
+
+	parts := make([]string, len(arr.Elements))
+	size := 0
+	for i, element := range arr.Elements {
+		parts[i] = element.Inspect()
+		size = addSize(size, len(parts[i]))
+	}
+	if len(parts) > 1 {
+		size = addSize(size, mulSize(len(sep.Value), len(parts)-1))
+	}
+	if result, err := allocate(rt, "join", size); result != nil || err != nil {
+		return result, err
+	}
+	return &String{Value: strings.Join(parts, sep.Value)}, nil
+}
+
+// replace replaces every old of a string by new.
+func replace(rt Runtime, args ...Object) (Object, error) {
+	values, err := stringArgs("replace", args...)
+	if err != nil {
+		return err, nil
+	}
+	s, old, new := values[0], values[1], values[2]
+	size := len(s)
+	if len(new) > len(old) {
+		size = addSize(size, mulSize(strings.Count(s, old), len(new)-len(old)))
+	}
+	if result, err := allocate(rt, "replace", size); result != nil || err != nil {
+		return result, err
+	}
+	return &String{Value: strings.ReplaceAll(s, old, new)}, nil
+}
+
+// repeat returns a string repeated count times. A result over the
+// allocation limit stops the program.
+func repeat(rt Runtime, args ...Object) (Object, error) {
+	str, ok := args[0].(*String)
+	if !ok {
+		return newError("argument to `repeat` must be STRING, got %s", args[0].Type()), nil
+	}
+	count, ok := args[1].(*Integer)
+	if !ok {
+		return newError("argument to `repeat` must be INTEGER, got %s", args[1].Type()), nil
+	}
+	if count.Value < 0 {
+		return newError("negative count for `repeat`: %d", count.Value), nil
+	}
+	size := -1
+	if count.Value <= math.MaxInt {
+		size = mulSize(len(str.Value), int(count.Value))
+	}
+	if result, err := allocate(rt, "repeat", size); result != nil || err != nil {
+		return result, err
+	}
+	return &String{Value: strings.Repeat(str.Value, int(count.Value))}, nil
+}
+
+// format replaces every {} of the string by the next argument, as it is
+// printed: format("{} + {}", 1, 2) is "1 + 2". {{ and }} stand for { and }.
+func format(args ...Object) Object {
+	if len(args) == 0 {
+		return newError("wrong number of arguments. got=0, want=at least 1")
+	}
+	str, ok := args[0].(*String)
+	if !ok {
+		return newError("argument to `format` must be STRING, got %s", args[0].Type())
+	}
+
+	var out strings.Builder
+	values := args[1:]
+	used := 0
+	template := str.Value
+	for i := 0; i < len(template); i++ {
+		switch {
+		case strings.HasPrefix(template[i:], "{{"):
+			out.WriteByte('{')
+			i++
+		case strings.HasPrefix(template[i:], "}}"):
+			out.WriteByte('}')
+			i++
+		case strings.HasPrefix(template[i:], "{}"):
+			if used == len(values) {
+				return newError("`format` has more {} than the %d arguments", len(values))
+			}
+			out.WriteString(values[used].Inspect())
+			used++
+			i++
+		default:
+			out.WriteByte(template[i])
+		}
+	}
+	if used != len(values) {
+		return newError("`format` has %d {} but %d arguments", used, len(values))
+	}
+	return &String{Value: out.String()}
+}
+
+func toStr(args ...Object) Object {
+	if str, ok := args[0].(*String); ok {
+		return str
+	}
+	return &String{Value: args[0].Inspect()}
+}
@@ -53,13 +53,20 @@ type Parser struct {
 	postfixParseFns map[token.TokenType]postfixParseFn
 
 	l      *lexer.Lexer
-	errors []string
+	errors []ParseError
+}
+
+// ParseError is an error found while parsing, along with the token the
+// parser was looking at when it found it.
+type ParseError struct {
+	Message string
+	Token   token.Token
 }
 
 func New(l *lexer.Lexer) *Parser {
 	p := &Parser{
 		l:      l,
-		errors: []string{},
+		errors: []ParseError{},
 	}
 
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
@@ -134,6 +141,8 @@ func (p *Parser) parseStatement() ast.Statement {
 
 	if curTokenType == token.RETURN {
 		return p.parseReturnStatement()
+	} else if curTokenType == token.IMPORT {
+		return p.parseImportStatement()
 	} else {
 		return p.parseExpressionStatement()
 	}
@@ -218,6 +227,21 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+func (p *Parser) parseImportStatement() *ast.ImportStatement {
+	stmt := &ast.ImportStatement{Token: p.curToken}
+
+	if !p.expectPeek(token.STRING) {
+		return nil
+	}
+	stmt.Path = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
 // === PARSE EXPRESSIONS ===
 
 func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
@@ -271,7 +295,7 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
 		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.addError(p.curToken, msg)
 		return nil
 	}
 
@@ -286,7 +310,7 @@ func (p *Parser) parseFloatLiteral() ast.Expression {
 	value, err := strconv.ParseFloat(p.curToken.Literal+"0", 64) // We add a 0 in case we have "3."
 	if err != nil {
 		msg := fmt.Sprintf("could not parse %q as float", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.addError(p.curToken, msg)
 		return nil
 	}
 
@@ -470,6 +494,7 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 		block.Statements = append(block.Statements, stmt)
 		p.nextToken()
 	}
+	block.Rbrace = p.curToken
 
 	return block
 }
@@ -571,6 +596,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		value := p.parseExpression(LOWEST)
 
 		hash.Pairs[key] = value
+		hash.Keys = append(hash.Keys, key)
 
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
 			return nil
@@ -638,16 +664,29 @@ func (p *Parser) curPrecedence() int {
 }
 
 func (p *Parser) Errors() []string {
+	messages := make([]string, len(p.errors))
+	for i, e := range p.errors {
+		messages[i] = e.Message
+	}
+	return messages
+}
+
+// ParseErrors returns the same errors as Errors with their position.
+func (p *Parser) ParseErrors() []ParseError {
 	return p.errors
 }
 
+func (p *Parser) addError(tok token.Token, msg string) {
+	p.errors = append(p.errors, ParseError{Message: msg, Token: tok})
+}
+
 func (p *Parser) peekError(t token.TokenType) {
 	msg := fmt.Sprintf("expected next token to be %s, got %s instead",
 		t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.addError(p.peekToken, msg)
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
 	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	p.addError(p.curToken, msg)
 }
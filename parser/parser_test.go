@@ -150,6 +150,36 @@ func TestReturnStatements(t *testing.T) {
 	}
 }
 
+func TestImportStatements(t *testing.T) {
+	input := `import "lib/math"; import "util"`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	expected := []string{"lib/math", "util"}
+	if len(program.Statements) != len(expected) {
+		t.Fatalf("program.Statements does not contain %d statements. got=%d",
+			len(expected), len(program.Statements))
+	}
+	for i, path := range expected {
+		stmt, ok := program.Statements[i].(*ast.ImportStatement)
+		if !ok {
+			t.Fatalf("stmt not *ast.ImportStatement. got=%T", program.Statements[i])
+		}
+		if stmt.Path.Value != path {
+			t.Errorf("stmt.Path.Value not %q. got=%q", path, stmt.Path.Value)
+		}
+	}
+
+	p = New(lexer.New("import x"))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Errorf("expected an error for an import without a string")
+	}
+}
+
 func TestIdentifierExpression(t *testing.T) {
 	input := "foobar;"
 
@@ -917,6 +947,33 @@ func TestStringLiteralExpression(t *testing.T) {
 	}
 }
 
+func TestComments(t *testing.T) {
+	input := `// a comment ends at the end of its line
+let url = "http://gold"; // not in strings
+url // last`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 2 {
+		t.Fatalf("program.Statements does not contain 2 statements. got=%d", len(program.Statements))
+	}
+	declare, ok := program.Statements[0].(*ast.Declare)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not *ast.Declare. got=%T", program.Statements[0])
+	}
+	literal, ok := declare.Value.(*ast.StringLiteral)
+	if !ok || literal.Value != "http://gold" {
+		t.Errorf("declare.Value is not the string %q. got=%s", "http://gold", declare.Value)
+	}
+	stmt, ok := program.Statements[1].(*ast.ExpressionStatement)
+	if !ok || !testIdentifier(t, stmt.Expression, "url") {
+		t.Errorf("program.Statements[1] is not url. got=%s", program.Statements[1])
+	}
+}
+
 func TestParsingEmptyArrayLiterals(t *testing.T) {
 	input := "[]"
 
@@ -1415,3 +1472,25 @@ func checkParserErrors(t *testing.T, p *Parser) {
 	}
 	t.FailNow()
 }
+
+func TestParseErrorPosition(t *testing.T) {
+	input := `let x = 5;
+let 5 = 10;`
+
+	l := lexer.New(input)
+	p := New(l)
+	p.ParseProgram()
+
+	errors := p.ParseErrors()
+	if len(errors) == 0 {
+		t.Fatalf("parser did not report any error")
+	}
+
+	err := errors[0]
+	if err.Message != "expected next token to be IDENT, got INT instead" {
+		t.Errorf("wrong message. got=%q", err.Message)
+	}
+	if err.Token.Line != 2 || err.Token.Column != 5 {
+		t.Errorf("wrong position. want=2:5, got=%d:%d", err.Token.Line, err.Token.Column)
+	}
+}
@@ -0,0 +1,228 @@
+package project
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ManifestFile is the name of the manifest at the root of every package.
+const ManifestFile = "gold.mod"
+
+// Manifest describes a package. In a gold.mod file, every line is a
+// directive followed by its arguments, and // starts a comment:
+//
+//	package app
+//	version 1.0.0
+//	entry main.gold
+//	require util ^0.3.0 ../util
+//	require json 1.2.x vendor/json.zip
+type Manifest struct {
+	Package string
+	Version Version
+	// Entry is the file imported when the package is imported by its name,
+	// and the one built. Defaults to main.gold.
+	Entry    string
+	Requires []Require
+}
+
+// Require is a dependency of a package: the name of the package, the
+// versions accepted and where it is, as a directory or a zip archive
+// relative to the requiring package.
+type Require struct {
+	Package    string
+	Constraint Constraint
+	Path       string
+}
+
+// ParseManifest reads the content of a gold.mod file.
+func ParseManifest(data []byte) (*Manifest, error) {
+	m := &Manifest{Entry: "main.gold"}
+	seen := map[string]bool{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if i := strings.Index(text, "//"); i >= 0 {
+			text = text[:i]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+
+		directive, args := fields[0], fields[1:]
+		want := 1
+		if directive == "require" {
+			want = 3
+		}
+		if len(args) != want {
+			return nil, fmt.Errorf("%s:%d: %s takes %d arguments, got %d", ManifestFile, line, directive, want, len(args))
+		}
+		if directive != "require" && seen[directive] {
+			return nil, fmt.Errorf("%s:%d: %s is given twice", ManifestFile, line, directive)
+		}
+		seen[directive] = true
+
+		var err error
+		switch directive {
+		case "package":
+			if !isName(args[0]) {
+				return nil, fmt.Errorf("%s:%d: invalid package name %q", ManifestFile, line, args[0])
+			}
+			m.Package = args[0]
+		case "version":
+			m.Version, err = ParseVersion(args[0])
+		case "entry":
+			m.Entry = args[0]
+		case "require":
+			if !isName(args[0]) {
+				return nil, fmt.Errorf("%s:%d: invalid package name %q", ManifestFile, line, args[0])
+			}
+			for _, require := range m.Requires {
+				if require.Package == args[0] {
+					return nil, fmt.Errorf("%s:%d: %s is required twice", ManifestFile, line, args[0])
+				}
+			}
+			var constraint Constraint
+			constraint, err = ParseConstraint(args[1])
+			m.Requires = append(m.Requires, Require{Package: args[0], Constraint: constraint, Path: args[2]})
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown directive %q", ManifestFile, line, directive)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", ManifestFile, line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if m.Package == "" {
+		return nil, fmt.Errorf("%s: the package directive is missing", ManifestFile)
+	}
+	if !seen["version"] {
+		return nil, fmt.Errorf("%s: the version directive is missing", ManifestFile)
+	}
+	return m, nil
+}
+
+// isName reports whether name can name a package: it is the first element
+// of the imports of the package.
+func isName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// Version is a semantic version, without pre-release nor build metadata.
+type Version struct {
+	Major, Minor, Patch int
+}
+
+// ParseVersion reads a version such as 1.2.3.
+func ParseVersion(s string) (Version, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return Version{}, fmt.Errorf("invalid version %q, expect MAJOR.MINOR.PATCH", s)
+	}
+	var numbers [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || part != strconv.Itoa(n) {
+			return Version{}, fmt.Errorf("invalid version %q, expect MAJOR.MINOR.PATCH", s)
+		}
+		numbers[i] = n
+	}
+	return Version{Major: numbers[0], Minor: numbers[1], Patch: numbers[2]}, nil
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Compare returns -1, 0 or 1 when v is lower than, equal to or greater than
+// other.
+func (v Version) Compare(other Version) int {
+	for _, d := range []int{v.Major - other.Major, v.Minor - other.Minor, v.Patch - other.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// Constraint is the set of versions accepted for a dependency:
+//
+//	1.2.3    exactly 1.2.3
+//	>=1.2.3  1.2.3 or any later version
+//	^1.2.3   1.2.3 or a later version with the same major version
+//	~1.2.3   1.2.3 or a later version with the same major and minor versions
+//	1.2.x    any version 1.2, like ~1.2.0
+//	*        any version
+type Constraint struct {
+	op      string
+	version Version
+}
+
+// ParseConstraint reads a constraint.
+func ParseConstraint(s string) (Constraint, error) {
+	if s == "*" {
+		return Constraint{op: "*"}, nil
+	}
+	if strings.HasSuffix(s, ".x") {
+		v, err := ParseVersion(strings.TrimSuffix(s, "x") + "0")
+		if err != nil {
+			return Constraint{}, fmt.Errorf("invalid constraint %q", s)
+		}
+		return Constraint{op: "~", version: v}, nil
+	}
+
+	op := ""
+	for _, prefix := range []string{">=", "^", "~"} {
+		if strings.HasPrefix(s, prefix) {
+			op = prefix
+			break
+		}
+	}
+	v, err := ParseVersion(strings.TrimPrefix(s, op))
+	if err != nil {
+		return Constraint{}, fmt.Errorf("invalid constraint %q", s)
+	}
+	return Constraint{op: op, version: v}, nil
+}
+
+// Allows reports whether v satisfies the constraint.
+func (c Constraint) Allows(v Version) bool {
+	switch c.op {
+	case "*":
+		return true
+	case "":
+		return v == c.version
+	case ">=":
+		return v.Compare(c.version) >= 0
+	case "^":
+		return v.Major == c.version.Major && v.Compare(c.version) >= 0
+	case "~":
+		return v.Major == c.version.Major && v.Minor == c.version.Minor && v.Compare(c.version) >= 0
+	}
+	return false
+}
+
+func (c Constraint) String() string {
+	if c.op == "*" {
+		return "*"
+	}
+	return c.op + c.version.String()
+}
@@ -0,0 +1,251 @@
+// Package project loads a Gold package described by its gold.mod manifest,
+// with the packages it depends on, and resolves the imports of its modules.
+//
+// The dependencies are directories or zip archives on the local disk, with
+// a gold.mod at their root: they are found without any network access. A
+// package is required with a constraint on its version, and every package of
+// the graph is loaded once, so all the packages requiring it must accept its
+// version.
+//
+// An import whose first element is the name of a package required by the
+// importing one refers to that package: "util" is its entry file and
+// "util/text" is text.gold at its root. The other imports are relative to
+// the importing file, and can't leave its package.
+package project
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"gold/compiler"
+	"gold/lexer"
+	"gold/parser"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Package is a package of the project.
+type Package struct {
+	Manifest *Manifest
+	// Location is the directory or the archive of the package.
+	Location string
+
+	files fs.FS
+	// dependencies are the packages required, by name
+	dependencies map[string]*Package
+	root         bool
+}
+
+// Project is a package with every package it depends on.
+type Project struct {
+	Root *Package
+	// Packages are the packages of the project by name, Root included.
+	Packages map[string]*Package
+}
+
+// Load loads the package in dir and its dependencies.
+func Load(dir string) (*Project, error) {
+	p := &Project{Packages: map[string]*Package{}}
+	root, err := p.load(filepath.Clean(dir), nil)
+	if err != nil {
+		p.Close()
+		return nil, err
+	}
+	root.root = true
+	p.Root = root
+	return p, nil
+}
+
+// load loads the package at location, required by the packages of chain,
+// and its dependencies.
+func (p *Project) load(location string, chain []string) (*Package, error) {
+	files, err := open(location)
+	if err != nil {
+		return nil, err
+	}
+	data, err := fs.ReadFile(files, ManifestFile)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", location, err)
+	}
+	manifest, err := ParseManifest(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", location, err)
+	}
+
+	pkg := &Package{Manifest: manifest, Location: location, files: files, dependencies: map[string]*Package{}}
+	p.Packages[manifest.Package] = pkg
+	chain = append(chain, manifest.Package)
+
+	// Requires are relative to the directory of the package, or to the
+	// directory holding its archive.
+	base := location
+	if _, ok := files.(*zip.ReadCloser); ok {
+		base = filepath.Dir(location)
+	}
+	for _, require := range manifest.Requires {
+		dependency, err := p.require(manifest.Package, require, filepath.Join(base, filepath.FromSlash(require.Path)), chain)
+		if err != nil {
+			return nil, err
+		}
+		pkg.dependencies[require.Package] = dependency
+	}
+	return pkg, nil
+}
+
+func (p *Project) require(from string, require Require, location string, chain []string) (*Package, error) {
+	for i, name := range chain {
+		if name == require.Package {
+			return nil, fmt.Errorf("package cycle: %s -> %s", strings.Join(chain[i:], " -> "), name)
+		}
+	}
+
+	dependency, ok := p.Packages[require.Package]
+	if ok {
+		if dependency.Location != location {
+			return nil, fmt.Errorf("package %s is required from %s and %s", require.Package, dependency.Location, location)
+		}
+	} else {
+		var err error
+		dependency, err = p.load(location, chain)
+		if err != nil {
+			return nil, err
+		}
+		if dependency.Manifest.Package != require.Package {
+			return nil, fmt.Errorf("%s requires %s, but %s holds package %s", from, require.Package, location, dependency.Manifest.Package)
+		}
+	}
+
+	if !require.Constraint.Allows(dependency.Manifest.Version) {
+		return nil, fmt.Errorf("%s requires %s %s, but %s is version %s",
+			from, require.Package, require.Constraint, location, dependency.Manifest.Version)
+	}
+	return dependency, nil
+}
+
+// open gives access to the files of the package at location, a directory or
+// a zip archive.
+func open(location string) (fs.FS, error) {
+	info, err := os.Stat(location)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return os.DirFS(location), nil
+	}
+	if filepath.Ext(location) != ".zip" {
+		return nil, fmt.Errorf("%s is neither a directory nor a zip archive", location)
+	}
+	return zip.OpenReader(location)
+}
+
+// Close closes the archives of the dependencies.
+func (p *Project) Close() error {
+	var errs []error
+	for _, pkg := range p.Packages {
+		if archive, ok := pkg.files.(*zip.ReadCloser); ok {
+			errs = append(errs, archive.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Names returns the names of the packages of the project, sorted.
+func (p *Project) Names() []string {
+	names := make([]string, 0, len(p.Packages))
+	for name := range p.Packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Entry returns the name of the module of the entry file of the root package,
+// the one to compile with the project as resolver.
+func (p *Project) Entry() string {
+	return p.Root.moduleName(path.Clean(p.Root.Manifest.Entry))
+}
+
+// Build compiles the entry file of the root package, with the modules it
+// imports.
+func (p *Project) Build(level int) (*compiler.Compiler, error) {
+	entry := p.Entry()
+	source, err := fs.ReadFile(p.Root.files, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	pr := parser.New(lexer.New(string(source)))
+	program := pr.ParseProgram()
+	if errs := pr.ParseErrors(); len(errs) != 0 {
+		return nil, fmt.Errorf("%s:%d:%d: %s", entry, errs[0].Token.Line, errs[0].Token.Column, errs[0].Message)
+	}
+
+	comp := compiler.New()
+	comp.SetResolver(p, entry)
+	comp.SetOptimization(level)
+	_, err = comp.Compile(program)
+	if err != nil {
+		var compileErr *compiler.CompileError
+		if errors.As(err, &compileErr) {
+			return nil, fmt.Errorf("%s:%d:%d: %w", entry, compileErr.Token.Line, compileErr.Token.Column, err)
+		}
+		return nil, fmt.Errorf("%s: %w", entry, err)
+	}
+	return comp, nil
+}
+
+// Resolve finds the module imported as importPath by the module named from,
+// see compiler.Resolver.
+func (p *Project) Resolve(from, importPath string) (string, string, error) {
+	pkg, fromFile := p.module(from)
+
+	target, file := pkg, path.Join(path.Dir(fromFile), importPath)
+	first, rest, _ := strings.Cut(importPath, "/")
+	if dependency, ok := pkg.dependencies[first]; ok {
+		target, file = dependency, rest
+		if rest == "" {
+			file = dependency.Manifest.Entry
+		}
+	}
+	if path.Ext(file) == "" {
+		file += ".gold"
+	}
+	file = path.Clean(file)
+	if !fs.ValidPath(file) {
+		return "", "", fmt.Errorf("%s is outside of package %s", importPath, target.Manifest.Package)
+	}
+
+	source, err := fs.ReadFile(target.files, file)
+	if err != nil {
+		return "", "", fmt.Errorf("package %s: %w", target.Manifest.Package, err)
+	}
+	return target.moduleName(file), string(source), nil
+}
+
+// module returns the package of the module named name and its path in the
+// package.
+func (p *Project) module(name string) (*Package, string) {
+	prefix, file, ok := strings.Cut(name, "/")
+	if ok {
+		if pkgName, _, ok := strings.Cut(prefix, "@"); ok {
+			if pkg, ok := p.Packages[pkgName]; ok && pkg != p.Root {
+				return pkg, file
+			}
+		}
+	}
+	return p.Root, name
+}
+
+// moduleName names the module of file, a path in the package. The modules
+// of the root package are named by their path, the ones of dependencies by
+// the name and version of the package followed by the path.
+func (pkg *Package) moduleName(file string) string {
+	if pkg.root {
+		return file
+	}
+	return pkg.Manifest.Package + "@" + pkg.Manifest.Version.String() + "/" + file
+}
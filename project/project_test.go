@@ -0,0 +1,226 @@
+package project
+
+import (
+	"archive/zip"
+	"gold/vm"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseManifest(t *testing.T) {
+	m, err := ParseManifest([]byte(`
+// the application
+package app
+version 1.2.3
+entry src/app.gold
+require util ^0.3.0 ../util // local copy
+require json 1.2.x vendor/json.zip
+`))
+	if err != nil {
+		t.Fatalf("ParseManifest returned an error: %s", err)
+	}
+	if m.Package != "app" || m.Version != (Version{1, 2, 3}) || m.Entry != "src/app.gold" || len(m.Requires) != 2 {
+		t.Fatalf("wrong manifest: %+v", m)
+	}
+	if r := m.Requires[1]; r.Package != "json" || r.Constraint.String() != "~1.2.0" || r.Path != "vendor/json.zip" {
+		t.Errorf("wrong require: %+v", r)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"version 1.0.0", "gold.mod: the package directive is missing"},
+		{"package app", "gold.mod: the version directive is missing"},
+		{"package app\npackage other", "gold.mod:2: package is given twice"},
+		{"package my.app", `gold.mod:1: invalid package name "my.app"`},
+		{"package app\nversion 1.0", `gold.mod:2: invalid version "1.0", expect MAJOR.MINOR.PATCH`},
+		{"package app\nversion 01.0.0", `gold.mod:2: invalid version "01.0.0", expect MAJOR.MINOR.PATCH`},
+		{"package app\nrequire util ../util", "gold.mod:2: require takes 3 arguments, got 2"},
+		{"package app\nrequire util =1.0.0 ../util", `gold.mod:2: invalid constraint "=1.0.0"`},
+		{"package app\nrequire a * a\nrequire a * b", "gold.mod:3: a is required twice"},
+		{"name app", `gold.mod:1: unknown directive "name"`},
+	}
+	for _, tt := range tests {
+		_, err := ParseManifest([]byte(tt.input))
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestConstraints(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		expected   bool
+	}{
+		{"1.2.3", "1.2.3", true},
+		{"1.2.3", "1.2.4", false},
+		{">=1.2.3", "2.0.0", true},
+		{">=1.2.3", "1.2.2", false},
+		{"^1.2.3", "1.9.0", true},
+		{"^1.2.3", "2.0.0", false},
+		{"^1.2.3", "1.2.0", false},
+		{"~1.2.3", "1.2.9", true},
+		{"~1.2.3", "1.3.0", false},
+		{"1.2.x", "1.2.0", true},
+		{"1.2.x", "1.3.0", false},
+		{"*", "0.0.1", true},
+	}
+	for _, tt := range tests {
+		c, err := ParseConstraint(tt.constraint)
+		if err != nil {
+			t.Fatalf("ParseConstraint(%q) returned an error: %s", tt.constraint, err)
+		}
+		v, err := ParseVersion(tt.version)
+		if err != nil {
+			t.Fatalf("ParseVersion(%q) returned an error: %s", tt.version, err)
+		}
+		if c.Allows(v) != tt.expected {
+			t.Errorf("%s allows %s: want %t", tt.constraint, tt.version, tt.expected)
+		}
+	}
+}
+
+// writeFiles creates files under dir, the keys being slash separated paths.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		err := os.MkdirAll(filepath.Dir(file), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(file, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func writeZip(t *testing.T, file string, files map[string]string) {
+	t.Helper()
+	out, err := os.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	w := zip.NewWriter(out)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuild(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"app/gold.mod":         "package app\nversion 1.0.0\nentry src/main.gold\nrequire util ^0.3.0 ../util\nrequire text ^1.0.0 ../vendor/text.zip",
+		"app/src/main.gold":    `import "util"; import "text/upper"; import "helpers"; [twice(help(1)), shout("gold")]`,
+		"app/src/helpers.gold": "let help = fn(lint x) { return x + 1 }",
+		"util/gold.mod":        "package util\nversion 0.3.1\nentry util.gold",
+		"util/util.gold":       `import "inner/calc"; let twice = fn(lint x) { return double(x) }`,
+		"util/inner/calc.gold": "let double = fn(lint x) { return 2 * x }",
+		"vendor/.keep":         "",
+	})
+	writeZip(t, filepath.Join(dir, "vendor", "text.zip"), map[string]string{
+		"gold.mod":   "package text\nversion 1.4.0\n// requires are relative to the archive\nrequire util >=0.1.0 ../util",
+		"upper.gold": `import "util"; let shout = fn(lstr s) { return upper(s) }`,
+	})
+
+	p, err := Load(filepath.Join(dir, "app"))
+	if err != nil {
+		t.Fatalf("Load returned an error: %s", err)
+	}
+	defer p.Close()
+	if names := strings.Join(p.Names(), " "); names != "app text util" {
+		t.Errorf("wrong packages: %s", names)
+	}
+
+	comp, err := p.Build(0)
+	if err != nil {
+		t.Fatalf("Build returned an error: %s", err)
+	}
+	machine := vm.New(comp.Bytecode())
+	err = machine.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if got := machine.LastPoppedStackElem().Inspect(); got != "[4, GOLD]" {
+		t.Errorf("wrong result: %s", got)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		files    map[string]string
+		expected string
+	}{
+		{
+			map[string]string{
+				"app/gold.mod":  "package app\nversion 1.0.0\nrequire util ^1.0.0 ../util",
+				"util/gold.mod": "package util\nversion 0.3.1",
+			},
+			"app requires util ^1.0.0, but DIR/util is version 0.3.1",
+		},
+		{
+			map[string]string{
+				"app/gold.mod": "package app\nversion 1.0.0\nrequire util * ../lib",
+				"lib/gold.mod": "package lib\nversion 0.3.1",
+			},
+			"app requires util, but DIR/lib holds package lib",
+		},
+		{
+			map[string]string{
+				"app/gold.mod": "package app\nversion 1.0.0\nrequire a * ../a",
+				"a/gold.mod":   "package a\nversion 1.0.0\nrequire b * ../b",
+				"b/gold.mod":   "package b\nversion 1.0.0\nrequire a * ../a",
+			},
+			"package cycle: a -> b -> a",
+		},
+		{
+			map[string]string{
+				"app/gold.mod":   "package app\nversion 1.0.0\nrequire a * ../a\nrequire b * ../b",
+				"a/gold.mod":     "package a\nversion 1.0.0\nrequire b * ../other",
+				"b/gold.mod":     "package b\nversion 1.0.0",
+				"other/gold.mod": "package b\nversion 1.0.0",
+			},
+			"package b is required from DIR/other and DIR/b",
+		},
+	}
+
+	for _, tt := range tests {
+		dir := t.TempDir()
+		writeFiles(t, dir, tt.files)
+		_, err := Load(filepath.Join(dir, "app"))
+		expected := strings.ReplaceAll(tt.expected, "DIR", dir)
+		if err == nil || err.Error() != expected {
+			t.Errorf("wrong error. want=%q, got=%v", expected, err)
+		}
+	}
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"app/gold.mod":  "package app\nversion 1.0.0",
+		"app/main.gold": `import "../outside"`,
+		"outside.gold":  "1",
+	})
+	p, err := Load(filepath.Join(dir, "app"))
+	if err != nil {
+		t.Fatalf("Load returned an error: %s", err)
+	}
+	_, err = p.Build(0)
+	if err == nil || err.Error() != `main.gold:1:1: cannot import "../outside": ../outside is outside of package app` {
+		t.Errorf("wrong error for an import outside of the package, got=%v", err)
+	}
+}
@@ -9,12 +9,15 @@ import (
 	"gold/parser"
 	"gold/vm"
 	"io"
+	"strings"
 )
 
 const PROMPT = ">> "
 
 func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
+	// The programs read their input from the same reader as the lines
+	reader := bufio.NewReader(in)
+	config := vm.Config{Stdin: reader, Stdout: out, Stderr: out}
 
 	constants := []object.Object{}
 	globals := make([]object.Object, vm.GlobalsSize)
@@ -26,12 +29,11 @@ func Start(in io.Reader, out io.Writer) {
 
 	for {
 		fmt.Fprintf(out, PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
+		line, err := reader.ReadString('\n')
+		if line == "" && err != nil {
 			return
 		}
-
-		line := scanner.Text()
+		line = strings.TrimRight(line, "\r\n")
 
 		if line == "" {
 			continue
@@ -50,7 +52,7 @@ func Start(in io.Reader, out io.Writer) {
 		}
 
 		comp := compiler.NewWithState(symbolTable, constants)
-		_, err := comp.Compile(program)
+		_, err = comp.Compile(program)
 		if err != nil {
 			fmt.Fprintf(out, "Woops! Compilation failed:\n %s\n", err)
 			continue
@@ -59,12 +61,16 @@ func Start(in io.Reader, out io.Writer) {
 		code := comp.Bytecode()
 		constants = code.Constants
 
-		machine := vm.NewWithGlobalsStore(code, globals)
+		machine := vm.NewWithGlobalsStore(code, globals, config)
 		err = machine.Run()
+		globals = machine.Globals()
 		if err != nil {
 			fmt.Fprintf(out, "Woops! Executing bytecode failed:\n %s\n", err)
 			continue
 		}
+		if _, exited := machine.ExitCode(); exited {
+			return
+		}
 
 		lastPopped := machine.LastPoppedStackElem()
 		io.WriteString(out, lastPopped.Inspect())
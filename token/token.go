@@ -1,5 +1,7 @@
 package token
 
+import "sort"
+
 type TokenType string
 
 const (
@@ -12,6 +14,8 @@ const (
 	FLOAT  = "FLOAT"
 	STRING = "STRING" // "foobar"
 
+	COMMENT = "COMMENT" // never returned by the lexer, see Lexer.Comments
+
 	INC = "++"
 	DEC = "--"
 
@@ -54,6 +58,7 @@ const (
 	ELSE     = "ELSE"
 	WHILE    = "WHILE"
 	RETURN   = "RETURN"
+	IMPORT   = "IMPORT"
 
 	MINT = "MINT"
 	LINT = "LINT"
@@ -71,6 +76,8 @@ const (
 type Token struct {
 	Type    TokenType
 	Literal string
+	Line    int // line of the first character, starting at 1
+	Column  int // column of the first character in bytes, starting at 1
 }
 
 var keywords = map[string]TokenType{
@@ -84,6 +91,7 @@ var keywords = map[string]TokenType{
 	"else":   ELSE,
 	"while":  WHILE,
 	"return": RETURN,
+	"import": IMPORT,
 
 	"mint":        MINT,
 	"lint":        LINT,
@@ -106,3 +114,14 @@ func LookupIdent(ident string) TokenType {
 	}
 	return IDENT
 }
+
+// Keywords returns every keyword of the language, aliases included, in
+// alphabetical order.
+func Keywords() []string {
+	names := make([]string, 0, len(keywords))
+	for name := range keywords {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
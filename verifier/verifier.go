@@ -0,0 +1,280 @@
+// Package verifier checks that bytecode is safe to run before handing it to
+// the VM, which trusts its input. Bytecode produced by the compiler always
+// passes; the checks are meant for `.cold` files that are corrupt or written
+// by hand.
+package verifier
+
+import (
+	"fmt"
+	"gold/code"
+	"gold/compiler"
+	"gold/object"
+)
+
+// Error locates the first problem found in the bytecode.
+type Error struct {
+	// Function is the index in the constant pool of the function with the
+	// problem, or -1 for the main instructions.
+	Function int
+	// Offset is the position of the faulty instruction.
+	Offset  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	unit := "main"
+	if e.Function >= 0 {
+		unit = fmt.Sprintf("function %d", e.Function)
+	}
+	return fmt.Sprintf("invalid bytecode: %s at %04d: %s", unit, e.Offset, e.Message)
+}
+
+// unit is a sequence of instructions run in its own frame: the main program
+// or a compiled function.
+type unit struct {
+	index        int
+	instructions code.Instructions
+	numLocals    int
+	numFree      int
+	isMain       bool
+
+	// starts maps the offset of every instruction to its position in decoded
+	decoded []instruction
+	starts  map[int]int
+}
+
+type instruction struct {
+	offset   int
+	op       code.Opcode
+	operands []int
+	width    int
+}
+
+// Verify checks that every instruction is defined and complete, that jumps
+// land on instructions, that the indexes of constants, closures, builtins,
+// locals and free variables are in range, and that the stack depth is the
+// same whichever path leads to an instruction and never drops below the
+// locals of the frame. Functions must return on every path.
+func Verify(bytecode *compiler.Bytecode) error {
+	return VerifyWithBuiltins(bytecode, len(object.Builtins))
+}
+
+// VerifyWithBuiltins is Verify for bytecode compiled with numBuiltins
+// builtins, see compiler.NewWithBuiltins.
+func VerifyWithBuiltins(bytecode *compiler.Bytecode, numBuiltins int) error {
+	units := []*unit{{index: -1, instructions: bytecode.Instructions, isMain: true}}
+	byFunction := map[*object.CompiledFunction]*unit{}
+	for i, constant := range bytecode.Constants {
+		fn, ok := constant.(*object.CompiledFunction)
+		if !ok {
+			continue
+		}
+		if fn.NumParameters > fn.NumLocals {
+			return &Error{Function: i, Message: fmt.Sprintf("%d parameters but %d locals", fn.NumParameters, fn.NumLocals)}
+		}
+		u := &unit{index: i, instructions: fn.Instructions, numLocals: fn.NumLocals}
+		units = append(units, u)
+		byFunction[fn] = u
+	}
+
+	for _, u := range units {
+		err := u.decode()
+		if err != nil {
+			return err
+		}
+	}
+
+	// The number of free variables of a function is set by the closures
+	// built from it, which must agree.
+	closed := map[*unit]bool{}
+	for _, u := range units {
+		for _, ins := range u.decoded {
+			if ins.op != code.OpClosure {
+				continue
+			}
+			constIndex, numFree := ins.operands[0], ins.operands[1]
+			if constIndex >= len(bytecode.Constants) {
+				return u.errorf(ins, "constant %d out of range, the pool has %d", constIndex, len(bytecode.Constants))
+			}
+			fn, ok := bytecode.Constants[constIndex].(*object.CompiledFunction)
+			if !ok {
+				return u.errorf(ins, "constant %d is not a function", constIndex)
+			}
+			target := byFunction[fn]
+			if closed[target] && target.numFree != numFree {
+				return u.errorf(ins, "function %d is closed over %d free variables, and %d elsewhere", constIndex, numFree, target.numFree)
+			}
+			closed[target] = true
+			target.numFree = numFree
+		}
+	}
+
+	for _, u := range units {
+		err := u.checkOperands(bytecode.Constants, numBuiltins)
+		if err != nil {
+			return err
+		}
+		err = u.checkStack()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (u *unit) errorf(ins instruction, format string, a ...any) error {
+	return &Error{Function: u.index, Offset: ins.offset, Message: fmt.Sprintf(format, a...)}
+}
+
+func (u *unit) decode() error {
+	u.starts = map[int]int{}
+	for offset := 0; offset < len(u.instructions); {
+		ins, err := code.ReadInstruction(u.instructions[offset:])
+		if err != nil {
+			return &Error{Function: u.index, Offset: offset, Message: err.Error()}
+		}
+
+		u.starts[offset] = len(u.decoded)
+		u.decoded = append(u.decoded, instruction{
+			offset:   offset,
+			op:       ins.Op,
+			operands: ins.Operands,
+			width:    ins.Width,
+		})
+		offset += ins.Width
+	}
+	return nil
+}
+
+func (u *unit) checkOperands(constants []object.Object, numBuiltins int) error {
+	for _, ins := range u.decoded {
+		switch ins.op {
+		case code.OpConstant:
+			if ins.operands[0] >= len(constants) {
+				return u.errorf(ins, "constant %d out of range, the pool has %d", ins.operands[0], len(constants))
+			}
+
+		case code.OpJump, code.OpJumpNotTruthy,
+			code.OpJumpNotGreaterThan, code.OpJumpNotGreaterEqualThan, code.OpJumpNotEqual, code.OpJumpEqual:
+			target := ins.operands[0]
+			if _, ok := u.starts[target]; !ok && target != len(u.instructions) {
+				return u.errorf(ins, "jump target %d is not the start of an instruction", target)
+			}
+
+		case code.OpGetLocal, code.OpSetLocal, code.OpIncLocal, code.OpDecLocal:
+			if ins.operands[0] >= u.numLocals {
+				return u.errorf(ins, "local %d out of range, the frame has %d", ins.operands[0], u.numLocals)
+			}
+
+		case code.OpGetFree:
+			if ins.operands[0] >= u.numFree {
+				return u.errorf(ins, "free variable %d out of range, the closure has %d", ins.operands[0], u.numFree)
+			}
+
+		case code.OpGetBuiltin:
+			if ins.operands[0] >= numBuiltins {
+				return u.errorf(ins, "builtin %d out of range, there are %d", ins.operands[0], numBuiltins)
+			}
+
+		case code.OpHash:
+			if ins.operands[0]%2 != 0 {
+				return u.errorf(ins, "hash built from an odd number of elements: %d", ins.operands[0])
+			}
+
+		case code.OpReturn:
+			if u.isMain {
+				return u.errorf(ins, "return outside of a function")
+			}
+
+		case code.OpTailCall:
+			if u.isMain {
+				return u.errorf(ins, "tail call outside of a function")
+			}
+		}
+	}
+	return nil
+}
+
+// stackEffect returns the number of values an instruction pops, then pushes.
+func stackEffect(ins instruction) (int, int) {
+	switch ins.op {
+	case code.OpPop, code.OpJumpNotTruthy, code.OpSetGlobal, code.OpSetLocal:
+		return 1, 0
+	case code.OpJump, code.OpIncGlobal, code.OpDecGlobal, code.OpIncLocal, code.OpDecLocal:
+		return 0, 0
+	case code.OpJumpNotGreaterThan, code.OpJumpNotGreaterEqualThan, code.OpJumpNotEqual, code.OpJumpEqual:
+		return 2, 0
+	case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv,
+		code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpGreaterEqualThan,
+		code.OpIndex:
+		return 2, 1
+	case code.OpMinus, code.OpBang, code.OpInc, code.OpDec:
+		return 1, 1
+	case code.OpArray, code.OpHash:
+		return ins.operands[0], 1
+	case code.OpCall:
+		return ins.operands[0] + 1, 1
+	case code.OpTailCall:
+		return ins.operands[0] + 1, 0
+	case code.OpClosure:
+		return ins.operands[1], 1
+	case code.OpReturn:
+		return 1, 0
+	}
+	// Constants, null, booleans, and the getters
+	return 0, 1
+}
+
+// checkStack follows every path of the control flow graph, recording the
+// depth of the stack, relative to the locals, before each instruction.
+func (u *unit) checkStack() error {
+	depths := make([]int, len(u.decoded))
+	for i := range depths {
+		depths[i] = -1
+	}
+
+	type state struct{ offset, depth int }
+	pending := []state{{0, 0}}
+
+	for len(pending) > 0 {
+		s := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		if s.offset == len(u.instructions) {
+			if !u.isMain {
+				return &Error{Function: u.index, Offset: s.offset, Message: "function can end without returning"}
+			}
+			continue
+		}
+
+		i := u.starts[s.offset]
+		ins := u.decoded[i]
+		if depths[i] >= 0 {
+			if depths[i] != s.depth {
+				return u.errorf(ins, "stack depth is %d or %d depending on the path", depths[i], s.depth)
+			}
+			continue
+		}
+		depths[i] = s.depth
+
+		pops, pushes := stackEffect(ins)
+		if s.depth < pops {
+			return u.errorf(ins, "stack underflow, %d values needed but %d available", pops, s.depth)
+		}
+		depth := s.depth - pops + pushes
+
+		switch {
+		case ins.op == code.OpReturn, ins.op == code.OpTailCall:
+			continue
+		case ins.op == code.OpJump:
+			pending = append(pending, state{ins.operands[0], depth})
+			continue
+		case code.IsJump(ins.op):
+			pending = append(pending, state{ins.operands[0], depth})
+		}
+		pending = append(pending, state{ins.offset + ins.width, depth})
+	}
+
+	return nil
+}
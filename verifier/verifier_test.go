@@ -0,0 +1,200 @@
+package verifier
+
+import (
+	"errors"
+	"fmt"
+	"gold/code"
+	"gold/compiler"
+	"gold/lexer"
+	"gold/object"
+	"gold/parser"
+	"strings"
+	"testing"
+)
+
+func TestVerifyCompiledPrograms(t *testing.T) {
+	inputs := []string{
+		"1 + 2 * 3; -4; !true",
+		"if (1 > 2) { 10 } else { 20 }; if (false) { 10 }",
+		"let x = 0; while (x < 10) { x++ }; x",
+		`[1, 2, 3][1]; {1: "a", 2: "b"}[1]`,
+		`len("gold"); push([1], 2)`,
+		"let add = fn(mint a, mint b) { return a + b }; add(1, 2)",
+		"let noop = fn() { }; noop()",
+		`may newAdder = fn(mint a, mint b) {
+			may c = a + b;
+			return fn(mint d) {
+				may e = d + c;
+				return fn(mint f) { return e + f; };
+			};
+		};
+		newAdder(1, 2)(3)(8)`,
+		`let countDown = fn(mint x) {
+			if (x == 0) {
+				return 0;
+			} else {
+				return countDown(x - 1);
+			}
+		};
+		countDown(1);`,
+		"may f = fn(mint x) { while (x < 3) { x++ }; if (x > 1) { return x } else { return 0 } }; f(0)",
+		"may g = fn(mint x) { while (x != 0) { x-- }; if (x == 0) { return 1 } else { return 2 } }; g(3)",
+		// Wide constants and jumps
+		strings.Repeat("1;", 40000) + "if (true) {" + strings.Repeat("2;", 30000) + "} else { 3 }",
+	}
+
+	for _, input := range inputs {
+		for _, level := range []int{compiler.OptimizationNone, compiler.OptimizationPeephole} {
+			err := Verify(compile(t, input, level))
+			if err != nil {
+				t.Errorf("compiled program rejected at optimization level %d: %s\n%s", level, err, input)
+			}
+		}
+	}
+}
+
+func TestVerifyErrors(t *testing.T) {
+	fn := func(numLocals int, ins ...[]byte) *object.CompiledFunction {
+		return &object.CompiledFunction{Instructions: concat(ins...), NumLocals: numLocals}
+	}
+
+	tests := []struct {
+		bytecode *compiler.Bytecode
+		expected string
+	}{
+		{
+			&compiler.Bytecode{Instructions: code.Instructions{255}},
+			"invalid bytecode: main at 0000: opcode 255 undefined",
+		},
+		{
+			&compiler.Bytecode{Instructions: code.Make(code.OpConstant, 1)[:2]},
+			"invalid bytecode: main at 0000: operands of OpConstant are truncated",
+		},
+		{
+			&compiler.Bytecode{
+				Instructions: concat(code.Make(code.OpConstant, 1), code.Make(code.OpPop)),
+				Constants:    []object.Object{&object.Integer{Value: 1}},
+			},
+			"invalid bytecode: main at 0000: constant 1 out of range, the pool has 1",
+		},
+		{
+			&compiler.Bytecode{Instructions: concat(code.Make(code.OpJump, 1), code.Make(code.OpNull), code.Make(code.OpPop))},
+			"invalid bytecode: main at 0000: jump target 1 is not the start of an instruction",
+		},
+		{
+			&compiler.Bytecode{Instructions: concat(code.Make(code.OpJump, 40))},
+			"invalid bytecode: main at 0000: jump target 40 is not the start of an instruction",
+		},
+		{
+			&compiler.Bytecode{Instructions: concat(code.Make(code.OpTrue), code.Make(code.OpAdd))},
+			"invalid bytecode: main at 0001: stack underflow, 2 values needed but 1 available",
+		},
+		{
+			&compiler.Bytecode{Instructions: concat(
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpNotTruthy, 5),
+				code.Make(code.OpNull),
+				code.Make(code.OpPop),
+			)},
+			"invalid bytecode: main at 0005: stack depth is 1 or 0 depending on the path",
+		},
+		{
+			&compiler.Bytecode{Instructions: concat(code.Make(code.OpTrue), code.Make(code.OpJumpEqual, 4))},
+			"invalid bytecode: main at 0001: stack underflow, 2 values needed but 1 available",
+		},
+		{
+			&compiler.Bytecode{Instructions: concat(code.Make(code.OpIncLocal, 0))},
+			"invalid bytecode: main at 0000: local 0 out of range, the frame has 0",
+		},
+		{
+			&compiler.Bytecode{Instructions: concat(code.Make(code.OpGetLocal, 0), code.Make(code.OpPop))},
+			"invalid bytecode: main at 0000: local 0 out of range, the frame has 0",
+		},
+		{
+			&compiler.Bytecode{Instructions: concat(code.Make(code.OpGetBuiltin, 200), code.Make(code.OpPop))},
+			fmt.Sprintf("invalid bytecode: main at 0000: builtin 200 out of range, there are %d", len(object.Builtins)),
+		},
+		{
+			&compiler.Bytecode{Instructions: concat(code.MakeWide(code.OpJump, 70000))},
+			"invalid bytecode: main at 0000: jump target 70000 is not the start of an instruction",
+		},
+		{
+			&compiler.Bytecode{Instructions: concat(code.Make(code.OpWide), code.Make(code.OpPop))},
+			"invalid bytecode: main at 0000: OpWide before OpPop, which has no operand",
+		},
+		{
+			&compiler.Bytecode{Instructions: concat(code.Make(code.OpNull), code.Make(code.OpReturn))},
+			"invalid bytecode: main at 0001: return outside of a function",
+		},
+		{
+			&compiler.Bytecode{Instructions: concat(code.Make(code.OpCurrentClosure), code.Make(code.OpTailCall, 0))},
+			"invalid bytecode: main at 0001: tail call outside of a function",
+		},
+		{
+			&compiler.Bytecode{
+				Instructions: concat(code.Make(code.OpConstant, 0), code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+				Constants:    []object.Object{&object.Integer{Value: 1}},
+			},
+			"invalid bytecode: main at 0003: constant 0 is not a function",
+		},
+		{
+			&compiler.Bytecode{
+				Instructions: concat(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+				Constants:    []object.Object{fn(0, code.Make(code.OpGetFree, 0), code.Make(code.OpReturn))},
+			},
+			"invalid bytecode: function 0 at 0000: free variable 0 out of range, the closure has 0",
+		},
+		{
+			&compiler.Bytecode{
+				Instructions: concat(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+				Constants:    []object.Object{fn(1, code.Make(code.OpGetLocal, 0), code.Make(code.OpPop))},
+			},
+			"invalid bytecode: function 0 at 0003: function can end without returning",
+		},
+		{
+			&compiler.Bytecode{
+				Instructions: concat(code.Make(code.OpClosure, 0, 0), code.Make(code.OpPop)),
+				Constants:    []object.Object{fn(1, code.Make(code.OpPop), code.Make(code.OpNull), code.Make(code.OpReturn))},
+			},
+			"invalid bytecode: function 0 at 0000: stack underflow, 1 values needed but 0 available",
+		},
+	}
+
+	for _, tt := range tests {
+		err := Verify(tt.bytecode)
+		var verifyErr *Error
+		if !errors.As(err, &verifyErr) {
+			t.Errorf("expected an *Error, got=%v\n%s", err, tt.bytecode.Instructions)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("wrong error.\nwant=%q\ngot =%q", tt.expected, err.Error())
+		}
+	}
+}
+
+func compile(t *testing.T, input string, level int) *compiler.Bytecode {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parse errors: %v", p.Errors())
+	}
+
+	comp := compiler.New()
+	comp.SetOptimization(level)
+	_, err := comp.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return comp.Bytecode()
+}
+
+func concat(ins ...[]byte) code.Instructions {
+	out := code.Instructions{}
+	for _, i := range ins {
+		out = append(out, i...)
+	}
+	return out
+}
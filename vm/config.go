@@ -0,0 +1,81 @@
+package vm
+
+import (
+	"fmt"
+	"gold/object"
+	"io"
+	"os"
+)
+
+// Config sets the resources a program may use, for hosts running code they
+// don't trust. Fields left to zero take their default.
+type Config struct {
+	// StackSize is the number of values the stack can hold, locals of every
+	// frame included. Defaults to StackSize.
+	StackSize int
+	// MaxFrames is the number of nested calls. Defaults to MaxFrames.
+	MaxFrames int
+	// MaxInstructions stops the program after this many instructions. There
+	// is no limit by default.
+	MaxInstructions int
+	// MaxAllocation is the largest array, hash or string the program may
+	// build, in elements, pairs or bytes. The builtins whose result can
+	// outgrow their arguments, like repeat or read_file, check it before
+	// allocating; the results of the others are checked once built. There
+	// is no limit by default.
+	MaxAllocation int
+	// Builtins are the functions the bytecode was compiled with, see
+	// compiler.NewWithBuiltins. Defaults to object.Builtins.
+	Builtins []object.BuiltinDefinition
+	// Args are the command-line arguments of the program, the ones following
+	// its file name, returned by args.
+	Args []string
+	// Getenv looks up the environment variables read by env, os.LookupEnv to
+	// give access to the ones of the process. Without it, the program sees
+	// no variable.
+	Getenv func(name string) (string, bool)
+	// Files restricts the files read and written by the file builtins.
+	// Without it, the program can't access any file.
+	Files *object.FilePolicy
+	// Stdin, Stdout and Stderr are the standard streams of the program,
+	// read by read_line and written by print. Default to the ones of the
+	// process.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+func (c Config) withDefaults() Config {
+	if c.StackSize <= 0 {
+		c.StackSize = StackSize
+	}
+	if c.MaxFrames <= 0 {
+		c.MaxFrames = MaxFrames
+	}
+	if c.Builtins == nil {
+		c.Builtins = object.Builtins
+	}
+	if c.Stdin == nil {
+		c.Stdin = os.Stdin
+	}
+	if c.Stdout == nil {
+		c.Stdout = os.Stdout
+	}
+	if c.Stderr == nil {
+		c.Stderr = os.Stderr
+	}
+	return c
+}
+
+// LimitError is returned when a program goes over the instruction or the
+// allocation limit of its Config. Going over the stack or the frames is a
+// *StackOverflowError.
+type LimitError struct {
+	// Limit is the name of the Config field.
+	Limit string
+	Value int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("limit exceeded: %s is %d", e.Limit, e.Value)
+}
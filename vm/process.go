@@ -0,0 +1,87 @@
+package vm
+
+import (
+	"bufio"
+	"fmt"
+	"gold/object"
+	"io"
+)
+
+// exitError unwinds the calls of a program calling exit, up to RunContext.
+type exitError struct {
+	code int
+}
+
+func (e *exitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+// Args returns the command-line arguments of Config.
+func (vm *VM) Args() []string {
+	return vm.config.Args
+}
+
+// Getenv looks up name with the Getenv of Config.
+func (vm *VM) Getenv(name string) (string, bool) {
+	if vm.config.Getenv == nil {
+		return "", false
+	}
+	return vm.config.Getenv(name)
+}
+
+// Exit returns the error stopping the program with code, see ExitCode.
+func (vm *VM) Exit(code int) error {
+	return &exitError{code: code}
+}
+
+// ExitCode returns the status the program gave to exit, and false when the
+// program ended without calling it.
+func (vm *VM) ExitCode() (int, bool) {
+	return vm.exitCode, vm.exited
+}
+
+// Files returns the files the program may access, within the Files policy
+// of Config.
+func (vm *VM) Files() *object.Files {
+	return vm.files
+}
+
+// SetLastError records the error of a builtin that failed.
+func (vm *VM) SetLastError(err error) {
+	vm.lastError = err
+}
+
+// LastError returns the error of the last builtin that failed.
+func (vm *VM) LastError() error {
+	return vm.lastError
+}
+
+// Allocate fails with a LimitError when a value of size elements, pairs or
+// bytes is over the MaxAllocation of Config.
+func (vm *VM) Allocate(size int) error {
+	return vm.checkAllocation(size)
+}
+
+// Stdin returns the standard input of Config, buffered so the lines read
+// one by one don't lose the rest of the input. A *bufio.Reader is used as
+// is, so that several VMs can share it.
+func (vm *VM) Stdin() *bufio.Reader {
+	if vm.stdin == nil {
+		reader, ok := vm.config.Stdin.(*bufio.Reader)
+		if !ok {
+			reader = bufio.NewReader(vm.config.Stdin)
+		}
+		vm.stdin = reader
+	}
+	return vm.stdin
+}
+
+// Stdout returns the standard output of Config.
+func (vm *VM) Stdout() io.Writer {
+	return vm.config.Stdout
+}
+
+// Stderr returns the standard error of Config.
+func (vm *VM) Stderr() io.Writer {
+	return vm.config.Stderr
+}
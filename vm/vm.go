@@ -1,12 +1,18 @@
 package vm
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"gold/code"
 	"gold/compiler"
 	"gold/object"
+	"math"
+	"strings"
 )
 
+// Defaults of Config. GlobalsSize is the initial size of the globals store.
 const (
 	StackSize   = 2048
 	GlobalsSize = 65536
@@ -14,11 +20,34 @@ const (
 )
 
 var (
-	True  = &object.Boolean{Value: true}
-	False = &object.Boolean{Value: false}
-	Null  = &object.Null{}
+	True  = object.TRUE
+	False = object.FALSE
+	Null  = object.NULL
 )
 
+// Integers between minCachedInteger and maxCachedInteger are shared, so
+// counters and small results don't allocate. Integers are never modified in
+// place and compared by value, which makes sharing them safe.
+const (
+	minCachedInteger = -128
+	maxCachedInteger = 1023
+)
+
+var cachedIntegers = func() []*object.Integer {
+	integers := make([]*object.Integer, maxCachedInteger-minCachedInteger+1)
+	for i := range integers {
+		integers[i] = &object.Integer{Value: int64(i + minCachedInteger)}
+	}
+	return integers
+}()
+
+func newInteger(value int64) *object.Integer {
+	if value >= minCachedInteger && value <= maxCachedInteger {
+		return cachedIntegers[value-minCachedInteger]
+	}
+	return &object.Integer{Value: value}
+}
+
 type VM struct {
 	constants []object.Object
 	stack     []object.Object
@@ -27,56 +56,128 @@ type VM struct {
 	frames      []*Frame
 	sp          int // Stack pointer. Always points to the next value. Top of stack is stack[sp-1]
 	framesIndex int
+
+	config   Config
+	executed int // number of instructions run so far
+	ctx      context.Context
+
+	exited    bool
+	exitCode  int
+	files     *object.Files
+	stdin     *bufio.Reader
+	lastError error
 }
 
 func New(bytecode *compiler.Bytecode) *VM {
+	return NewWithConfig(bytecode, Config{})
+}
+
+// NewWithConfig creates a VM running bytecode within the limits of config.
+func NewWithConfig(bytecode *compiler.Bytecode, config Config) *VM {
+	config = config.withDefaults()
+
 	mainFn := &object.CompiledFunction{Instructions: bytecode.Instructions}
 	mainClosure := &object.Closure{Fn: mainFn}
 	mainFrame := NewFrame(mainClosure, 0)
 
-	frames := make([]*Frame, MaxFrames)
+	frames := make([]*Frame, config.MaxFrames)
 	frames[0] = mainFrame
 
 	return &VM{
 		constants: bytecode.Constants,
 
-		stack: make([]object.Object, StackSize),
+		stack: make([]object.Object, config.StackSize),
 		sp:    0,
 
 		globals: make([]object.Object, GlobalsSize),
 
 		frames:      frames,
 		framesIndex: 1,
+
+		config: config,
+		files:  object.NewFiles(config.Files),
 	}
 }
 
 // used in the repl to keep the stored value
-func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
-	vm := New(bytecode)
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object, config Config) *VM {
+	vm := NewWithConfig(bytecode, config)
 	vm.globals = s
 	return vm
 }
 
+// Globals returns the globals store, which grows past GlobalsSize when a
+// program has that many globals.
+func (vm *VM) Globals() []object.Object {
+	return vm.globals
+}
+
 func (vm *VM) LastPoppedStackElem() object.Object {
 	return vm.stack[vm.sp]
 }
 
 func (vm *VM) Run() error {
+	return vm.RunContext(context.Background())
+}
+
+// contextCheckInterval is the number of instructions run between two checks
+// of the context, which are too slow to do on every instruction.
+const contextCheckInterval = 1024
+
+// RunContext runs the program until it ends, fails, or ctx is done. In the
+// last case, the error is the one of the context. A program calling exit
+// ends without error, see ExitCode.
+func (vm *VM) RunContext(ctx context.Context) error {
+	vm.ctx = ctx
+	err := vm.run(0)
+	var exit *exitError
+	if errors.As(err, &exit) {
+		vm.exited = true
+		vm.exitCode = exit.code
+		return nil
+	}
+	return err
+}
+
+// run runs the instructions until the main function ends or, when the VM is
+// called back by a builtin, until the frames are back to stop.
+func (vm *VM) run(stop int) error {
 	var ip int
 	var ins code.Instructions
 	var op code.Opcode
 
-	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
-		vm.currentFrame().ip++
+	done := vm.ctx.Done()
+	maxInstructions := vm.config.MaxInstructions
+
+	for vm.framesIndex != stop {
+		// The frame only changes on calls and returns, but reading it again
+		// is cheaper than tracking them.
+		frame := vm.currentFrame()
+		ins = frame.Instructions()
+		if frame.ip >= len(ins)-1 {
+			break
+		}
+		frame.ip++
 
-		ip = vm.currentFrame().ip
-		ins = vm.currentFrame().Instructions()
+		vm.executed++
+		if maxInstructions > 0 && vm.executed > maxInstructions {
+			return &LimitError{Limit: "MaxInstructions", Value: maxInstructions}
+		}
+		if done != nil && vm.executed%contextCheckInterval == 0 {
+			select {
+			case <-done:
+				return vm.ctx.Err()
+			default:
+			}
+		}
+
+		ip = frame.ip
 		op = code.Opcode(ins[ip])
 
 		switch op {
 		case code.OpConstant:
 			constIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
+			frame.ip += 2
 
 			err := vm.push(vm.constants[constIndex])
 			if err != nil {
@@ -130,15 +231,15 @@ func (vm *VM) Run() error {
 
 		case code.OpJump:
 			pos := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip = pos - 1
+			frame.ip = pos - 1
 
 		case code.OpJumpNotTruthy:
 			pos := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
+			frame.ip += 2
 
 			condition := vm.pop()
 			if !isTruthy(condition) {
-				vm.currentFrame().ip = pos - 1
+				frame.ip = pos - 1
 			}
 
 		case code.OpNull:
@@ -149,13 +250,13 @@ func (vm *VM) Run() error {
 
 		case code.OpSetGlobal:
 			globalIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
+			frame.ip += 2
 
 			vm.globals[globalIndex] = vm.pop()
 
 		case code.OpGetGlobal:
 			globalIndex := code.ReadUint16(ins[ip+1:])
-			vm.currentFrame().ip += 2
+			frame.ip += 2
 
 			err := vm.push(vm.globals[globalIndex])
 			if err != nil {
@@ -164,19 +265,22 @@ func (vm *VM) Run() error {
 
 		case code.OpArray:
 			numElements := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
+			frame.ip += 2
 
-			array := vm.buildArray(vm.sp-numElements, vm.sp)
+			array, err := vm.buildArray(vm.sp-numElements, vm.sp)
+			if err != nil {
+				return err
+			}
 			vm.sp = vm.sp - numElements
 
-			err := vm.push(array)
+			err = vm.push(array)
 			if err != nil {
 				return err
 			}
 
 		case code.OpHash:
 			numElements := int(code.ReadUint16(ins[ip+1:]))
-			vm.currentFrame().ip += 2
+			frame.ip += 2
 
 			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
 			if err != nil {
@@ -200,17 +304,26 @@ func (vm *VM) Run() error {
 
 		case code.OpCall:
 			numArgs := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+			frame.ip += 1
 
 			err := vm.executeCall(int(numArgs))
 			if err != nil {
 				return err
 			}
 
+		case code.OpTailCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			frame.ip += 1
+
+			err := vm.executeTailCall(int(numArgs))
+			if err != nil {
+				return err
+			}
+
 		case code.OpReturn:
 			returnValue := vm.pop()
 
-			frame := vm.popFrame()
+			vm.popFrame()
 			vm.sp = frame.basePointer - 1
 
 			err := vm.push(returnValue)
@@ -220,17 +333,13 @@ func (vm *VM) Run() error {
 
 		case code.OpSetLocal:
 			localIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
-
-			frame := vm.currentFrame()
+			frame.ip += 1
 
 			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
 
 		case code.OpGetLocal:
 			localIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
-
-			frame := vm.currentFrame()
+			frame.ip += 1
 
 			err := vm.push(vm.stack[frame.basePointer+int(localIndex)])
 			if err != nil {
@@ -239,9 +348,9 @@ func (vm *VM) Run() error {
 
 		case code.OpGetBuiltin:
 			builtinIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+			frame.ip += 1
 
-			definition := object.Builtins[builtinIndex]
+			definition := vm.config.Builtins[builtinIndex]
 
 			err := vm.push(definition.Builtin)
 			if err != nil {
@@ -251,7 +360,7 @@ func (vm *VM) Run() error {
 		case code.OpClosure:
 			constIndex := code.ReadUint16(ins[ip+1:])
 			numFree := code.ReadUint8(ins[ip+3:])
-			vm.currentFrame().ip += 3
+			frame.ip += 3
 
 			err := vm.pushClosure(int(constIndex), int(numFree))
 			if err != nil {
@@ -260,29 +369,180 @@ func (vm *VM) Run() error {
 
 		case code.OpGetFree:
 			freeIndex := code.ReadUint8(ins[ip+1:])
-			vm.currentFrame().ip += 1
+			frame.ip += 1
 
-			currentClosure := vm.currentFrame().cl
+			currentClosure := frame.cl
 			err := vm.push(currentClosure.Free[freeIndex])
 			if err != nil {
 				return err
 			}
 
 		case code.OpCurrentClosure:
-			currentClosure := vm.currentFrame().cl
+			currentClosure := frame.cl
 			err := vm.push(currentClosure)
 			if err != nil {
 				return err
 			}
+
+		case code.OpIncGlobal, code.OpDecGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			frame.ip += 2
+
+			value, err := incremented(vm.globals[globalIndex], op == code.OpIncGlobal)
+			if err != nil {
+				return err
+			}
+			vm.globals[globalIndex] = value
+
+		case code.OpIncLocal, code.OpDecLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			frame.ip += 1
+
+			slot := frame.basePointer + int(localIndex)
+			value, err := incremented(vm.stack[slot], op == code.OpIncLocal)
+			if err != nil {
+				return err
+			}
+			vm.stack[slot] = value
+
+		case code.OpJumpNotGreaterThan, code.OpJumpNotGreaterEqualThan, code.OpJumpNotEqual, code.OpJumpEqual:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			frame.ip += 2
+
+			right := vm.pop()
+			left := vm.pop()
+			result, err := compare(fusedComparison(op), left, right)
+			if err != nil {
+				return err
+			}
+			if !result {
+				frame.ip = pos - 1
+			}
+
+		case code.OpWide:
+			err := vm.executeWide(ins, ip)
+			if err != nil {
+				return err
+			}
 		}
 	}
 
 	return nil
 }
 
+// executeWide runs an instruction prefixed by OpWide, whose operands are
+// twice as wide. It is rare enough not to be inlined in Run.
+func (vm *VM) executeWide(ins code.Instructions, ip int) error {
+	op := code.Opcode(ins[ip+1])
+	def, err := code.Lookup(byte(op))
+	if err != nil {
+		return err
+	}
+	operands, read := code.ReadOperandsWide(def, ins[ip+2:])
+	vm.currentFrame().ip += 1 + read
+
+	switch op {
+	case code.OpConstant:
+		return vm.push(vm.constants[operands[0]])
+
+	case code.OpJump:
+		vm.currentFrame().ip = operands[0] - 1
+
+	case code.OpJumpNotTruthy:
+		condition := vm.pop()
+		if !isTruthy(condition) {
+			vm.currentFrame().ip = operands[0] - 1
+		}
+
+	case code.OpSetGlobal:
+		index := operands[0]
+		if index >= len(vm.globals) {
+			vm.globals = append(vm.globals, make([]object.Object, index+1-len(vm.globals))...)
+		}
+		vm.globals[index] = vm.pop()
+
+	case code.OpGetGlobal:
+		if operands[0] >= len(vm.globals) {
+			return fmt.Errorf("global %d is not defined", operands[0])
+		}
+		return vm.push(vm.globals[operands[0]])
+
+	case code.OpArray:
+		array, err := vm.buildArray(vm.sp-operands[0], vm.sp)
+		if err != nil {
+			return err
+		}
+		vm.sp = vm.sp - operands[0]
+		return vm.push(array)
+
+	case code.OpHash:
+		hash, err := vm.buildHash(vm.sp-operands[0], vm.sp)
+		if err != nil {
+			return err
+		}
+		vm.sp = vm.sp - operands[0]
+		return vm.push(hash)
+
+	case code.OpCall:
+		return vm.executeCall(operands[0])
+
+	case code.OpTailCall:
+		return vm.executeTailCall(operands[0])
+
+	case code.OpSetLocal:
+		vm.stack[vm.currentFrame().basePointer+operands[0]] = vm.pop()
+
+	case code.OpGetLocal:
+		return vm.push(vm.stack[vm.currentFrame().basePointer+operands[0]])
+
+	case code.OpGetBuiltin:
+		return vm.push(vm.config.Builtins[operands[0]].Builtin)
+
+	case code.OpClosure:
+		return vm.pushClosure(operands[0], operands[1])
+
+	case code.OpGetFree:
+		return vm.push(vm.currentFrame().cl.Free[operands[0]])
+
+	case code.OpIncGlobal, code.OpDecGlobal:
+		if operands[0] >= len(vm.globals) {
+			return fmt.Errorf("global %d is not defined", operands[0])
+		}
+		value, err := incremented(vm.globals[operands[0]], op == code.OpIncGlobal)
+		if err != nil {
+			return err
+		}
+		vm.globals[operands[0]] = value
+
+	case code.OpIncLocal, code.OpDecLocal:
+		slot := vm.currentFrame().basePointer + operands[0]
+		value, err := incremented(vm.stack[slot], op == code.OpIncLocal)
+		if err != nil {
+			return err
+		}
+		vm.stack[slot] = value
+
+	case code.OpJumpNotGreaterThan, code.OpJumpNotGreaterEqualThan, code.OpJumpNotEqual, code.OpJumpEqual:
+		right := vm.pop()
+		left := vm.pop()
+		result, err := compare(fusedComparison(op), left, right)
+		if err != nil {
+			return err
+		}
+		if !result {
+			vm.currentFrame().ip = operands[0] - 1
+		}
+
+	default:
+		return fmt.Errorf("%s has no wide form", def.Name)
+	}
+
+	return nil
+}
+
 func (vm *VM) push(o object.Object) error {
-	if vm.sp >= StackSize {
-		return fmt.Errorf("stack overflow")
+	if vm.sp >= len(vm.stack) {
+		return vm.stackOverflow()
 	}
 
 	vm.stack[vm.sp] = o
@@ -301,17 +561,27 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
 
-	leftType := left.Type()
-	rightType := right.Type()
-
-	if leftType == object.INTEGER_OBJ && rightType == object.INTEGER_OBJ {
-		value, err := executeBinaryNumberOperation[int64](op, left.(*object.Integer).Value, right.(*object.Integer).Value)
-		if err != nil {
-			return err
+	// Most operations are on integers, checked first without going through
+	// the types.
+	if left, ok := left.(*object.Integer); ok {
+		if right, ok := right.(*object.Integer); ok {
+			if op == code.OpDiv {
+				err := checkIntegerDivision(left.Value, right.Value)
+				if err != nil {
+					return err
+				}
+			}
+			value, err := executeBinaryNumberOperation[int64](op, left.Value, right.Value)
+			if err != nil {
+				return err
+			}
+			return vm.push(newInteger(value))
 		}
-		return vm.push(&object.Integer{Value: value})
 	}
 
+	leftType := left.Type()
+	rightType := right.Type()
+
 	leftIsNumber := leftType == object.INTEGER_OBJ || leftType == object.FLOAT_OBJ
 	rightIsNumber := rightType == object.INTEGER_OBJ || rightType == object.FLOAT_OBJ
 	if leftIsNumber && rightIsNumber {
@@ -341,24 +611,42 @@ func (vm *VM) executeBinaryOperation(op code.Opcode) error {
 }
 
 func (vm *VM) executeIncDecOperation(op code.Opcode) error {
-	var increaseValue int
-	if op == code.OpInc {
-		increaseValue = 1
-	} else {
-		increaseValue = -1
+	value, err := incremented(vm.pop(), op == code.OpInc)
+	if err != nil {
+		return err
+	}
+	return vm.push(value)
+}
+
+// incremented returns value plus one, or minus one when increment is false.
+func incremented(value object.Object, increment bool) (object.Object, error) {
+	delta := int64(-1)
+	if increment {
+		delta = 1
 	}
 
-	value := vm.pop()
 	switch value := value.(type) {
 	case *object.Integer:
-		return vm.push(&object.Integer{Value: value.Value + int64(increaseValue)})
+		return newInteger(value.Value + delta), nil
 	case *object.Float:
-		return vm.push(&object.Float{Value: value.Value + float64(increaseValue)})
+		return &object.Float{Value: value.Value + float64(delta)}, nil
 	default:
-		return fmt.Errorf("unsupported type for inc/dec: %s", value.Type())
+		return nil, fmt.Errorf("unsupported type for inc/dec: %s", value.Type())
 	}
 }
 
+// checkIntegerDivision fails for the integer divisions without a result:
+// by zero, and of the smallest integer by -1, whose result is too large.
+func checkIntegerDivision(left, right int64) error {
+	switch {
+	case right == 0:
+		return errors.New("integer division by zero")
+	case right == -1 && left == math.MinInt64:
+		return fmt.Errorf("integer overflow: %d / -1", left)
+	}
+	return nil
+}
+
 func executeBinaryNumberOperation[N int64 | float64](op code.Opcode, leftValue, rightValue N) (N, error) {
 	var result N
 
@@ -382,12 +670,41 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
 
-	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
-		value, err := executeNumberComparison[int64](op, left.(*object.Integer).Value, right.(*object.Integer).Value)
-		if err != nil {
-			return err
+	result, err := compare(op, left, right)
+	if err != nil {
+		return err
+	}
+	return vm.push(nativeBoolToBooleanObject(result))
+}
+
+// fusedComparison returns the comparison done by a superinstruction that
+// compares and jumps.
+func fusedComparison(op code.Opcode) code.Opcode {
+	switch op {
+	case code.OpJumpNotGreaterThan:
+		return code.OpGreaterThan
+	case code.OpJumpNotGreaterEqualThan:
+		return code.OpGreaterEqualThan
+	case code.OpJumpNotEqual:
+		return code.OpEqual
+	default:
+		return code.OpNotEqual
+	}
+}
+
+func compare(op code.Opcode, left, right object.Object) (bool, error) {
+	if left, ok := left.(*object.Integer); ok {
+		if right, ok := right.(*object.Integer); ok {
+			return executeNumberComparison[int64](op, left.Value, right.Value)
 		}
-		return vm.push(value)
+	}
+
+	// Equality is the one of hash keys, exact between integers and floats
+	switch op {
+	case code.OpEqual:
+		return object.Equal(left, right), nil
+	case code.OpNotEqual:
+		return !object.Equal(left, right), nil
 	}
 
 	leftIsNumber := left.Type() == object.INTEGER_OBJ || left.Type() == object.FLOAT_OBJ
@@ -395,47 +712,35 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 	if leftIsNumber && rightIsNumber {
 		leftValue, err := getFloat64(left)
 		if err != nil {
-			return err
+			return false, err
 		}
 		rightValue, err := getFloat64(right)
 		if err != nil {
-			return err
+			return false, err
 		}
 
-		value, err := executeNumberComparison[float64](op, leftValue, rightValue)
-		if err != nil {
-			return err
-		}
-		return vm.push(value)
+		return executeNumberComparison[float64](op, leftValue, rightValue)
 	}
 
-	switch op {
-	case code.OpEqual:
-		return vm.push(nativeBoolToBooleanObject(right == left))
-	case code.OpNotEqual:
-		return vm.push(nativeBoolToBooleanObject(right != left))
-	default:
-		return fmt.Errorf("unknown operator : %d (%s %s)",
-			op, left.Type(), right.Type())
-	}
+	return false, fmt.Errorf("unknown operator : %d (%s %s)",
+		op, left.Type(), right.Type())
 }
 
-// Cannot be a method since methods don't accept type parameter
 func executeNumberComparison[C int64 | float64](
 	op code.Opcode,
 	left, right C,
-) (*object.Boolean, error) {
+) (bool, error) {
 	switch op {
 	case code.OpEqual:
-		return nativeBoolToBooleanObject(right == left), nil
+		return right == left, nil
 	case code.OpNotEqual:
-		return nativeBoolToBooleanObject(right != left), nil
+		return right != left, nil
 	case code.OpGreaterThan:
-		return nativeBoolToBooleanObject(left > right), nil
+		return left > right, nil
 	case code.OpGreaterEqualThan:
-		return nativeBoolToBooleanObject(left >= right), nil
+		return left >= right, nil
 	default:
-		return nil, fmt.Errorf("unknown operator: %d", op)
+		return false, fmt.Errorf("unknown operator: %d", op)
 	}
 }
 
@@ -454,7 +759,7 @@ func (vm *VM) executeMinusOperator() error {
 	switch operand.Type() {
 	case object.INTEGER_OBJ:
 		value := operand.(*object.Integer).Value
-		return vm.push(&object.Integer{Value: -value})
+		return vm.push(newInteger(-value))
 	case object.FLOAT_OBJ:
 		value := operand.(*object.Float).Value
 		return vm.push(&object.Float{Value: -value})
@@ -474,43 +779,76 @@ func (vm *VM) executeBinaryStringOperation(
 	leftValue := left.(*object.String).Value
 	rightValue := right.(*object.String).Value
 
+	err := vm.checkAllocation(len(leftValue) + len(rightValue))
+	if err != nil {
+		return err
+	}
 	return vm.push(&object.String{Value: leftValue + rightValue})
 }
 
-func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
+// checkAllocation fails when an object of size elements, pairs or bytes is
+// over the allocation limit.
+func (vm *VM) checkAllocation(size int) error {
+	if vm.config.MaxAllocation > 0 && size > vm.config.MaxAllocation {
+		return &LimitError{Limit: "MaxAllocation", Value: vm.config.MaxAllocation}
+	}
+	return nil
+}
+
+func sizeOf(obj object.Object) int {
+	switch obj := obj.(type) {
+	case *object.Array:
+		return len(obj.Elements)
+	case *object.Tuple:
+		return len(obj.Elements)
+	case *object.Hash:
+		return obj.Len()
+	case *object.String:
+		return len(obj.Value)
+	}
+	return 0
+}
+
+func (vm *VM) buildArray(startIndex, endIndex int) (object.Object, error) {
+	err := vm.checkAllocation(endIndex - startIndex)
+	if err != nil {
+		return nil, err
+	}
 	elements := make([]object.Object, endIndex-startIndex)
 
 	for i := startIndex; i < endIndex; i++ {
 		elements[i-startIndex] = vm.stack[i]
 	}
 
-	return &object.Array{Elements: elements}
+	return &object.Array{Elements: elements}, nil
 }
 
 func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
-	hashedPairs := make(map[object.HashKey]object.HashPair)
+	err := vm.checkAllocation((endIndex - startIndex) / 2)
+	if err != nil {
+		return nil, err
+	}
+	hash := object.NewHash((endIndex - startIndex) / 2)
 
 	for i := startIndex; i < endIndex; i += 2 {
 		key := vm.stack[i]
 		value := vm.stack[i+1]
 
-		pair := object.HashPair{Key: key, Value: value}
-
-		hashKey, ok := key.(object.Hashable)
-		if !ok {
-			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+		err := hash.Set(key, value)
+		if err != nil {
+			return nil, err
 		}
-
-		hashedPairs[hashKey.HashKey()] = pair
 	}
 
-	return &object.Hash{Pairs: hashedPairs}, nil
+	return hash, nil
 }
 
 func (vm *VM) executeIndexExpression(left, index object.Object) error {
 	switch {
 	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
-		return vm.executeArrayIndex(left, index)
+		return vm.executeArrayIndex(left.(*object.Array).Elements, index)
+	case left.Type() == object.TUPLE_OBJ && index.Type() == object.INTEGER_OBJ:
+		return vm.executeArrayIndex(left.(*object.Tuple).Elements, index)
 	case left.Type() == object.HASH_OBJ:
 		return vm.executeHashIndex(left, index)
 	default:
@@ -518,41 +856,88 @@ func (vm *VM) executeIndexExpression(left, index object.Object) error {
 	}
 }
 
-func (vm *VM) executeArrayIndex(array, index object.Object) error {
-	arrayObject := array.(*object.Array)
+func (vm *VM) executeArrayIndex(elements []object.Object, index object.Object) error {
 	i := index.(*object.Integer).Value
-	max := int64(len(arrayObject.Elements) - 1)
+	max := int64(len(elements) - 1)
 
 	if i < 0 || i > max {
 		return vm.push(Null)
 	}
 
-	return vm.push(arrayObject.Elements[i])
+	return vm.push(elements[i])
 }
 
 func (vm *VM) executeHashIndex(hash, index object.Object) error {
 	hashObject := hash.(*object.Hash)
 
-	key, ok := index.(object.Hashable)
-	if !ok {
-		return fmt.Errorf("unusable as hash key: %s", index.Type())
+	value, ok, err := hashObject.Get(index)
+	if err != nil {
+		return err
 	}
-
-	pair, ok := hashObject.Pairs[key.HashKey()]
 	if !ok {
 		return vm.push(Null)
 	}
 
-	return vm.push(pair.Value)
+	return vm.push(value)
+}
+
+// StackOverflowError is returned when the calls are nested too deep, or when
+// the stack has no room left for a value.
+type StackOverflowError struct {
+	// Trace lists the names of the functions being run, innermost first.
+	Trace []string
+}
+
+func (e *StackOverflowError) Error() string {
+	var out strings.Builder
+	out.WriteString("stack overflow")
+
+	// Recursive calls are listed once, with their count
+	for i := 0; i < len(e.Trace); {
+		j := i + 1
+		for j < len(e.Trace) && e.Trace[j] == e.Trace[i] {
+			j++
+		}
+		fmt.Fprintf(&out, "\n\tin %s", e.Trace[i])
+		if j-i > 1 {
+			fmt.Fprintf(&out, " (%d calls)", j-i)
+		}
+		i = j
+	}
+	return out.String()
+}
+
+func (vm *VM) stackOverflow() error {
+	return &StackOverflowError{Trace: vm.trace()}
+}
+
+// trace returns the names of the functions of the frames, innermost first.
+func (vm *VM) trace() []string {
+	trace := make([]string, 0, vm.framesIndex)
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		switch name := vm.frames[i].cl.Fn.Name; {
+		case i == 0:
+			trace = append(trace, "<main>")
+		case name == "":
+			trace = append(trace, "<anonymous>")
+		default:
+			trace = append(trace, name)
+		}
+	}
+	return trace
 }
 
 func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.framesIndex-1]
 }
 
-func (vm *VM) pushFrame(f *Frame) {
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.framesIndex >= len(vm.frames) {
+		return vm.stackOverflow()
+	}
 	vm.frames[vm.framesIndex] = f
 	vm.framesIndex++
+	return nil
 }
 
 func (vm *VM) popFrame() *Frame {
@@ -572,6 +957,40 @@ func (vm *VM) executeCall(numArgs int) error {
 	}
 }
 
+// Call runs fn with args on top of the stack and returns its result. It
+// lets builtins call back the functions of the program, and is only valid
+// while the VM runs.
+func (vm *VM) Call(fn object.Object, args ...object.Object) (object.Object, error) {
+	err := vm.push(fn)
+	if err != nil {
+		return nil, err
+	}
+	for _, arg := range args {
+		err := vm.push(arg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch fn := fn.(type) {
+	case *object.Closure:
+		stop := vm.framesIndex
+		err = vm.callClosure(fn, len(args))
+		if err != nil {
+			return nil, err
+		}
+		err = vm.run(stop)
+	case *object.Builtin:
+		err = vm.callBuiltin(fn, len(args))
+	default:
+		err = fmt.Errorf("calling non-closure and non-builtin")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return vm.pop(), nil
+}
+
 func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 	if numArgs != cl.Fn.NumParameters {
 		return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
@@ -579,19 +998,79 @@ func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
 	}
 
 	frame := NewFrame(cl, vm.sp-numArgs)
-	vm.pushFrame(frame)
+	if frame.basePointer+cl.Fn.NumLocals >= len(vm.stack) {
+		return vm.stackOverflow()
+	}
+	err := vm.pushFrame(frame)
+	if err != nil {
+		return err
+	}
 
 	vm.sp = frame.basePointer + cl.Fn.NumLocals
 
 	return nil
 }
 
+// executeTailCall calls a closure in the frame of the current function,
+// which returns what the closure returns. The arguments replace the locals,
+// and the callee the current closure.
+func (vm *VM) executeTailCall(numArgs int) error {
+	cl, ok := vm.stack[vm.sp-1-numArgs].(*object.Closure)
+	if !ok {
+		// Builtins don't have a frame, they are called and returned from
+		err := vm.executeCall(numArgs)
+		if err != nil {
+			return err
+		}
+		returnValue := vm.pop()
+		frame := vm.popFrame()
+		vm.sp = frame.basePointer - 1
+		return vm.push(returnValue)
+	}
+
+	if numArgs != cl.Fn.NumParameters {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d",
+			cl.Fn.NumParameters, numArgs)
+	}
+
+	frame := vm.currentFrame()
+	base := frame.basePointer
+	if base+cl.Fn.NumLocals >= len(vm.stack) {
+		return vm.stackOverflow()
+	}
+
+	vm.stack[base-1] = cl
+	copy(vm.stack[base:], vm.stack[vm.sp-numArgs:vm.sp])
+	clear(vm.stack[base+numArgs : base+cl.Fn.NumLocals])
+
+	frame.cl = cl
+	frame.ip = -1
+	vm.sp = base + cl.Fn.NumLocals
+
+	return nil
+}
+
 func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
 	args := vm.stack[vm.sp-numArgs : vm.sp]
 
-	result := builtin.Fn(args...)
+	var result object.Object
+	if builtin.RuntimeFn != nil {
+		// The callbacks run above the arguments, which stay on the stack
+		var err error
+		result, err = builtin.RuntimeFn(vm, args...)
+		if err != nil {
+			return err
+		}
+	} else {
+		result = builtin.Fn(args...)
+	}
 	vm.sp = vm.sp - numArgs - 1
 
+	err := vm.checkAllocation(sizeOf(result))
+	if err != nil {
+		return err
+	}
+
 	if result != nil {
 		err := vm.push(result)
 		if err != nil {
@@ -0,0 +1,63 @@
+package vm
+
+import (
+	"fmt"
+	"gold/compiler"
+	"testing"
+)
+
+var benchmarks = []struct {
+	name  string
+	input string
+}{
+	{
+		"GlobalLoop",
+		"let x = 0; let sum = 0; while (x++ < 100000) { sum = sum + x }; sum",
+	},
+	{
+		"LocalLoop",
+		`may loop = fn(mint n) {
+			mint i = 0;
+			mint sum = 0;
+			while (i < n) { sum = sum + i * 2; i++ };
+			return sum;
+		};
+		loop(100000)`,
+	},
+	{
+		"Fibonacci",
+		`may fibonacci = fn(mint x) {
+			if (x < 2) {
+				return x;
+			} else {
+				return fibonacci(x - 1) + fibonacci(x - 2);
+			}
+		};
+		fibonacci(22)`,
+	},
+}
+
+func BenchmarkRun(b *testing.B) {
+	levels := []int{compiler.OptimizationNone, compiler.OptimizationPeephole}
+	for _, bench := range benchmarks {
+		for _, level := range levels {
+			comp := compiler.New()
+			comp.SetOptimization(level)
+			_, err := comp.Compile(parse(bench.input))
+			if err != nil {
+				b.Fatalf("compiler error: %s", err)
+			}
+			bytecode := comp.Bytecode()
+
+			b.Run(fmt.Sprintf("%s/O%d", bench.name, level), func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					err := New(bytecode).Run()
+					if err != nil {
+						b.Fatalf("vm error: %s", err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -1,13 +1,19 @@
 package vm
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"gold/ast"
 	"gold/compiler"
 	"gold/lexer"
 	"gold/object"
 	"gold/parser"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestIntegerArithmetic(t *testing.T) {
@@ -129,6 +135,19 @@ func TestConditionals(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestFarJumps(t *testing.T) {
+	// The alternatives are more than 64 KiB long: jumps over them are wide,
+	// and so must be the jumps threaded through them.
+	long := strings.Repeat("x = x + 1;", 8000)
+	tests := []vmTestCase{
+		{"let x = 0; let a = true; let b = true; if (a) { if (b) { 1 } } else { " + long + " 2 }", 1},
+		{"let x = 0; let a = false; if (a) { 1 } else { " + long + " x }", 8000},
+		{"let x = 0; while (x < 2) { if (x == 0) { x++ } else { " + long + " } } x", 8001},
+	}
+
+	runVmTests(t, tests)
+}
+
 func TestWhile(t *testing.T) {
 	tests := []vmTestCase{
 		{"while (false) {10}", Null},
@@ -215,6 +234,8 @@ func TestHashLiterals(t *testing.T) {
 				(&object.Integer{Value: 6}).HashKey(): 16,
 			},
 		},
+		{`sprintf("%s", {"b": 1, 3: 2, "a": 3})`, "{b: 1, 3: 2, a: 3}"},
+		{`sprintf("%s", {"b": 1, "a": 2, "b": 3})`, "{b: 3, a: 2}"},
 	}
 
 	runVmTests(t, tests)
@@ -229,6 +250,8 @@ func TestIndexExpressions(t *testing.T) {
 		{"[1, 2, 3][99]", Null},
 		{"[1][-1]", Null},
 		{"{1: 1, 2: 2}[1]", 1},
+		{`{"a": 1, true: 2}["a"]`, 1},
+		{`{"a": 1, true: 2}[true]`, 2},
 		{"{1: 1, 2: 2}[2]", 2},
 		{"{1: 1}[0]", Null},
 		{"{}[0]", Null},
@@ -237,6 +260,79 @@ func TestIndexExpressions(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestEquality(t *testing.T) {
+	tests := []vmTestCase{
+		{`"gold" == "go" + "ld"`, true},
+		{`let a = "gold"; let b = "go"; a != b + "ld"`, false},
+		{"[1, [2, 3]] == [1, [2, 3]]", true},
+		{"[1, [2, 3]] == [1, [3, 2]]", false},
+		{"[1, 2] == [1, 2, 3]", false},
+		{"[1, 2.0] == [1.0, 2]", true},
+		{`{"a": 1, "b": [2]} == {"b": [2], "a": 1}`, true},
+		{`{"a": 1} == {"a": 2}`, false},
+		{`{"a": 1} != {"a": 1, "b": 2}`, true},
+		{"tuple(1, [2]) == tuple(1, [2])", true},
+		{"tuple(1, 2) == [1, 2]", false},
+		{"[null] == [null]", true},
+		{"[sqrt(-1.0)] == [sqrt(-1.0)]", false},
+		{"[len] == [len]", true},
+		{"[len] == [first]", false},
+		// Numbers compare exactly, as in arrays and hash keys
+		{"9007199254740993 == 9007199254740992.0", false},
+		{"9007199254740993 != 9007199254740992.0", true},
+		{"let a = 9007199254740993; let b = 9007199254740992.0; a == b", false},
+		{"let a = 9007199254740993; let b = 9007199254740992.0; [a] == [b]", false},
+		{"9007199254740992 == 9007199254740992.0", true},
+		{"let a = 2; let b = 2.0; if (a != b) { 1 } else { 2 }", 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestHashKeys(t *testing.T) {
+	tests := []vmTestCase{
+		{"{1.5: 1, 1.9: 2}[1.5]", 1},
+		{"{1.5: 1, 1.9: 2}[1.9]", 2},
+		{`sprintf("%s", {1.5: 1, 1.9: 2})`, "{1.500000: 1, 1.900000: 2}"},
+		{"{1: 1}[1.0]", 1},
+		{"{0.0: 1}[-0.0]", 1},
+		{"{sqrt(-1.0): 1}[sqrt(-1.0)]", Null},
+		{"{tuple(1, 2): 3}[tuple(1, 2)]", 3},
+		{"{tuple(1, 2): 3}[tuple(2, 1)]", Null},
+		{"{tuple(1, tuple(2.0)): 3}[tuple(1.0, tuple(2))]", 3},
+		{`sprintf("%s", {1: "a", 1.0: "b"})`, "{1: b}"},
+		{"tuple(1, 2)[1]", 2},
+		{"tuple()[0]", Null},
+		{"len(tuple(1, 2, 3))", 3},
+		{`sprintf("%s", tuple(1))`, "(1,)"},
+		{`sprintf("%s", tuple(1, "a"))`, "(1, a)"},
+	}
+
+	runVmTests(t, tests)
+
+	errors := []struct {
+		input    string
+		expected string
+	}{
+		{"{[1]: 2}", "unusable as hash key: ARRAY"},
+		{"{tuple(1, {}): 2}", "unusable as hash key: HASH in TUPLE"},
+		{"{1: 2}[tuple([1])]", "unusable as hash key: ARRAY in TUPLE"},
+	}
+
+	for _, tt := range errors {
+		comp := compiler.New()
+		_, err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		err = New(comp.Bytecode()).Run()
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+}
+
 func TestCallingFunctionsWithoutArguments(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -476,6 +572,137 @@ func TestBuiltinFunctions(t *testing.T) {
 	runVmTests(t, tests)
 }
 
+func TestStringBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`split("a,b,c", ",")`, []string{"a", "b", "c"}},
+		{`split("ab", "")`, []string{"a", "b"}},
+		{`join(["a", "b"], ", ")`, "a, b"},
+		{`join([1, 2.5, true], "-")`, "1-2.500000-true"},
+		{`trim("  gold  ")`, "gold"},
+		{`upper("Gold")`, "GOLD"},
+		{`lower("Gold")`, "gold"},
+		{`contains("golden", "old")`, true},
+		{`contains("gold", "silver")`, false},
+		{`contains("gold", "g") == true`, true},
+		{`index_of("golden", "den")`, 3},
+		{`index_of("gold", "x")`, -1},
+		{`replace("a-b-c", "-", "+")`, "a+b+c"},
+		{`starts_with("gold", "go")`, true},
+		{`ends_with("gold", "go")`, false},
+		{`repeat("ab", 3)`, "ababab"},
+		{`repeat("ab", -1)`, &object.Error{Message: "negative count for `repeat`: -1"}},
+		{`repeat("ab", 4611686018427387904)`, &object.Error{Message: "result of `repeat` is too large"}},
+		{`repeat("", 4611686018427387904)`, ""},
+		{`format("{} + {} = {}", 1, 2.5, "x")`, "1 + 2.500000 = x"},
+		{`format("{{}} {}", null)`, "{} null"},
+		{`format("none")`, "none"},
+		{`format("{} {}", 1)`, &object.Error{Message: "`format` has more {} than the 1 arguments"}},
+		{`format("{}", 1, 2)`, &object.Error{Message: "`format` has 1 {} but 2 arguments"}},
+		{`to_str(12)`, "12"},
+		{`to_str("s")`, "s"},
+		{`to_str([1, "a"])`, "[1, a]"},
+		{`parse_int("42")`, 42},
+		{`parse_int("-7")`, -7},
+		{`parse_int("4.2")`, Null},
+		{`parse_int("")`, Null},
+		{`parse_float("4.5")`, 4.5},
+		{`parse_float("x")`, Null},
+		{`may n = parse_int("10"); if (n != null) { 1 }`, 1},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestMathBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`abs(-3)`, 3},
+		{`abs(-2.5)`, 2.5},
+		{`min(3, 1, 2)`, 1},
+		{`max(3, 1, 2)`, 3},
+		{`min(3, 1.5)`, 1.5},
+		{`max(3, 1.5)`, 3.0},
+		{`min(4)`, 4},
+		{`floor(2.7)`, 2.0},
+		{`floor(-2.5)`, -3.0},
+		{`ceil(2.1)`, 3.0},
+		{`round(2.5)`, 3.0},
+		{`round(7)`, 7},
+		{`pow(2, 10)`, 1024},
+		{`pow(2.0, 0.5) * pow(2.0, 0.5)`, 2.0000000000000004},
+		{`pow(3, 0)`, 1},
+		{`pow(2, -1)`, &object.Error{Message: "negative exponent for integers in `pow`: -1"}},
+		{`sqrt(16)`, 4.0},
+		{`sin(0)`, 0.0},
+		{`cos(0)`, 1.0},
+		{`tan(0.0)`, 0.0},
+		{`exp(0)`, 1.0},
+		{`log(exp(2))`, 2.0},
+		{`pi() > 3.14`, true},
+		{`pi() < 3.15`, true},
+		{`int(2.9)`, 2},
+		{`int(-2.9)`, -2},
+		{`int(5)`, 5},
+		{`float(5)`, 5.0},
+		{`float(5) / 2`, 2.5},
+		{`int(float(7) / 2) + 1`, 4},
+		// Overflow wraps around, NaN and infinities follow IEEE 754
+		{`abs(-9223372036854775807 - 1)`, -9223372036854775807 - 1},
+		{`pow(2, 63)`, -9223372036854775807 - 1},
+		{`pow(2, 64)`, 0},
+		{`to_str(sqrt(-1))`, "NaN"},
+		{`to_str(log(0))`, "-Inf"},
+		{`to_str(exp(1000))`, "+Inf"},
+		{`max(1.0, sqrt(-1)) == max(1.0, sqrt(-1))`, false},
+		{`int(sqrt(-1))`, &object.Error{Message: "NaN is out of the range of integers"}},
+		{`int(exp(1000))`, &object.Error{Message: "+Inf is out of the range of integers"}},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestHigherOrderBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`map([1, 2, 3], fn(lint x) { return x * 2 })`, []int{2, 4, 6}},
+		{`map([], fn(lint x) { return x })`, []int{}},
+		{`map(["a", "bc"], len)`, []int{1, 2}},
+		{`let n = 10; map([1, 2], fn(lint x) { return x + n })`, []int{11, 12}},
+		{`filter([1, 2, 3, 4], fn(lint x) { return x > 2 })`, []int{3, 4}},
+		{`reduce([1, 2, 3, 4], fn(lint sum, lint x) { return sum + x }, 0)`, 10},
+		{`reduce([], fn(lint sum, lint x) { return sum + x }, 5)`, 5},
+		{`sort([3, 1, 2], fn(lint a, lint b) { return a < b })`, []int{1, 2, 3}},
+		{`sort([3, 1, 2], fn(lint a, lint b) { return a > b })`, []int{3, 2, 1}},
+		{
+			// Callbacks calling builtins calling callbacks
+			`let nested = [[3, 1], [2]];
+			let sorted = map(nested, fn(larr xs) { return sort(xs, fn(lint a, lint b) { return a < b }) });
+			reduce(sorted, fn(lint total, larr xs) { return total + reduce(xs, fn(lint s, lint x) { return s + x }, 0) }, 0)`,
+			6,
+		},
+		{
+			// The frames and the stack are back to the caller after a callback
+			`let add = fn(lint a) { return map([a], fn(lint x) { return x + 1 })[0] + 1 }; add(1) + add(2)`,
+			7,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestCallbackErrors(t *testing.T) {
+	input := `lint deep = fn(lint x) { return 1 + deep(x + 1) }; map([1], deep)`
+
+	comp := compiler.New()
+	_, err := comp.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	err = New(comp.Bytecode()).Run()
+	var overflow *StackOverflowError
+	if !errors.As(err, &overflow) {
+		t.Fatalf("expected a stack overflow from the callback, got=%v", err)
+	}
+}
+
 func TestClosures(t *testing.T) {
 	tests := []vmTestCase{
 		{
@@ -640,24 +867,29 @@ type vmTestCase struct {
 func runVmTests(t *testing.T, tests []vmTestCase) {
 	t.Helper()
 
+	// Optimizations must not change the result of any program
+	levels := []int{compiler.OptimizationNone, compiler.OptimizationConstants, compiler.OptimizationPeephole}
 	for _, tt := range tests {
-		program := parse(tt.input)
+		for _, level := range levels {
+			program := parse(tt.input)
 
-		comp := compiler.New()
-		_, err := comp.Compile(program)
-		if err != nil {
-			t.Fatalf("compiler error: %s", err)
-		}
+			comp := compiler.New()
+			comp.SetOptimization(level)
+			_, err := comp.Compile(program)
+			if err != nil {
+				t.Fatalf("compiler error: %s", err)
+			}
 
-		vm := New(comp.Bytecode())
-		err = vm.Run()
-		if err != nil {
-			t.Fatalf("vm error: %s", err)
-		}
+			vm := New(comp.Bytecode())
+			err = vm.Run()
+			if err != nil {
+				t.Fatalf("vm error at optimization level %d: %s", level, err)
+			}
 
-		stackElem := vm.LastPoppedStackElem()
+			stackElem := vm.LastPoppedStackElem()
 
-		testExpectedObject(t, tt.expected, stackElem)
+			testExpectedObject(t, tt.expected, stackElem)
+		}
 	}
 }
 
@@ -704,6 +936,26 @@ func testExpectedObject(
 			t.Errorf("testStringObject failed: %s", err)
 		}
 
+	case []string:
+		array, ok := actual.(*object.Array)
+		if !ok {
+			t.Errorf("object not Array: %T (%+v)", actual, actual)
+			return
+		}
+
+		if len(array.Elements) != len(expected) {
+			t.Errorf("wrong num of elements. want=%d, got=%d",
+				len(expected), len(array.Elements))
+			return
+		}
+
+		for i, expectedElem := range expected {
+			err := testStringObject(expectedElem, array.Elements[i])
+			if err != nil {
+				t.Errorf("testStringObject failed: %s", err)
+			}
+		}
+
 	case []int:
 		array, ok := actual.(*object.Array)
 		if !ok {
@@ -731,19 +983,25 @@ func testExpectedObject(
 			return
 		}
 
-		if len(hash.Pairs) != len(expected) {
+		if hash.Len() != len(expected) {
 			t.Errorf("hash has wrong number of Pairs. want=%d, got=%d",
-				len(expected), len(hash.Pairs))
+				len(expected), hash.Len())
 			return
 		}
 
-		for expectedKey, expectedValue := range expected {
-			pair, ok := hash.Pairs[expectedKey]
+		for _, pair := range hash.Pairs() {
+			key, err := object.HashKeyOf(pair.Key)
+			if err != nil {
+				t.Errorf("unusable key in Pairs: %s", err)
+				continue
+			}
+			expectedValue, ok := expected[key]
 			if !ok {
-				t.Errorf("no pair for given key in Pairs")
+				t.Errorf("unexpected key in Pairs: %s", pair.Key.Inspect())
+				continue
 			}
 
-			err := testIntegerObject(expectedValue, pair.Value)
+			err = testIntegerObject(expectedValue, pair.Value)
 			if err != nil {
 				t.Errorf("testIntegerObject failed: %s", err)
 			}
@@ -821,3 +1079,540 @@ func testStringObject(expected string, actual object.Object) error {
 
 	return nil
 }
+
+func TestWideOperands(t *testing.T) {
+	var constants strings.Builder
+	for i := 0; i <= 65536; i++ {
+		fmt.Fprintf(&constants, "%d;", i)
+	}
+
+	var globals strings.Builder
+	for i := 0; i <= 65536; i++ {
+		fmt.Fprintf(&globals, "let %s = %d;", letters(i), i)
+	}
+	fmt.Fprintf(&globals, "%s = %s + 1; %s", letters(65536), letters(65535), letters(65536))
+
+	var locals strings.Builder
+	locals.WriteString("let f = fn() {")
+	for i := 0; i <= 300; i++ {
+		fmt.Fprintf(&locals, "let %s = %d;", letters(i), i)
+	}
+	fmt.Fprintf(&locals, "return %s + %s }; f()", letters(255), letters(300))
+
+	statements := strings.Repeat("1;", 20000)
+
+	tests := []vmTestCase{
+		{constants.String(), 65536},
+		{globals.String(), 65536},
+		{locals.String(), 555},
+		{"if (false) {" + statements + "} else { 42 }", 42},
+		{"if (true) {" + statements + "7 }", 7},
+		{statements + "let i = 0; while (i < 3) { i++ }; i", 3},
+	}
+
+	runVmTests(t, tests)
+}
+
+// letters spells n with letters only, to be used as an identifier.
+func letters(n int) string {
+	name := ""
+	for {
+		name = string(rune('a'+n%26)) + name
+		n /= 26
+		if n == 0 {
+			return "v" + name
+		}
+	}
+}
+
+func TestSuperinstructions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let x = 0; x++; x++; x--; x", 1},
+		{"let x = 1.5; x++; x", 2.5},
+		{"let x = 0; while (x++ < 5) { }; x", 6},
+		{"let x = 10; while (x-- >= 3) { }; x", 1},
+		{"let x = 3; let n = 0; while (x != 0) { x--; n++ }; n", 3},
+		{"let x = 3; if (x == 3) { 1 } else { 2 }", 1},
+		{"let x = 1.5; if (x > 1) { 1 } else { 2 }", 1},
+		{`may count = fn(mint n) {
+			mint i = 0;
+			mint total = 0;
+			while (i < n) { total++; i++ };
+			while (i > 0) { i--; total-- };
+			return total + i;
+		};
+		count(40)`, 0},
+		// Beyond the shared small integers
+		{"let x = 5000; x++; x == 5001", true},
+		{"let x = -500; x--; x", -501},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			input: `
+			may count = fn(mint n, mint total) {
+				if (n == 0) {
+					return total;
+				} else {
+					return count(n - 1, total + 2);
+				}
+			};
+			count(100000, 0);`,
+			expected: 200000,
+		},
+		{
+			input: `
+			may outer = fn(mint n) {
+				may loop = fn(mint i) {
+					mint twice = i * 2;
+					if (i == n) {
+						return twice;
+					} else {
+						return loop(i + 1);
+					}
+				};
+				return loop(0);
+			};
+			outer(5000);`,
+			expected: 10000,
+		},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestStackOverflow(t *testing.T) {
+	inputs := []string{
+		// Too many frames
+		`may depth = fn(mint n) {
+			if (n == 0) {
+				return 0;
+			} else {
+				return 1 + depth(n - 1);
+			}
+		};
+		depth(100000);`,
+		// Too many values on the stack
+		`may depth = fn(mint n, mint a, mint b, mint c) {
+			if (n == 0) {
+				return 0;
+			} else {
+				return 1 + depth(n - 1, a, b, c);
+			}
+		};
+		depth(100000, 1, 2, 3);`,
+	}
+
+	for _, input := range inputs {
+		comp := compiler.New()
+		_, err := comp.Compile(parse(input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		err = New(comp.Bytecode()).Run()
+		var overflow *StackOverflowError
+		if !errors.As(err, &overflow) {
+			t.Fatalf("expected a *StackOverflowError, got=%v", err)
+		}
+		if overflow.Trace[0] != "depth" || overflow.Trace[len(overflow.Trace)-1] != "<main>" {
+			t.Errorf("wrong trace. got=%v", overflow.Trace)
+		}
+		if !strings.HasPrefix(err.Error(), "stack overflow\n\tin depth (") || !strings.HasSuffix(err.Error(), " calls)\n\tin <main>") {
+			t.Errorf("wrong message. got=%q", err.Error())
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		config   Config
+		input    string
+		expected string
+	}{
+		{Config{MaxInstructions: 100}, "while (true) { }", "limit exceeded: MaxInstructions is 100"},
+		{Config{MaxAllocation: 3}, "[1, 2, 3, 4]", "limit exceeded: MaxAllocation is 3"},
+		{Config{MaxAllocation: 1}, "{1: 2, 3: 4}", "limit exceeded: MaxAllocation is 1"},
+		{Config{MaxAllocation: 10}, `let s = "ab"; while (true) { s = s + s }`, "limit exceeded: MaxAllocation is 10"},
+		{Config{MaxAllocation: 2}, "push([1, 2], 3)", "limit exceeded: MaxAllocation is 2"},
+		{Config{MaxFrames: 10}, "may f = fn() { return 1 + f() }; f()", "stack overflow\n\tin f (9 calls)\n\tin <main>"},
+		{Config{StackSize: 4}, "[1, 2, 3, 4, 5]", "stack overflow\n\tin <main>"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		_, err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		err = NewWithConfig(comp.Bytecode(), tt.config).Run()
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong error for %q. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+
+	// Within the limits, programs run as usual
+	comp := compiler.New()
+	_, err := comp.Compile(parse("let x = 0; while (x < 10) { x++ }; [x, x]"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	machine := NewWithConfig(comp.Bytecode(), Config{MaxInstructions: 200, MaxAllocation: 2})
+	err = machine.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, []int{10, 10}, machine.LastPoppedStackElem())
+}
+
+func TestRunContext(t *testing.T) {
+	comp := compiler.New()
+	_, err := comp.Compile(parse("while (true) { }"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = New(comp.Bytecode()).RunContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to stop the program, got=%v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	err = New(comp.Bytecode()).RunContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the cancellation to stop the program, got=%v", err)
+	}
+}
+
+func TestProcessBuiltins(t *testing.T) {
+	environment := map[string]string{"HOME": "/home/gold"}
+	config := Config{
+		Args: []string{"-v", "input.txt"},
+		Getenv: func(name string) (string, bool) {
+			value, ok := environment[name]
+			return value, ok
+		},
+	}
+	tests := []struct {
+		config   Config
+		input    string
+		expected interface{}
+		exitCode int
+		exited   bool
+	}{
+		{config, "args()", []string{"-v", "input.txt"}, 0, false},
+		{Config{}, "len(args())", 0, 0, false},
+		{config, `env("HOME")`, "/home/gold", 0, false},
+		{config, `env("PATH")`, Null, 0, false},
+		{Config{}, `env("HOME")`, Null, 0, false},
+		{config, "exit(3); 1", 0, 3, true},
+		{config, "lint x = 1; exit(0); x = 2", 0, 0, true},
+		{config, "may stop = fn(lint x) { return exit(x) }; stop(4); 6", 0, 4, true},
+		{config, "exit(256)", &object.Error{Message: "exit status must be between 0 and 255, got 256"}, 0, false},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		_, err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error for %q: %s", tt.input, err)
+		}
+
+		machine := NewWithConfig(comp.Bytecode(), tt.config)
+		err = machine.Run()
+		if err != nil {
+			t.Fatalf("vm error for %q: %s", tt.input, err)
+		}
+		code, exited := machine.ExitCode()
+		if exited != tt.exited || code != tt.exitCode {
+			t.Errorf("wrong exit for %q. want=%d, %t, got=%d, %t", tt.input, tt.exitCode, tt.exited, code, exited)
+		}
+		if !tt.exited {
+			testExpectedObject(t, tt.expected, machine.LastPoppedStackElem())
+		}
+	}
+}
+
+func TestBuiltinAllocation(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "big.txt")
+	err := os.WriteFile(file, []byte(strings.Repeat("x", 100000)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Going over the limit stops the program before the result is built
+	limited := Config{MaxAllocation: 1000, Files: &object.FilePolicy{}}
+	limit := &LimitError{Limit: "MaxAllocation", Value: 1000}
+	tests := []struct {
+		config   Config
+		input    string
+		expected interface{}
+	}{
+		{limited, `join(["", "", ""], "` + strings.Repeat("-", 600) + `")`, limit},
+		{limited, `join(["a", "b"], "-")`, "a-b"},
+		{limited, `repeat("ab", 501)`, limit},
+		{limited, `repeat("ab", 500)`, strings.Repeat("ab", 500)},
+		{limited, `replace("` + strings.Repeat("a", 100) + `", "a", "` + strings.Repeat("b", 20) + `")`, limit},
+		{limited, `replace("abc", "b", "xyz")`, "axyzc"},
+		{limited, `sprintf("%999999d", 1)`, limit},
+		{limited, `sprintf("%*d", 5000, 1)`, limit},
+		{limited, `printf("%999999d", 1)`, limit},
+		{limited, `sprintf("%s%s", "` + strings.Repeat("-", 600) + `", "` + strings.Repeat("-", 600) + `")`, limit},
+		{limited, `sprintf("%5.1f|%q", 1.25, "a")`, "  1.2|\"a\""},
+		{limited, `read_file("FILE")`, limit},
+		{limited, `read_lines("FILE")`, limit},
+		{limited, `read_file("FILE.missing"); last_error() == null`, false},
+		{Config{Files: &object.FilePolicy{}}, `read_file("FILE") == null`, false},
+	}
+
+	for _, tt := range tests {
+		input := strings.ReplaceAll(tt.input, "FILE", file)
+		comp := compiler.New()
+		_, err := comp.Compile(parse(input))
+		if err != nil {
+			t.Fatalf("compiler error for %q: %s", input, err)
+		}
+		machine := NewWithConfig(comp.Bytecode(), tt.config)
+		err = machine.Run()
+
+		if expected, ok := tt.expected.(*LimitError); ok {
+			var limitErr *LimitError
+			if !errors.As(err, &limitErr) || *limitErr != *expected {
+				t.Errorf("wrong error for %q. want=%q, got=%v", input, expected, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("vm error for %q: %s", input, err)
+		}
+		testExpectedObject(t, tt.expected, machine.LastPoppedStackElem())
+	}
+}
+
+func TestVariadicBuiltinsWithoutArguments(t *testing.T) {
+	// The compiler rejects these calls, assembled bytecode may not
+	for _, name := range []string{"sprintf", "printf", "format"} {
+		machine := New(&compiler.Bytecode{})
+		result, err := machine.Call(object.GetBuiltinByName(name))
+		if err != nil {
+			t.Fatalf("vm error for %s: %s", name, err)
+		}
+		testExpectedObject(t, &object.Error{Message: "wrong number of arguments. got=0, want=at least 1"}, result)
+	}
+}
+
+func TestFileBuiltins(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	outside := filepath.Join(dir, "outside.txt")
+	for name, content := range map[string]string{
+		filepath.Join(root, "notes.txt"):     "one\ntwo\n",
+		filepath.Join(root, "sub", "a.txt"):  "",
+		filepath.Join(root, "sub", "b.gold"): "",
+		outside:                              "secret",
+	} {
+		err := os.MkdirAll(filepath.Dir(name), 0755)
+		if err == nil {
+			err = os.WriteFile(name, []byte(content), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := os.Symlink(outside, filepath.Join(root, "link.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink(filepath.Join(dir, "pwned.txt"), filepath.Join(root, "dangling.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	allowed := &object.FilePolicy{Roots: []string{root}}
+	readOnly := &object.FilePolicy{Roots: []string{root}, ReadOnly: true}
+	tests := []struct {
+		policy   *object.FilePolicy
+		input    string
+		expected interface{}
+	}{
+		{allowed, `read_file("ROOT/notes.txt")`, "one\ntwo\n"},
+		{allowed, `read_lines("ROOT/notes.txt")`, []string{"one", "two"}},
+		{allowed, `read_lines("ROOT/sub/a.txt")`, []string{}},
+		{allowed, `list_dir("ROOT/sub")`, []string{"a.txt", "b.gold"}},
+		{allowed, `exists("ROOT/notes.txt")`, true},
+		{allowed, `exists("ROOT/missing.txt")`, false},
+		{allowed, `write_file("ROOT/new.txt", "a"); append_file("ROOT/new.txt", "b"); read_file("ROOT/new.txt")`, "ab"},
+		{allowed, `last_error()`, Null},
+		{allowed, `read_file("ROOT/missing.txt")`, Null},
+		{allowed, `read_file("ROOT/../outside.txt")`, Null},
+		{allowed, `read_file("ROOT/../outside.txt"); last_error()`, "ROOT/../outside.txt: outside of the allowed directories"},
+		{allowed, `read_file("ROOT/link.txt"); last_error()`, "ROOT/link.txt: outside of the allowed directories"},
+		{allowed, `exists("DIR/outside.txt")`, Null},
+		{allowed, `write_file("ROOT/dangling.txt", "x"); last_error()`, "ROOT/dangling.txt: broken symbolic link"},
+		{allowed, `append_file("ROOT/dangling.txt", "x")`, Null},
+		{&object.FilePolicy{}, `write_file("ROOT/dangling.txt", "x")`, Null},
+		{readOnly, `read_file("ROOT/notes.txt")`, "one\ntwo\n"},
+		{readOnly, `write_file("ROOT/notes.txt", "")`, Null},
+		{readOnly, `write_file("ROOT/notes.txt", ""); last_error()`, "ROOT/notes.txt: file access is read-only"},
+		{&object.FilePolicy{}, `read_file("DIR/outside.txt")`, "secret"},
+		{nil, `read_file("ROOT/notes.txt"); last_error()`, "file access is disabled"},
+	}
+
+	for _, tt := range tests {
+		input := strings.NewReplacer("ROOT", root, "DIR", dir).Replace(tt.input)
+		expected := tt.expected
+		if message, ok := expected.(string); ok {
+			expected = strings.NewReplacer("ROOT", root, "DIR", dir).Replace(message)
+		}
+
+		comp := compiler.New()
+		_, err := comp.Compile(parse(input))
+		if err != nil {
+			t.Fatalf("compiler error for %q: %s", input, err)
+		}
+		machine := NewWithConfig(comp.Bytecode(), Config{Files: tt.policy})
+		err = machine.Run()
+		if err != nil {
+			t.Fatalf("vm error for %q: %s", input, err)
+		}
+		testExpectedObject(t, expected, machine.LastPoppedStackElem())
+	}
+
+	content, err := os.ReadFile(filepath.Join(root, "notes.txt"))
+	if err != nil || string(content) != "one\ntwo\n" {
+		t.Errorf("expected the read-only policy to keep notes.txt, got=%q (%v)", content, err)
+	}
+	_, err = os.Lstat(filepath.Join(dir, "pwned.txt"))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected no write through the broken link, got=%v", err)
+	}
+}
+
+func TestIOBuiltins(t *testing.T) {
+	tests := []struct {
+		input          string
+		stdin          string
+		expected       interface{}
+		expectedStdout string
+		expectedStderr string
+	}{
+		{`print("a"); print(null)`, "", Null, "a\nnull\n", ""},
+		{`eprint("oops")`, "", Null, "", "oops\n"},
+		{`read_line()`, "first\r\nsecond", "first", "", ""},
+		{`read_line(); read_line()`, "first\nsecond", "second", "", ""},
+		{`read_line(); read_line()`, "first\n", Null, "", ""},
+		{`read_line()`, "", Null, "", ""},
+		{`input("name? ")`, "gold\n", "gold", "name? ", ""},
+		{`input()`, "gold", "gold", "", ""},
+		{`sprintf("%5.2f|%-4d|%q|%v|%t", 3.14159, 42, "go", [1, 2], true)`, "", ` 3.14|42  |"go"|[1, 2]|true`, "", ""},
+		{`sprintf("a\tb\\n\n")`, "", "a\tb\\n\n", "", ""},
+		{`sprintf("%d")`, "", "%!d(MISSING)", "", ""},
+		{`printf("%d-%s\n", 1, "x"); printf("end")`, "", Null, "1-x\nend", ""},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		_, err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error for %q: %s", tt.input, err)
+		}
+
+		var stdout, stderr strings.Builder
+		machine := NewWithConfig(comp.Bytecode(), Config{Stdin: strings.NewReader(tt.stdin), Stdout: &stdout, Stderr: &stderr})
+		err = machine.Run()
+		if err != nil {
+			t.Fatalf("vm error for %q: %s", tt.input, err)
+		}
+		testExpectedObject(t, tt.expected, machine.LastPoppedStackElem())
+		if stdout.String() != tt.expectedStdout {
+			t.Errorf("wrong stdout for %q. want=%q, got=%q", tt.input, tt.expectedStdout, stdout.String())
+		}
+		if stderr.String() != tt.expectedStderr {
+			t.Errorf("wrong stderr for %q. want=%q, got=%q", tt.input, tt.expectedStderr, stderr.String())
+		}
+	}
+}
+
+func TestJSONBuiltins(t *testing.T) {
+	tests := []struct {
+		document string
+		input    string
+		expected interface{}
+	}{
+		{`{"b": [1, 2.5, true, null, 1e3, -7], "a": {"x": "<y>"}}`, "json_stringify(json_parse(args()[0]), 0)",
+			`{"b":[1,2.5,true,null,1000.0,-7],"a":{"x":"<y>"}}`},
+		{`{"b": [1], "a": {}}`, "json_stringify(json_parse(args()[0]), 2)", "{\n  \"b\": [\n    1\n  ],\n  \"a\": {}\n}"},
+		{`{"name": "gold", "tags": ["a", "b"]}`, `json_parse(args()[0])["tags"][1]`, "b"},
+		{`{"size": 9223372036854775807}`, `json_parse(args()[0])["size"]`, 9223372036854775807},
+		{`{"size": 9223372036854775808}`, `json_parse(args()[0])["size"]`, 9223372036854775808.0},
+		{`"caf\u00e9"`, "json_parse(args()[0])", "café"},
+		{`[]`, "json_parse(args()[0])", []int{}},
+		{`null`, "json_parse(args()[0])", Null},
+		{`{"a": }`, "json_parse(args()[0])", Null},
+		{`{"a": }`, "json_parse(args()[0]); last_error()", "json_parse: missing value after object key"},
+		{`[1] [2]`, "json_parse(args()[0]); last_error()", "json_parse: unexpected data after the value"},
+		{`[1, 2`, "json_parse(args()[0]); last_error()", "json_parse: unexpected end of JSON input"},
+		{``, "json_parse(args()[0]); last_error()", "json_parse: unexpected EOF"},
+		{strings.Repeat("[", 600) + strings.Repeat("]", 600), "json_parse(args()[0]); last_error()", "json_parse: the document is nested too deeply"},
+		{``, `json_parse(read_file("missing.json"))`, Null},
+		{``, `json_stringify({1: 2.0, true: "x", "s": [1.5]}, 0)`, `{"1":2.0,"true":"x","s":[1.5]}`},
+		{``, `json_stringify(null, 0)`, "null"},
+		{``, `json_stringify([1, len], 0)`, Null},
+		{``, `json_stringify([1, len], 0); last_error()`, "json_stringify: BUILTIN can't be represented in JSON"},
+		{``, `json_stringify(fn() { return 1 }, 0); last_error()`, "json_stringify: CLOSURE can't be represented in JSON"},
+		{``, `json_stringify(sqrt(-1.0), 0); last_error()`, "json_stringify: NaN can't be represented in JSON"},
+		{``, `json_stringify({1.5: 1}, 0); last_error()`, "json_stringify: FLOAT keys can't be represented in JSON"},
+		{``, `json_stringify(1, -1)`, &object.Error{Message: "indent must be between 0 and 16, got -1"}},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		_, err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error for %q: %s", tt.input, err)
+		}
+		machine := NewWithConfig(comp.Bytecode(), Config{Args: []string{tt.document}})
+		err = machine.Run()
+		if err != nil {
+			t.Fatalf("vm error for %q: %s", tt.input, err)
+		}
+		testExpectedObject(t, tt.expected, machine.LastPoppedStackElem())
+	}
+}
+
+func TestImports(t *testing.T) {
+	modules := compiler.MapResolver{
+		"lib/math.gold":  "let _scale = 10; let scaled = fn(lint x) { return x * _scale }; lint loaded = 0; loaded = loaded + 1",
+		"lib/twice.gold": `import "math"; let twice = fn(lint x) { return scaled(scaled(x)) }`,
+	}
+	tests := []vmTestCase{
+		{`import "lib/math"; scaled(2)`, 20},
+		{`import "lib/twice"; twice(1)`, 100},
+		// A module imported twice runs once and shares its globals
+		{`import "lib/math"; import "lib/twice"; [scaled(2), twice(1), loaded]`, []int{20, 100, 1}},
+		{`import "lib/twice"; import "lib/math"; loaded = loaded + 1; loaded`, 2},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		comp.SetResolver(modules, "main.gold")
+		_, err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error for %q: %s", tt.input, err)
+		}
+		machine := New(comp.Bytecode())
+		err = machine.Run()
+		if err != nil {
+			t.Fatalf("vm error for %q: %s", tt.input, err)
+		}
+		testExpectedObject(t, tt.expected, machine.LastPoppedStackElem())
+	}
+}